@@ -23,8 +23,7 @@ type Cache struct {
 }
 
 func (c *Cache) SetEX(key string, val interface{}, timeout time.Duration) error {
-	//TODO implement me
-	panic("implement me")
+	return c.Set(key, val, timeout)
 }
 
 func (c *Cache) BRPopLPush(source string, destination string, timeout time.Duration) string {
@@ -47,9 +46,15 @@ func (c *Cache) Scan(cursor uint64, match string, count int64) ([]string, uint64
 	panic("implement me")
 }
 
+// SetNX 不存在时设置，返回是否设置成功
 func (c *Cache) SetNX(key string, value interface{}, expiration time.Duration) bool {
-	//TODO implement me
-	panic("implement me")
+	c.Lock()
+	defer c.Unlock()
+	if _, found := c.get(key); found {
+		return false
+	}
+	c.set(key, value, expiration)
+	return true
 }
 
 func (c *Cache) LRange(key string, start int64, stop int64) []string {
@@ -0,0 +1,163 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/utils/cache"
+)
+
+var (
+	// ErrPublishFailed 消息发布失败
+	ErrPublishFailed = errors.New("queue: publish failed")
+	// ErrDelayNotSupported 队列不支持延迟消息
+	ErrDelayNotSupported = errors.New("queue: delay not supported")
+	// ErrUnknownBroker 未注册的队列实现
+	ErrUnknownBroker = errors.New("queue: unknown broker")
+)
+
+// Producer 生产者
+type Producer interface {
+	// Topic 消息主题
+	Topic() string
+
+	// Publish 发布消息，返回队列内的消息编号
+	Publish(ctx context.Context, msgs ...*Message) ([]string, error)
+}
+
+// Consumer 消费者
+// Receive 之后、Ack 之前消费者退出时，stream 和 reliable 队列会重新投递消息；
+// delay 队列获取时已删除消息，最多投递一次，处理失败时需调用 Nack 放回
+type Consumer interface {
+	// Topic 消息主题
+	Topic() string
+
+	// Receive 消费获取一批，timeout 内没有消息时返回空
+	Receive(ctx context.Context, count int64, timeout time.Duration) ([]*Message, error)
+
+	// Ack 确认消费
+	Ack(ctx context.Context, msgs ...*Message) error
+
+	// Nack 拒绝消费，消息将被重新投递
+	Nack(ctx context.Context, msgs ...*Message) error
+}
+
+// Broker 队列实现，按主题创建生产者和消费者
+type Broker interface {
+	// Producer 创建生产者
+	Producer(topic string) Producer
+
+	// Consumer 创建消费者，group 为空时独立消费
+	Consumer(topic string, group string) Consumer
+}
+
+// BrokerFunc 队列实现构造函数
+type BrokerFunc func(client cache.ICache, logger glog.ILogger) Broker
+
+var (
+	brokersMu sync.RWMutex
+	brokers   = make(map[string]BrokerFunc)
+)
+
+// Register 注册队列实现，一般在实现包的 init 中调用
+func Register(name string, fn BrokerFunc) {
+	brokersMu.Lock()
+	defer brokersMu.Unlock()
+	if fn == nil {
+		panic("queue: Register broker is nil")
+	}
+	if _, dup := brokers[name]; dup {
+		panic("queue: Register called twice for broker " + name)
+	}
+	brokers[name] = fn
+}
+
+// Brokers 已注册的队列实现名称
+func Brokers() []string {
+	brokersMu.RLock()
+	defer brokersMu.RUnlock()
+	list := make([]string, 0, len(brokers))
+	for name := range brokers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// NewBroker 按名称创建队列实现，便于通过配置切换 stream、reliable、delay、memory
+func NewBroker(name string, client cache.ICache, logger glog.ILogger) (Broker, error) {
+	brokersMu.RLock()
+	fn, ok := brokers[name]
+	brokersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBroker, name)
+	}
+	return fn(client, logger), nil
+}
+
+// Handler 消息处理函数，返回nil确认消费，返回错误则拒绝消费等待重新投递
+type Handler func(ctx context.Context, msg *Message) error
+
+// Middleware 消息处理中间件
+type Middleware func(next Handler) Handler
+
+// ConsumeTimeout 消费大循环每次阻塞等待的时间
+var ConsumeTimeout = 15 * time.Second
+
+// ConsumeLogger 消费大循环和中间件的日志，Ack、Nack 和去重标记失败时输出，为空时使用标准库 log
+var ConsumeLogger glog.ILogger
+
+// Consume 消费大循环，阻塞直到 ctx 结束。处理成功自动 Ack，失败自动 Nack
+func Consume(ctx context.Context, consumer Consumer, handler Handler, middlewares ...Middleware) error {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		msgs, err := consumer.Receive(ctx, 1, ConsumeTimeout)
+		if err != nil || len(msgs) == 0 {
+			// 没有消息，歇一会
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, msg := range msgs {
+			if err = handler(ctx, msg); err != nil {
+				if err = consumer.Nack(ctx, msg); err != nil {
+					consumeError("Nack", consumer, msg, err)
+				}
+				continue
+			}
+			if err = consumer.Ack(ctx, msg); err != nil {
+				consumeError("Ack", consumer, msg, err)
+			}
+		}
+	}
+}
+
+// consumeError 输出 Ack、Nack 失败的日志
+func consumeError(action string, consumer Consumer, msg *Message, err error) {
+	logError(fmt.Sprintf("queue: %s %s %s: %v", action, consumer.Topic(), msg.Id, err))
+}
+
+// logError 输出到 ConsumeLogger，为空时使用标准库 log
+func logError(text string) {
+	if ConsumeLogger != nil {
+		ConsumeLogger.Error(text)
+		return
+	}
+	log.Println(text)
+}
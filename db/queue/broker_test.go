@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingConsumer 每次返回一条消息，Ack 和 Nack 总是失败
+type failingConsumer struct {
+	msgs chan *Message
+}
+
+func (c *failingConsumer) Topic() string { return "topic" }
+
+func (c *failingConsumer) Receive(ctx context.Context, count int64, timeout time.Duration) ([]*Message, error) {
+	select {
+	case msg := <-c.msgs:
+		return []*Message{msg}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *failingConsumer) Ack(ctx context.Context, msgs ...*Message) error {
+	return errors.New("ack failed")
+}
+
+func (c *failingConsumer) Nack(ctx context.Context, msgs ...*Message) error {
+	return errors.New("nack failed")
+}
+
+// syncBuffer 并发安全的日志输出
+type syncBuffer struct {
+	locker sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	return b.buf.String()
+}
+
+func TestConsumeLogsAckErrors(t *testing.T) {
+	out, writer := &syncBuffer{}, log.Writer()
+	log.SetOutput(out)
+	defer log.SetOutput(writer)
+
+	consumer := &failingConsumer{msgs: make(chan *Message, 2)}
+	consumer.msgs <- &Message{Id: "1"}
+	consumer.msgs <- &Message{Id: "2"}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Consume(ctx, consumer, func(ctx context.Context, msg *Message) error {
+			if msg.Id == "2" {
+				return errors.New("handler failed")
+			}
+			return nil
+		})
+	}()
+	assert.Eventually(t, func() bool {
+		text := out.String()
+		return strings.Contains(text, "queue: Ack topic 1: ack failed") &&
+			strings.Contains(text, "queue: Nack topic 2: nack failed")
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
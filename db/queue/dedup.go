@@ -0,0 +1,191 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/go-redis/redis/v8"
+)
+
+// HeaderIdempotencyKey 业务指定的幂等键消息头，未设置时使用消息Id，没有Id的原始消息使用消息体的哈希
+const HeaderIdempotencyKey = "idempotency-key"
+
+// ErrDuplicateInFlight 相同幂等键的消息正在被其它消费者处理
+var ErrDuplicateInFlight = errors.New("queue: duplicate message in flight")
+
+const (
+	dedupProcessing = "processing"
+	dedupDone       = "done"
+)
+
+type dedupConfig struct {
+	db        int
+	prefix    string
+	retention time.Duration
+	lockTime  time.Duration
+	keyFunc   func(msg *Message) string
+}
+
+// DedupOption 去重配置
+type DedupOption func(*dedupConfig)
+
+// WithDedupDB redis DB 默认为 0
+func WithDedupDB(db int) DedupOption {
+	return func(cfg *dedupConfig) {
+		cfg.db = db
+	}
+}
+
+// WithDedupPrefix 去重记录key前缀。默认 Dedup
+func WithDedupPrefix(prefix string) DedupOption {
+	return func(cfg *dedupConfig) {
+		cfg.prefix = prefix
+	}
+}
+
+// WithDedupRetention 处理成功记录的保留时间，超过后相同消息会被再次处理。默认24小时
+func WithDedupRetention(retention time.Duration) DedupOption {
+	return func(cfg *dedupConfig) {
+		if retention > 0 {
+			cfg.retention = retention
+		}
+	}
+}
+
+// WithDedupLockTime 处理中标记的过期时间，消费者中途退出后其它消费者可在过期后重新处理。默认5分钟
+func WithDedupLockTime(lockTime time.Duration) DedupOption {
+	return func(cfg *dedupConfig) {
+		if lockTime > 0 {
+			cfg.lockTime = lockTime
+		}
+	}
+}
+
+// WithDedupKey 自定义幂等键，返回空时不去重
+func WithDedupKey(fn func(msg *Message) string) DedupOption {
+	return func(cfg *dedupConfig) {
+		if fn != nil {
+			cfg.keyFunc = fn
+		}
+	}
+}
+
+// Deduplicator 基于缓存的消费去重
+type Deduplicator struct {
+	client cache.ICache
+	cfg    *dedupConfig
+}
+
+// NewDeduplicator 创建消费去重
+func NewDeduplicator(client cache.ICache, opts ...DedupOption) *Deduplicator {
+	cfg := &dedupConfig{
+		prefix:    "Dedup",
+		retention: 24 * time.Hour,
+		lockTime:  5 * time.Minute,
+		keyFunc:   idempotencyKey,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return &Deduplicator{client: client, cfg: cfg}
+}
+
+func idempotencyKey(msg *Message) string {
+	if key := msg.GetHeader(HeaderIdempotencyKey); len(key) > 0 {
+		return key
+	}
+	if len(msg.Id) > 0 {
+		return msg.Id
+	}
+	// 旧的生产者写入的原始消息没有Id
+	sum := sha256.Sum256(msg.Body)
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *Deduplicator) key(msg *Message) string {
+	key := d.cfg.keyFunc(msg)
+	if len(key) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s:%s", d.cfg.prefix, msg.Topic, key)
+}
+
+// Begin 开始处理消息。返回 false 表示已处理成功，应跳过；返回 ErrDuplicateInFlight 表示正在被其它消费者处理；
+// 缓存不可用时返回其错误
+func (d *Deduplicator) Begin(ctx context.Context, msg *Message) (bool, error) {
+	key := d.key(msg)
+	if len(key) == 0 {
+		return true, nil
+	}
+	client := d.client.WithDB(d.cfg.db).WithContext(ctx)
+	for i := 0; i < 2; i++ {
+		if client.SetNX(key, dedupProcessing, d.cfg.lockTime) {
+			return true, nil
+		}
+		// SetNX 不返回错误，通过读取标记区分缓存不可用
+		status, err := client.GetString(key)
+		if err != nil && err != redis.Nil {
+			return false, err
+		}
+		switch status {
+		case dedupDone:
+			return false, nil
+		case dedupProcessing:
+			return false, ErrDuplicateInFlight
+		}
+		// 标记刚好过期，再试一次
+	}
+	return false, ErrDuplicateInFlight
+}
+
+// Done 标记处理成功，保留时间内相同消息将被跳过
+func (d *Deduplicator) Done(ctx context.Context, msg *Message) error {
+	key := d.key(msg)
+	if len(key) == 0 {
+		return nil
+	}
+	return d.client.WithDB(d.cfg.db).WithContext(ctx).SetEX(key, dedupDone, d.cfg.retention)
+}
+
+// Abort 处理失败，清除处理中标记以便重新投递后再次处理
+func (d *Deduplicator) Abort(ctx context.Context, msg *Message) {
+	key := d.key(msg)
+	if len(key) == 0 {
+		return
+	}
+	d.client.WithDB(d.cfg.db).WithContext(ctx).Delete(key)
+}
+
+// Middleware 去重中间件，已处理成功的消息直接确认，不再调用处理函数
+func (d *Deduplicator) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			ok, err := d.Begin(ctx, msg)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			if err = next(ctx, msg); err != nil {
+				d.Abort(ctx, msg)
+				return err
+			}
+			// 消息已处理成功，不能因标记失败而重新投递，标记过期后可能被再次处理
+			if err = d.Done(ctx, msg); err != nil {
+				logError(fmt.Sprintf("queue: dedup done %s %s: %v", msg.Topic, msg.Id, err))
+			}
+			return nil
+		}
+	}
+}
+
+// Dedup 创建去重中间件
+func Dedup(client cache.ICache, opts ...DedupOption) Middleware {
+	return NewDeduplicator(client, opts...).Middleware()
+}
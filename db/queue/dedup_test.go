@@ -0,0 +1,132 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/db/memory"
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupSkipsProcessedMessage(t *testing.T) {
+	var calls int
+	handler := Dedup(memory.New(), WithDedupRetention(time.Minute))(func(ctx context.Context, msg *Message) error {
+		calls++
+		return nil
+	})
+
+	msg := NewMessage([]byte("x"))
+	msg.Topic = "topic"
+	assert.Nil(t, handler(context.Background(), msg))
+	assert.Nil(t, handler(context.Background(), msg))
+	assert.Equal(t, 1, calls)
+}
+
+func TestDedupRetriesFailedMessage(t *testing.T) {
+	var calls int
+	handler := Dedup(memory.New())(func(ctx context.Context, msg *Message) error {
+		calls++
+		if calls == 1 {
+			return errors.New("fail")
+		}
+		return nil
+	})
+
+	msg := NewMessage([]byte("x"))
+	assert.NotNil(t, handler(context.Background(), msg))
+	assert.Nil(t, handler(context.Background(), msg))
+	assert.Equal(t, 2, calls)
+}
+
+func TestDedupIdempotencyKey(t *testing.T) {
+	d := NewDeduplicator(memory.New())
+	m1 := NewMessage([]byte("a"))
+	m1.SetHeader(HeaderIdempotencyKey, "order-1")
+	m2 := NewMessage([]byte("b"))
+	m2.SetHeader(HeaderIdempotencyKey, "order-1")
+
+	ok, err := d.Begin(context.Background(), m1)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	// 第一条仍在处理中
+	_, err = d.Begin(context.Background(), m2)
+	assert.True(t, errors.Is(err, ErrDuplicateInFlight))
+
+	assert.Nil(t, d.Done(context.Background(), m1))
+	ok, err = d.Begin(context.Background(), m2)
+	assert.False(t, ok)
+	assert.Nil(t, err)
+}
+
+// failingCache 模拟缓存不可用
+type failingCache struct {
+	cache.ICache
+	setNX bool
+}
+
+func (c *failingCache) WithDB(db int) cache.ICache {
+	return c
+}
+
+func (c *failingCache) WithContext(ctx context.Context) cache.ICache {
+	return c
+}
+
+func (c *failingCache) SetNX(key string, value interface{}, expiration time.Duration) bool {
+	if c.setNX {
+		return c.ICache.SetNX(key, value, expiration)
+	}
+	return false
+}
+
+func (c *failingCache) GetString(key string) (string, error) {
+	return "", errUnavailable
+}
+
+func (c *failingCache) SetEX(key string, value interface{}, timeout time.Duration) error {
+	return errUnavailable
+}
+
+var errUnavailable = errors.New("unavailable")
+
+func TestDedupCacheUnavailable(t *testing.T) {
+	// SetNX 失败时返回缓存的错误，而不是 ErrDuplicateInFlight
+	d := NewDeduplicator(&failingCache{ICache: memory.New()})
+	_, err := d.Begin(context.Background(), NewMessage([]byte("x")))
+	assert.Equal(t, errUnavailable, err)
+}
+
+func TestDedupDoneFailure(t *testing.T) {
+	var calls int
+	handler := Dedup(&failingCache{ICache: memory.New(), setNX: true})(func(ctx context.Context, msg *Message) error {
+		calls++
+		return nil
+	})
+	var buf syncBuffer
+	writer := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(writer)
+
+	// 处理成功后标记失败只输出日志，消息不会被 Nack
+	assert.Nil(t, handler(context.Background(), NewMessage([]byte("x"))))
+	assert.Equal(t, 1, calls)
+	assert.Contains(t, buf.String(), "dedup done")
+}
+
+func TestDedupRawMessage(t *testing.T) {
+	var calls int
+	handler := Dedup(memory.New())(func(ctx context.Context, msg *Message) error {
+		calls++
+		return nil
+	})
+	// 原始消息没有Id，按消息体去重
+	assert.Nil(t, handler(context.Background(), Decode([]byte("raw"))))
+	assert.Nil(t, handler(context.Background(), Decode([]byte("raw"))))
+	assert.Nil(t, handler(context.Background(), Decode([]byte("other"))))
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	"github.com/donetkit/contrib/utils/uuid"
+)
+
+const (
+	ContentTypeJSON  = "application/json"
+	ContentTypeText  = "text/plain"
+	ContentTypeBytes = "application/octet-stream"
+)
+
+// Envelope 消息在队列中的标准存储格式
+// JSON 消息体原样嵌入，文本消息体保存为字符串，二进制消息体使用 base64
+type Envelope struct {
+	Id          string            `json:"id"`
+	Topic       string            `json:"topic,omitempty"`
+	Key         string            `json:"key,omitempty"`
+	Timestamp   int64             `json:"timestamp"`
+	ContentType string            `json:"content_type,omitempty"`
+	Encoding    string            `json:"encoding,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Attempts    int64             `json:"attempts,omitempty"`
+	Body        json.RawMessage   `json:"body"`
+}
+
+// NewEnvelope 将任意生产值包装为消息
+// []byte 为二进制，string 为文本，其它值序列化为 JSON
+func NewEnvelope(value interface{}) (*Message, error) {
+	switch v := value.(type) {
+	case *Message:
+		return v, nil
+	case Message:
+		return &v, nil
+	case []byte:
+		return NewMessage(v), nil
+	case string:
+		msg := NewMessage([]byte(v))
+		msg.ContentType = ContentTypeText
+		return msg, nil
+	}
+	body, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	msg := NewMessage(body)
+	msg.ContentType = ContentTypeJSON
+	return msg, nil
+}
+
+// Encode 编码消息，空Id和时间会被补齐
+func Encode(msg *Message) ([]byte, error) {
+	if len(msg.Id) == 0 {
+		msg.Id = uuid.NewUUID()
+	}
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UnixMilli()
+	}
+	env := Envelope{
+		Id:          msg.Id,
+		Topic:       msg.Topic,
+		Key:         msg.Key,
+		Timestamp:   msg.Timestamp,
+		ContentType: msg.ContentType,
+		Headers:     msg.Headers,
+		Attempts:    msg.Attempts,
+	}
+	switch {
+	case msg.ContentType == ContentTypeJSON && json.Valid(msg.Body):
+		env.Body = msg.Body
+	case utf8.Valid(msg.Body):
+		if msg.ContentType == ContentTypeJSON {
+			env.Encoding = "text"
+		}
+		env.Body, _ = json.Marshal(string(msg.Body))
+	default:
+		env.Encoding = "base64"
+		env.Body, _ = json.Marshal(base64.StdEncoding.EncodeToString(msg.Body))
+	}
+	return json.Marshal(&env)
+}
+
+// Decode 解码消息。非 Encode 生产的原始数据整体作为消息体返回，便于兼容旧的生产者
+func Decode(data []byte) *Message {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil || len(env.Id) == 0 || env.Timestamp == 0 {
+		return &Message{Body: data}
+	}
+	msg := &Message{
+		Id:          env.Id,
+		Topic:       env.Topic,
+		Key:         env.Key,
+		ContentType: env.ContentType,
+		Headers:     env.Headers,
+		Body:        env.Body,
+		Timestamp:   env.Timestamp,
+		Attempts:    env.Attempts,
+	}
+	if env.ContentType == ContentTypeJSON && env.Encoding == "" {
+		return msg
+	}
+	var str string
+	if err := json.Unmarshal(env.Body, &str); err == nil {
+		msg.Body = []byte(str)
+		if env.Encoding == "base64" {
+			if b, err := base64.StdEncoding.DecodeString(str); err == nil {
+				msg.Body = b
+			}
+		}
+	}
+	return msg
+}
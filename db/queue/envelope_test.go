@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/donetkit/contrib/tracer"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	values := []interface{}{
+		"text",
+		[]byte{0xff, 0x00, 0x01},
+		map[string]interface{}{"id": "1", "name": "x"},
+	}
+	for _, value := range values {
+		msg, err := NewEnvelope(value)
+		assert.Nil(t, err)
+		msg.SetHeader("k", "v")
+		data, err := Encode(msg)
+		assert.Nil(t, err)
+
+		got := Decode(data)
+		assert.Equal(t, msg.Id, got.Id)
+		assert.Equal(t, msg.ContentType, got.ContentType)
+		assert.Equal(t, msg.Body, got.Body)
+		assert.Equal(t, "v", got.GetHeader("k"))
+	}
+}
+
+func TestEnvelopeJSONBodyIsEmbedded(t *testing.T) {
+	msg, _ := NewEnvelope(struct {
+		Name string `json:"name"`
+	}{Name: "x"})
+	data, _ := Encode(msg)
+	assert.Contains(t, string(data), `"body":{"name":"x"}`)
+
+	var v struct {
+		Name string `json:"name"`
+	}
+	assert.Nil(t, Decode(data).Unmarshal(&v))
+	assert.Equal(t, "x", v.Name)
+}
+
+func TestDecodeRawValue(t *testing.T) {
+	msg := Decode([]byte(`{"name":"legacy"}`))
+	assert.Equal(t, "", msg.Id)
+	assert.Equal(t, `{"name":"legacy"}`, string(msg.Body))
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	tracerServer := tracer.New(tracer.WithProvider(tp), tracer.WithPropagators(propagation.TraceContext{}))
+
+	msg := NewMessage([]byte("x"))
+	msg.Topic = "topic"
+	_, producer := StartProducerSpan(context.Background(), tracerServer, "test", msg)
+	producer.End()
+	assert.NotEmpty(t, msg.GetHeader("traceparent"))
+
+	data, _ := Encode(msg)
+	_, consumer := StartConsumerSpan(context.Background(), tracerServer, "test", Decode(data))
+	consumer.End()
+	assert.Equal(t, producer.SpanContext().TraceID(), consumer.SpanContext().TraceID())
+
+	// 已有链路时作为子链路，并关联生产者链路
+	ctx, parent := tracerServer.Tracer.Start(context.Background(), "batch")
+	_, linked := StartConsumerSpan(ctx, tracerServer, "test", Decode(data))
+	linked.End()
+	parent.End()
+	assert.Equal(t, parent.SpanContext().TraceID(), linked.SpanContext().TraceID())
+	assert.Len(t, linked.(sdktrace.ReadOnlySpan).Links(), 1)
+	assert.Equal(t, trace.SpanKindConsumer, linked.(sdktrace.ReadOnlySpan).SpanKind())
+}
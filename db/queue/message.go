@@ -0,0 +1,53 @@
+package queue
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/donetkit/contrib/utils/uuid"
+)
+
+// Message 与具体队列实现无关的消息
+type Message struct {
+	Id          string            // 消息Id，生产时为空则自动生成
+	Topic       string            // 消息主题
+	Key         string            // 业务键
+	ContentType string            // 消息体类型
+	Headers     map[string]string // 消息头，链路信息也保存在这里
+	Body        []byte            // 消息体
+	Timestamp   int64             // 生产时间，毫秒
+	Attempts    int64             // 投递次数，首次投递为1
+	Delay       int64             // 延迟投递秒数，仅支持延迟的队列有效，不随消息保存
+	Receipt     string            // 消费回执，由队列实现填充，用于 Ack/Nack，不随消息保存
+}
+
+// NewMessage 创建消息
+func NewMessage(body []byte) *Message {
+	return &Message{
+		Id:          uuid.NewUUID(),
+		ContentType: ContentTypeBytes,
+		Body:        body,
+		Timestamp:   time.Now().UnixMilli(),
+	}
+}
+
+// GetHeader 获取消息头
+func (m *Message) GetHeader(key string) string {
+	if m.Headers == nil {
+		return ""
+	}
+	return m.Headers[key]
+}
+
+// SetHeader 设置消息头
+func (m *Message) SetHeader(key, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+}
+
+// Unmarshal 将 JSON 消息体解析到 v
+func (m *Message) Unmarshal(v interface{}) error {
+	return json.Unmarshal(m.Body, v)
+}
@@ -0,0 +1,278 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/donetkit/contrib/utils/cache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+)
+
+const instrumName = "github.com/donetkit/contrib/db/queue"
+
+var consumerGroupKey = attribute.Key("messaging.consumer_group")
+
+// Stats 队列状态快照，由后台轮询采集，Group 为空表示整个队列
+type Stats struct {
+	Topic   string
+	Group   string
+	Length  int64 // 队列长度
+	Pending int64 // 已投递未确认的消息数
+	Lag     int64 // 消费组尚未投递的消息数
+	Due     int64 // 延迟队列已到期未取走的消息数
+}
+
+// StreamLag Stream 消费组最后投递编号之后的消息数，最多统计 maxLag 条
+func StreamLag(client cache.ICache, key, lastDeliveredId, lastId string, maxLag int64) int64 {
+	if lastDeliveredId == lastId {
+		return 0
+	}
+	msgs := client.XRangeN(key, lastDeliveredId, "+", maxLag+1)
+	var lag = int64(len(msgs))
+	if lag > 0 && msgs[0].ID == lastDeliveredId {
+		lag--
+	}
+	if lag > maxLag {
+		lag = maxLag
+	}
+	return lag
+}
+
+// MetricsOption 指标配置
+type MetricsOption func(*Metrics)
+
+// WithMeterProvider 默认使用 otel.GetMeterProvider()
+func WithMeterProvider(meterProvider metric.MeterProvider) MetricsOption {
+	return func(m *Metrics) {
+		m.meterProvider = meterProvider
+	}
+}
+
+// Metrics 队列指标。方法在 nil 上调用时不做任何事，队列未设置指标时无需判断
+type Metrics struct {
+	meterProvider metric.MeterProvider
+	meter         metric.Meter
+
+	produced     metric.Int64Counter
+	consumed     metric.Int64Counter
+	acked        metric.Int64Counter
+	retried      metric.Int64Counter
+	deadLettered metric.Int64Counter
+	latency      metric.Float64Histogram
+
+	length  metric.Int64ObservableGauge
+	pending metric.Int64ObservableGauge
+	lag     metric.Int64ObservableGauge
+	due     metric.Int64ObservableGauge
+
+	locker sync.RWMutex
+	stats  map[string]*polledStats
+}
+
+type polledStats struct {
+	system string
+	stats  []Stats
+}
+
+func NewMetrics(opts ...MetricsOption) *Metrics {
+	m := &Metrics{
+		meterProvider: otel.GetMeterProvider(),
+		stats:         make(map[string]*polledStats),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.meter = m.meterProvider.Meter(instrumName)
+
+	m.produced, _ = m.meter.Int64Counter(
+		"messaging.queue.produced",
+		metric.WithDescription("The number of messages produced"),
+		metric.WithUnit("{message}"),
+	)
+	m.consumed, _ = m.meter.Int64Counter(
+		"messaging.queue.consumed",
+		metric.WithDescription("The number of messages delivered to handlers"),
+		metric.WithUnit("{message}"),
+	)
+	m.acked, _ = m.meter.Int64Counter(
+		"messaging.queue.acked",
+		metric.WithDescription("The number of messages acknowledged"),
+		metric.WithUnit("{message}"),
+	)
+	m.retried, _ = m.meter.Int64Counter(
+		"messaging.queue.retried",
+		metric.WithDescription("The number of messages redelivered after failure or timeout"),
+		metric.WithUnit("{message}"),
+	)
+	m.deadLettered, _ = m.meter.Int64Counter(
+		"messaging.queue.dead_lettered",
+		metric.WithDescription("The number of messages discarded after exceeding the retry limit"),
+		metric.WithUnit("{message}"),
+	)
+	m.latency, _ = m.meter.Float64Histogram(
+		"messaging.queue.handler_duration",
+		metric.WithDescription("Timing of message handlers"),
+		metric.WithUnit("ms"),
+	)
+
+	m.length, _ = m.meter.Int64ObservableGauge(
+		"messaging.queue.length",
+		metric.WithDescription("The number of messages in the queue"),
+	)
+	m.pending, _ = m.meter.Int64ObservableGauge(
+		"messaging.queue.pending",
+		metric.WithDescription("The number of delivered but unacknowledged messages"),
+	)
+	m.lag, _ = m.meter.Int64ObservableGauge(
+		"messaging.queue.lag",
+		metric.WithDescription("The number of messages not yet delivered to the consumer group"),
+	)
+	m.due, _ = m.meter.Int64ObservableGauge(
+		"messaging.queue.due",
+		metric.WithDescription("The number of delayed messages that are due but not taken"),
+	)
+	if _, err := m.meter.RegisterCallback(m.observe, m.length, m.pending, m.lag, m.due); err != nil {
+		otel.Handle(err)
+	}
+	return m
+}
+
+func attrs(system, topic, group string) metric.MeasurementOption {
+	kvs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String(system),
+		semconv.MessagingDestinationKey.String(topic),
+	}
+	if len(group) > 0 {
+		kvs = append(kvs, consumerGroupKey.String(group))
+	}
+	return metric.WithAttributes(kvs...)
+}
+
+// Produced 生产消息数
+func (m *Metrics) Produced(ctx context.Context, system, topic string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.produced.Add(ctx, n, attrs(system, topic, ""))
+}
+
+// Consumed 投递给处理函数的消息数
+func (m *Metrics) Consumed(ctx context.Context, system, topic, group string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.consumed.Add(ctx, n, attrs(system, topic, group))
+}
+
+// Acked 确认消息数
+func (m *Metrics) Acked(ctx context.Context, system, topic, group string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.acked.Add(ctx, n, attrs(system, topic, group))
+}
+
+// Retried 重新投递的消息数
+func (m *Metrics) Retried(ctx context.Context, system, topic, group string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.retried.Add(ctx, n, attrs(system, topic, group))
+}
+
+// DeadLettered 超过最大重试次数被抛弃的消息数
+func (m *Metrics) DeadLettered(ctx context.Context, system, topic, group string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.deadLettered.Add(ctx, n, attrs(system, topic, group))
+}
+
+// Handled 记录处理函数耗时
+func (m *Metrics) Handled(ctx context.Context, system, topic, group string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.latency.Record(ctx, float64(duration)/float64(time.Millisecond), attrs(system, topic, group))
+}
+
+// Middleware 记录处理耗时。投递、确认、重试数由各队列的 Broker 实现统计，这里不重复计数
+func (m *Metrics) Middleware(system, group string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			if m == nil {
+				return next(ctx, msg)
+			}
+			start := time.Now()
+			err := next(ctx, msg)
+			m.Handled(ctx, system, msg.Topic, group, time.Since(start))
+			return err
+		}
+	}
+}
+
+// Poll 后台按间隔调用 fn 采集队列状态，指标导出时读取最近一次的结果，ctx 结束后停止并移除
+// 每个队列启动一个，name 用于区分同一类型的不同队列
+func (m *Metrics) Poll(ctx context.Context, system, name string, interval time.Duration, fn func() []Stats) {
+	if m == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	key := system + "/" + name
+	go func() {
+		defer func() {
+			m.locker.Lock()
+			delete(m.stats, key)
+			m.locker.Unlock()
+		}()
+		for {
+			stats := fn()
+			m.locker.Lock()
+			m.stats[key] = &polledStats{system: system, stats: stats}
+			m.locker.Unlock()
+			select {
+			case <-ctx.Done():
+				return // 退出了...
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// Snapshot 最近一次采集的队列状态
+func (m *Metrics) Snapshot() map[string][]Stats {
+	if m == nil {
+		return nil
+	}
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	rs := make(map[string][]Stats, len(m.stats))
+	for key, item := range m.stats {
+		rs[key] = item.stats
+	}
+	return rs
+}
+
+func (m *Metrics) observe(ctx context.Context, o metric.Observer) error {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	for _, item := range m.stats {
+		for _, stats := range item.stats {
+			opt := attrs(item.system, stats.Topic, stats.Group)
+			if len(stats.Group) == 0 {
+				o.ObserveInt64(m.length, stats.Length, opt)
+				o.ObserveInt64(m.due, stats.Due, opt)
+			} else {
+				o.ObserveInt64(m.lag, stats.Lag, opt)
+			}
+			o.ObserveInt64(m.pending, stats.Pending, opt)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNilMetricsIsNoop(t *testing.T) {
+	var m *Metrics
+	m.Produced(context.Background(), "test", "topic", 1)
+	m.Poll(context.Background(), "test", "topic", time.Second, func() []Stats { return nil })
+	assert.Nil(t, m.Snapshot())
+
+	handler := m.Middleware("test", "group")(func(ctx context.Context, msg *Message) error {
+		return errors.New("fail")
+	})
+	assert.NotNil(t, handler(context.Background(), NewMessage([]byte("x"))))
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	var calls int
+	handler := NewMetrics().Middleware("test", "group")(func(ctx context.Context, msg *Message) error {
+		calls++
+		return nil
+	})
+	assert.Nil(t, handler(context.Background(), NewMessage([]byte("x"))))
+	assert.Equal(t, 1, calls)
+}
+
+func TestMetricsPoll(t *testing.T) {
+	m := NewMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+	polled := make(chan struct{}, 1)
+	m.Poll(ctx, "test", "topic", time.Hour, func() []Stats {
+		polled <- struct{}{}
+		return []Stats{{Topic: "topic", Length: 3}, {Topic: "topic", Group: "g", Pending: 1, Lag: 2}}
+	})
+	<-polled
+	assert.Eventually(t, func() bool {
+		return len(m.Snapshot()["test/topic"]) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// 停止后移除
+	cancel()
+	assert.Eventually(t, func() bool {
+		return len(m.Snapshot()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
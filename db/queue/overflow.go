@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OverflowPolicy 队列达到最大长度时的处理策略
+type OverflowPolicy int
+
+const (
+	OverflowDropOldest OverflowPolicy = iota // 写入新消息并丢弃最旧的消息，延迟队列丢弃最晚到期的消息
+	OverflowReject                           // 拒绝新消息，TryAdd 返回 *OverflowError，Add 丢弃消息并记录警告日志
+	OverflowBlock                            // 阻塞等待消费者腾出空间，超时后返回 *OverflowError
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowReject:
+		return "reject"
+	case OverflowBlock:
+		return "block"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+var (
+	// ErrQueueFull 队列已满，可用 errors.Is 判断 *OverflowError
+	ErrQueueFull = errors.New("queue: queue is full")
+	// ErrBatchTooLarge 一批消息超过最大队列长度，拒绝或阻塞策略下永远无法写入
+	ErrBatchTooLarge = errors.New("queue: batch is larger than max length")
+)
+
+// OverflowError 队列已满时拒绝写入的错误
+type OverflowError struct {
+	Topic     string
+	Policy    OverflowPolicy
+	Length    int64 // 写入前的队列长度
+	MaxLength int64
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("queue: %s is full (%d/%d, policy %s)", e.Topic, e.Length, e.MaxLength, e.Policy)
+}
+
+func (e *OverflowError) Is(target error) bool {
+	return target == ErrQueueFull
+}
+
+// BlockPollInterval 阻塞策略检查队列长度的间隔
+var BlockPollInterval = 100 * time.Millisecond
+
+// WaitCapacity 按策略检查队列能否再写入 n 条消息，length 返回当前队列长度
+// maxLength 小于等于0或策略为 OverflowDropOldest 时不检查；OverflowBlock 最多等待 timeout，ctx 结束时返回 ctx.Err()
+// n 大于 maxLength 时直接返回 ErrBatchTooLarge
+func WaitCapacity(ctx context.Context, topic string, policy OverflowPolicy, maxLength, n int64, timeout time.Duration, length func() int64) error {
+	if maxLength <= 0 || policy == OverflowDropOldest {
+		return nil
+	}
+	if n > maxLength {
+		return fmt.Errorf("%w: %s %d > %d", ErrBatchTooLarge, topic, n, maxLength)
+	}
+	current := length()
+	if current+n <= maxLength {
+		return nil
+	}
+	overflow := &OverflowError{Topic: topic, Policy: policy, Length: current, MaxLength: maxLength}
+	if policy != OverflowBlock {
+		return overflow
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(BlockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return overflow
+		case <-ticker.C:
+			if overflow.Length = length(); overflow.Length+n <= maxLength {
+				return nil
+			}
+		}
+	}
+}
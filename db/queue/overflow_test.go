@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitCapacityReject(t *testing.T) {
+	length := func() int64 { return 10 }
+	assert.Nil(t, WaitCapacity(context.Background(), "topic", OverflowDropOldest, 10, 1, time.Second, length))
+	assert.Nil(t, WaitCapacity(context.Background(), "topic", OverflowReject, 0, 1, time.Second, length))
+	assert.Nil(t, WaitCapacity(context.Background(), "topic", OverflowReject, 11, 1, time.Second, length))
+
+	err := WaitCapacity(context.Background(), "topic", OverflowReject, 10, 1, time.Second, length)
+	assert.True(t, errors.Is(err, ErrQueueFull))
+	var overflow *OverflowError
+	assert.True(t, errors.As(err, &overflow))
+	assert.Equal(t, int64(10), overflow.Length)
+	assert.Equal(t, OverflowReject, overflow.Policy)
+}
+
+func TestWaitCapacityBlock(t *testing.T) {
+	var current int64 = 10
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		atomic.StoreInt64(&current, 5)
+	}()
+	length := func() int64 { return atomic.LoadInt64(&current) }
+	assert.Nil(t, WaitCapacity(context.Background(), "topic", OverflowBlock, 10, 1, time.Second, length))
+
+	// 超时
+	atomic.StoreInt64(&current, 10)
+	err := WaitCapacity(context.Background(), "topic", OverflowBlock, 10, 1, 200*time.Millisecond, length)
+	assert.True(t, errors.Is(err, ErrQueueFull))
+
+	// ctx 结束
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = WaitCapacity(ctx, "topic", OverflowBlock, 10, 1, time.Second, length)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestWaitCapacityBatchTooLarge(t *testing.T) {
+	length := func() int64 { return 0 }
+	for _, policy := range []OverflowPolicy{OverflowReject, OverflowBlock} {
+		err := WaitCapacity(context.Background(), "topic", policy, 10, 11, time.Minute, length)
+		assert.True(t, errors.Is(err, ErrBatchTooLarge))
+		assert.False(t, errors.Is(err, ErrQueueFull))
+	}
+	assert.Nil(t, WaitCapacity(context.Background(), "topic", OverflowDropOldest, 10, 11, time.Minute, length))
+}
@@ -5,6 +5,9 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
+// IProducerConsumer Redis Stream 队列的原始接口
+//
+// Deprecated: 各队列的签名并不一致，请使用 Producer、Consumer 和 Broker
 type IProducerConsumer interface {
 	// Count 元素个数
 	Count() int64
@@ -0,0 +1,449 @@
+package queue_admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	TypeStream   = "stream"   // queue_stream
+	TypeReliable = "reliable" // queue_reliable
+	TypeDelay    = "delay"    // queue_delay
+)
+
+var (
+	ErrNotFound     = errors.New("queue admin: topic not found")
+	ErrInvalidArgs  = errors.New("queue admin: invalid arguments")
+	ErrNotSupported = errors.New("queue admin: operation not supported for this topic type")
+)
+
+// Topic 队列概要
+type Topic struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Length int64  `json:"length"`
+}
+
+// Consumer 消费者
+type Consumer struct {
+	Name    string `json:"name"`
+	Pending int64  `json:"pending"`
+	Idle    int64  `json:"idle"` // 空闲毫秒数
+}
+
+// Group 消费组
+type Group struct {
+	Name            string     `json:"name"`
+	Pending         int64      `json:"pending"`
+	LastDeliveredId string     `json:"last_delivered_id"`
+	Lag             int64      `json:"lag"` // 尚未投递的消息数，最多统计 MaxLag 条
+	Consumers       []Consumer `json:"consumers"`
+}
+
+// StreamInfo Stream详情
+type StreamInfo struct {
+	Name    string  `json:"name"`
+	Length  int64   `json:"length"`
+	FirstId string  `json:"first_id"`
+	LastId  string  `json:"last_id"`
+	Groups  []Group `json:"groups"`
+}
+
+// ReliableInfo 可靠队列详情，Acks 为各消费者确认列表中未确认的消息数
+type ReliableInfo struct {
+	Name   string           `json:"name"`
+	Length int64            `json:"length"`
+	Acks   map[string]int64 `json:"acks"`
+}
+
+// Message 消息
+type Message struct {
+	Id       string `json:"id"`
+	Value    string `json:"value"`
+	Consumer string `json:"consumer,omitempty"`
+	Idle     int64  `json:"idle,omitempty"`     // 死信空闲毫秒数
+	Attempts int64  `json:"attempts,omitempty"` // 死信投递次数
+}
+
+// Admin 队列管理，直接操作 Redis 中 queue_stream、queue_reliable、queue_delay 的数据
+type Admin struct {
+	client cache.ICache
+	db     int
+	logger glog.ILoggerEntry
+	maxLag int64
+}
+
+func New(client cache.ICache, opts ...Option) *Admin {
+	a := &Admin{
+		client: client,
+		maxLag: 10000,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *Admin) cache(ctx context.Context) cache.ICache {
+	return a.client.WithDB(a.db).WithContext(ctx)
+}
+
+// ackPrefix 可靠队列确认列表前缀，与 queue_reliable 的 key:Ack:status 保持一致
+func ackPrefix(key string) string {
+	return fmt.Sprintf("%s:Ack:", key)
+}
+
+func (a *Admin) scan(ctx context.Context, match string) []string {
+	var keys []string
+	var cursor uint64
+	for {
+		list, next := a.cache(ctx).Scan(cursor, match, 1000)
+		keys = append(keys, list...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	return keys
+}
+
+// Type 队列类型，不存在时返回空
+func (a *Admin) Type(ctx context.Context, key string) string {
+	pipe := a.cache(ctx).Pipeline()
+	cmd := pipe.Type(ctx, key)
+	pipe.Exec(ctx)
+	return topicType(key, cmd.Val())
+}
+
+func topicType(key, redisType string) string {
+	switch redisType {
+	case "stream":
+		return TypeStream
+	case "list":
+		// 确认列表不是队列
+		if strings.Contains(key, ":Ack:") {
+			return ""
+		}
+		return TypeReliable
+	case "zset":
+		return TypeDelay
+	}
+	return ""
+}
+
+// Topics 列出匹配的队列，match 为空时列出全部
+func (a *Admin) Topics(ctx context.Context, match string) ([]Topic, error) {
+	if len(match) == 0 {
+		match = "*"
+	}
+	keys := a.scan(ctx, match)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	pipe := a.cache(ctx).Pipeline()
+	types := make([]*redis.StatusCmd, len(keys))
+	for i, key := range keys {
+		types[i] = pipe.Type(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var topics []Topic
+	var lengths []*redis.IntCmd
+	pipe = a.cache(ctx).Pipeline()
+	for i, key := range keys {
+		topic := Topic{Name: key, Type: topicType(key, types[i].Val())}
+		switch topic.Type {
+		case TypeStream:
+			lengths = append(lengths, pipe.XLen(ctx, key))
+		case TypeReliable:
+			lengths = append(lengths, pipe.LLen(ctx, key))
+		case TypeDelay:
+			lengths = append(lengths, pipe.ZCard(ctx, key))
+		default:
+			continue
+		}
+		topics = append(topics, topic)
+	}
+	if len(topics) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i := range topics {
+		topics[i].Length = lengths[i].Val()
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	return topics, nil
+}
+
+// Info 队列详情，Stream 返回 *StreamInfo，可靠队列返回 *ReliableInfo
+func (a *Admin) Info(ctx context.Context, key string) (interface{}, error) {
+	switch a.Type(ctx, key) {
+	case TypeStream:
+		return a.StreamInfo(ctx, key)
+	case TypeReliable:
+		return a.ReliableInfo(ctx, key)
+	case TypeDelay:
+		return &Topic{Name: key, Type: TypeDelay, Length: a.length(ctx, key, TypeDelay)}, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (a *Admin) length(ctx context.Context, key, topicType string) int64 {
+	pipe := a.cache(ctx).Pipeline()
+	var cmd *redis.IntCmd
+	switch topicType {
+	case TypeStream:
+		cmd = pipe.XLen(ctx, key)
+	case TypeReliable:
+		cmd = pipe.LLen(ctx, key)
+	default:
+		cmd = pipe.ZCard(ctx, key)
+	}
+	pipe.Exec(ctx)
+	return cmd.Val()
+}
+
+// StreamInfo Stream详情，包括各消费组的待确认数、积压数及消费者
+func (a *Admin) StreamInfo(ctx context.Context, key string) (*StreamInfo, error) {
+	client := a.cache(ctx)
+	stream := client.XInfoStream(key)
+	if stream == nil {
+		return nil, ErrNotFound
+	}
+	info := &StreamInfo{
+		Name:    key,
+		Length:  stream.Length,
+		FirstId: stream.FirstEntry.ID,
+		LastId:  stream.LastGeneratedID,
+	}
+	for _, item := range client.XInfoGroups(key) {
+		group := Group{
+			Name:            item.Name,
+			Pending:         item.Pending,
+			LastDeliveredId: item.LastDeliveredID,
+			Lag:             queue.StreamLag(client, key, item.LastDeliveredID, stream.LastGeneratedID, a.maxLag),
+		}
+		for _, consumer := range client.XInfoConsumers(key, item.Name) {
+			group.Consumers = append(group.Consumers, Consumer{
+				Name:    consumer.Name,
+				Pending: consumer.Pending,
+				Idle:    consumer.Idle,
+			})
+		}
+		info.Groups = append(info.Groups, group)
+	}
+	return info, nil
+}
+
+// lag 最后投递编号之后的消息数
+func (a *Admin) lag(ctx context.Context, key, lastDeliveredId, lastId string) int64 {
+	if lastDeliveredId == lastId {
+		return 0
+	}
+	msgs := a.cache(ctx).XRangeN(key, lastDeliveredId, "+", a.maxLag+1)
+	var lag = int64(len(msgs))
+	if lag > 0 && msgs[0].ID == lastDeliveredId {
+		lag--
+	}
+	if lag > a.maxLag {
+		lag = a.maxLag
+	}
+	return lag
+}
+
+// ReliableInfo 可靠队列详情
+func (a *Admin) ReliableInfo(ctx context.Context, key string) (*ReliableInfo, error) {
+	info := &ReliableInfo{
+		Name:   key,
+		Length: a.length(ctx, key, TypeReliable),
+		Acks:   make(map[string]int64),
+	}
+	ackKeys := a.scan(ctx, ackPrefix(key)+"*")
+	if len(ackKeys) == 0 {
+		return info, nil
+	}
+	pipe := a.cache(ctx).Pipeline()
+	lengths := make([]*redis.IntCmd, len(ackKeys))
+	for i, ackKey := range ackKeys {
+		lengths[i] = pipe.LLen(ctx, ackKey)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, ackKey := range ackKeys {
+		info.Acks[strings.TrimPrefix(ackKey, ackPrefix(key))] = lengths[i].Val()
+	}
+	return info, nil
+}
+
+// Peek 查看消息，不影响消费
+// Stream 从 start 开始（为空时从头开始）；可靠队列从最先被消费的一端开始；延迟队列按到期时间排序
+func (a *Admin) Peek(ctx context.Context, key string, start string, count int64) ([]Message, error) {
+	if count <= 0 {
+		count = 10
+	}
+	client := a.cache(ctx)
+	var msgs []Message
+	switch a.Type(ctx, key) {
+	case TypeStream:
+		if len(start) == 0 {
+			start = "-"
+		}
+		for _, item := range client.XRangeN(key, start, "+", count) {
+			msgs = append(msgs, Message{Id: item.ID, Value: streamValue(key, item)})
+		}
+	case TypeReliable:
+		// LPush 生产，RPopLPush 消费，最右侧最先被消费
+		list := client.LRange(key, -count, -1)
+		for i := len(list) - 1; i >= 0; i-- {
+			msgs = append(msgs, Message{Value: list[i]})
+		}
+	case TypeDelay:
+		// 按到期时间从早到晚
+		for _, item := range client.ZRangeByScore(key, 0, math.MaxInt64, 0, count) {
+			msgs = append(msgs, Message{Value: item})
+		}
+	default:
+		return nil, ErrNotFound
+	}
+	return msgs, nil
+}
+
+func streamValue(key string, msg redis.XMessage) string {
+	if val, ok := msg.Values[key].(string); ok {
+		return val
+	}
+	for _, val := range msg.Values {
+		if str, ok := val.(string); ok {
+			return str
+		}
+	}
+	return ""
+}
+
+// DeadLetters 消费组中空闲超过 minIdle 的待确认消息
+func (a *Admin) DeadLetters(ctx context.Context, key, group string, minIdle time.Duration, count int64) ([]Message, error) {
+	if len(key) == 0 || len(group) == 0 {
+		return nil, ErrInvalidArgs
+	}
+	if count <= 0 {
+		count = 100
+	}
+	client := a.cache(ctx)
+	var msgs []Message
+	for _, pending := range client.XPendingExt(key, group, "-", "+", count) {
+		if pending.Idle < minIdle {
+			continue
+		}
+		msg := Message{
+			Id:       pending.ID,
+			Consumer: pending.Consumer,
+			Idle:     pending.Idle.Milliseconds(),
+			Attempts: pending.RetryCount,
+		}
+		if items := client.XRangeN(key, pending.ID, pending.ID, 1); len(items) > 0 {
+			msg.Value = streamValue(key, items[0])
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// Replay 重新投递死信
+// Stream 将消费组中指定的待确认消息重新写入队列尾部，并确认删除原消息，返回重新写入的个数
+// 可靠队列将 group 指定的确认列表（消费者状态Key）中的消息全部放回队列，ids 不使用
+func (a *Admin) Replay(ctx context.Context, key, group string, ids ...string) (int64, error) {
+	if len(key) == 0 || len(group) == 0 {
+		return 0, ErrInvalidArgs
+	}
+	client := a.cache(ctx)
+	switch a.Type(ctx, key) {
+	case TypeStream:
+		var count int64
+		for _, id := range ids {
+			items := client.XRangeN(key, id, id, 1)
+			if len(items) == 0 {
+				continue
+			}
+			value := streamValue(key, items[0])
+			if len(client.XAdd(key, "", false, 0, value)) == 0 {
+				return count, fmt.Errorf("queue admin: replay %s %s failed", key, id)
+			}
+			client.XAck(key, group, id)
+			client.XDel(key, id)
+			count++
+			if a.logger != nil {
+				a.logger.Info(fmt.Sprintf("重新投递死信：%s %s %s", key, group, id))
+			}
+		}
+		return count, nil
+	case TypeReliable:
+		var count int64
+		for client.RPopLPush(ackPrefix(key)+group, key) != "" {
+			count++
+		}
+		if a.logger != nil {
+			a.logger.Info(fmt.Sprintf("重新投递死信：%s %s %d", key, group, count))
+		}
+		return count, nil
+	case TypeDelay:
+		return 0, ErrNotSupported
+	}
+	return 0, ErrNotFound
+}
+
+// ResetGroup 重置消费组的消费位置，startId 为 0 时从头开始，$ 时从最新开始
+func (a *Admin) ResetGroup(ctx context.Context, key, group, startId string) error {
+	if len(key) == 0 || len(group) == 0 {
+		return ErrInvalidArgs
+	}
+	if len(startId) == 0 {
+		startId = "$"
+	}
+	if a.cache(ctx).XGroupSetID(key, group, startId) != "OK" {
+		return fmt.Errorf("queue admin: reset group %s %s to %s failed", key, group, startId)
+	}
+	if a.logger != nil {
+		a.logger.Info(fmt.Sprintf("重置消费组：%s %s %s", key, group, startId))
+	}
+	return nil
+}
+
+// DeleteConsumers 删除消费组中没有待确认消息且空闲超过 minIdle 的消费者，返回删除的消费者
+// consumer 不为空时只删除该消费者，忽略待确认消息和空闲时间
+func (a *Admin) DeleteConsumers(ctx context.Context, key, group, consumer string, minIdle time.Duration) ([]string, error) {
+	if len(key) == 0 || len(group) == 0 {
+		return nil, ErrInvalidArgs
+	}
+	client := a.cache(ctx)
+	var deleted []string
+	for _, item := range client.XInfoConsumers(key, group) {
+		if len(consumer) > 0 {
+			if item.Name != consumer {
+				continue
+			}
+		} else if item.Pending > 0 || time.Duration(item.Idle)*time.Millisecond < minIdle {
+			continue
+		}
+		client.XGroupDelConsumer(key, group, item.Name)
+		deleted = append(deleted, item.Name)
+		if a.logger != nil {
+			a.logger.Info(fmt.Sprintf("删除消费者：%s %s %s", key, group, item.Name))
+		}
+	}
+	return deleted, nil
+}
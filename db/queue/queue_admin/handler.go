@@ -0,0 +1,101 @@
+package queue_admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Handler 管理接口，可挂载到 webserve 的 AddHandler 或任意 http.ServeMux
+//
+//	GET  /topics?match=order*                        队列列表
+//	GET  /info?key=order                             队列详情、消费组积压及消费者
+//	GET  /messages?key=order&start=0-0&count=10      查看消息
+//	GET  /dead-letters?key=order&group=g&idle=60000  消费组中空闲超过 idle 毫秒的待确认消息
+//	POST /replay?key=order&group=g&id=1-0&id=2-0     重新投递死信
+//	POST /groups/reset?key=order&group=g&id=0        重置消费组消费位置
+//	POST /consumers/delete?key=order&group=g&idle=3600000&consumer=c  删除空闲消费者
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/topics", a.get(func(r *http.Request) (interface{}, error) {
+		return a.Topics(r.Context(), r.FormValue("match"))
+	}))
+	mux.HandleFunc("/info", a.get(func(r *http.Request) (interface{}, error) {
+		return a.Info(r.Context(), r.FormValue("key"))
+	}))
+	mux.HandleFunc("/messages", a.get(func(r *http.Request) (interface{}, error) {
+		return a.Peek(r.Context(), r.FormValue("key"), r.FormValue("start"), formInt(r, "count"))
+	}))
+	mux.HandleFunc("/dead-letters", a.get(func(r *http.Request) (interface{}, error) {
+		return a.DeadLetters(r.Context(), r.FormValue("key"), r.FormValue("group"), formMillis(r, "idle"), formInt(r, "count"))
+	}))
+	mux.HandleFunc("/replay", a.post(func(r *http.Request) (interface{}, error) {
+		count, err := a.Replay(r.Context(), r.FormValue("key"), r.FormValue("group"), r.Form["id"]...)
+		return map[string]int64{"count": count}, err
+	}))
+	mux.HandleFunc("/groups/reset", a.post(func(r *http.Request) (interface{}, error) {
+		return nil, a.ResetGroup(r.Context(), r.FormValue("key"), r.FormValue("group"), r.FormValue("id"))
+	}))
+	mux.HandleFunc("/consumers/delete", a.post(func(r *http.Request) (interface{}, error) {
+		return a.DeleteConsumers(r.Context(), r.FormValue("key"), r.FormValue("group"), r.FormValue("consumer"), formMillis(r, "idle"))
+	}))
+	return mux
+}
+
+func (a *Admin) get(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return a.handle(http.MethodGet, fn)
+}
+
+func (a *Admin) post(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return a.handle(http.MethodPost, fn)
+}
+
+func (a *Admin) handle(method string, fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		data, err := fn(r)
+		if err != nil {
+			writeJSON(w, statusCode(err), map[string]string{"error": err.Error()})
+			return
+		}
+		if data == nil {
+			data = map[string]bool{"ok": true}
+		}
+		writeJSON(w, http.StatusOK, data)
+	}
+}
+
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidArgs), errors.Is(err, ErrNotSupported):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(data)
+}
+
+func formInt(r *http.Request, name string) int64 {
+	value, _ := strconv.ParseInt(r.FormValue(name), 10, 64)
+	return value
+}
+
+func formMillis(r *http.Request, name string) time.Duration {
+	return time.Duration(formInt(r, name)) * time.Millisecond
+}
@@ -0,0 +1,76 @@
+package queue_admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCache struct {
+	cache.ICache
+	startId   string
+	consumers []redis.XInfoConsumer
+	deleted   []string
+}
+
+func (c *fakeCache) WithDB(db int) cache.ICache {
+	return c
+}
+
+func (c *fakeCache) WithContext(ctx context.Context) cache.ICache {
+	return c
+}
+
+func (c *fakeCache) XGroupSetID(key string, group string, start string) string {
+	c.startId = start
+	return "OK"
+}
+
+func (c *fakeCache) XInfoConsumers(key string, group string) []redis.XInfoConsumer {
+	return c.consumers
+}
+
+func (c *fakeCache) XGroupDelConsumer(key string, group string, consumer string) int64 {
+	c.deleted = append(c.deleted, consumer)
+	return 0
+}
+
+func TestHandlerResetGroup(t *testing.T) {
+	client := &fakeCache{}
+	handler := New(client).Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/groups/reset?key=order&group=g&id=0", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", client.startId)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/groups/reset?key=order&group=g", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/groups/reset?key=order", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandlerDeleteStaleConsumers(t *testing.T) {
+	client := &fakeCache{consumers: []redis.XInfoConsumer{
+		{Name: "busy", Pending: 1, Idle: 7200_000},
+		{Name: "active", Pending: 0, Idle: 1000},
+		{Name: "stale", Pending: 0, Idle: 7200_000},
+	}}
+	w := httptest.NewRecorder()
+	New(client).Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/consumers/delete?key=order&group=g&idle=3600000", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var deleted []string
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &deleted))
+	assert.Equal(t, []string{"stale"}, deleted)
+	assert.Equal(t, []string{"stale"}, client.deleted)
+}
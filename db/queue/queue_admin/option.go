@@ -0,0 +1,31 @@
+package queue_admin
+
+import (
+	"github.com/donetkit/contrib-log/glog"
+)
+
+// Option for queue admin
+type Option func(*Admin)
+
+// WithDB redis DB 默认为 0
+func WithDB(db int) Option {
+	return func(a *Admin) {
+		a.db = db
+	}
+}
+
+// WithLogger set logger function
+func WithLogger(logger glog.ILogger) Option {
+	return func(a *Admin) {
+		a.logger = logger.WithField("Queue-Admin", "Queue-Admin")
+	}
+}
+
+// WithMaxLag 统计消费组积压数的上限。默认10000
+func WithMaxLag(maxLag int64) Option {
+	return func(a *Admin) {
+		if maxLag > 0 {
+			a.maxLag = maxLag
+		}
+	}
+}
@@ -4,22 +4,28 @@ import (
 	"context"
 	"fmt"
 	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
 	"github.com/donetkit/contrib/utils/cache"
+	"github.com/go-redis/redis/v8"
 	"time"
 )
 
 type RedisDelayQueue struct {
-	ctx                         context.Context   // Context
-	DB                          int               // redis DB 默认为 0
-	key                         string            // 消息队列key
-	Topic                       string            // 消息队列主题
-	ThrowOnFailure              bool              // 失败时抛出异常。默认false
-	RetryTimesWhenSendFailed    int               // 发送消息失败时的重试次数。默认3次
-	RetryIntervalWhenSendFailed int               // 重试间隔。默认1000ms
-	TransferInterval            int64             // 转移延迟消息到主队列的间隔。默认10s
-	Delay                       int64             // 默认延迟时间。默认60秒
-	logger                      glog.ILoggerEntry // logger
-	client                      cache.ICache      // cache client
+	ctx                         context.Context      // Context
+	DB                          int                  // redis DB 默认为 0
+	key                         string               // 消息队列key
+	Topic                       string               // 消息队列主题
+	ThrowOnFailure              bool                 // 失败时抛出异常。默认false
+	RetryTimesWhenSendFailed    int                  // 发送消息失败时的重试次数。默认3次
+	RetryIntervalWhenSendFailed int                  // 重试间隔。默认1000ms
+	TransferInterval            int64                // 转移延迟消息到主队列的间隔。默认10s
+	Delay                       int64                // 默认延迟时间。默认60秒
+	MaxLength                   int64                // 最大队列长度，0表示不限制。默认0
+	Overflow                    queue.OverflowPolicy // 超过最大队列长度时的处理策略。默认丢弃最晚到期的消息
+	OverflowTimeout             int64                // 阻塞策略等待消费者腾出空间的超时时间。默认5000ms
+	Metrics                     *queue.Metrics       // 指标
+	logger                      glog.ILoggerEntry    // logger
+	client                      cache.ICache         // cache client
 }
 
 func New(client cache.ICache, key string, logger glog.ILogger) *RedisDelayQueue {
@@ -30,6 +36,7 @@ func New(client cache.ICache, key string, logger glog.ILogger) *RedisDelayQueue
 		key:                         key,
 		TransferInterval:            10,
 		Delay:                       60,
+		OverflowTimeout:             5000,
 		Topic:                       key,
 		client:                      client,
 		ctx:                         context.Background(),
@@ -38,7 +45,10 @@ func New(client cache.ICache, key string, logger glog.ILogger) *RedisDelayQueue
 
 // Count 个数
 func (r *RedisDelayQueue) Count() int64 {
-	return 0
+	pipe := r.client.WithDB(r.DB).WithContext(r.ctx).Pipeline()
+	cmd := pipe.ZCard(r.ctx, r.key)
+	pipe.Exec(r.ctx)
+	return cmd.Val()
 }
 
 // IsEmpty 集合是否为空
@@ -47,53 +57,66 @@ func (r *RedisDelayQueue) IsEmpty() bool {
 
 }
 
-// Add 添加延迟消息
+// Add 添加延迟消息，MaxLength 已满被 Overflow 策略拒绝或等待超时时丢弃消息，记录警告日志并返回0，需要感知拒绝时使用 TryAdd
 func (r *RedisDelayQueue) Add(value interface{}, delay int64) int64 {
-	if value == nil {
-		return 0
+	rs, err := r.TryAdd(r.ctx, value, delay)
+	if err != nil {
+		r.logger.Warning(fmt.Sprintf("发布到队列[%s]失败：%s", r.Topic, err.Error()))
 	}
-	var target = time.Now().Unix() + delay
-	var rs int64
-	for i := 0; i < r.RetryTimesWhenSendFailed; i++ {
-		// 添加到有序集合的成员数量，不包括已经存在更新分数的成员
-		rs = r.client.WithDB(r.DB).WithContext(r.ctx).ZAdd(r.key, float64(target), value)
-		if rs >= 0 {
-			return rs
-		}
+	return rs
+}
 
-		r.logger.Debug(fmt.Sprintf("发布到队列[%s]失败！", r.Topic))
+// TryAdd 添加延迟消息，设置 MaxLength 后队列已满时按 Overflow 策略处理，拒绝或等待超时返回 *queue.OverflowError
+func (r *RedisDelayQueue) TryAdd(ctx context.Context, value interface{}, delay int64) (int64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	return r.add(ctx, time.Now().Unix()+delay, value)
+}
 
-		if i < r.RetryTimesWhenSendFailed {
-			time.Sleep(time.Duration(r.RetryIntervalWhenSendFailed) * time.Millisecond)
-		}
+// Adds 批量生产，整批被 Overflow 策略拒绝时丢弃，记录警告日志并返回0，需要感知拒绝时使用 TryAdds
+func (r *RedisDelayQueue) Adds(values ...interface{}) int64 {
+	rs, err := r.TryAdds(r.ctx, values...)
+	if err != nil {
+		r.logger.Warning(fmt.Sprintf("批量发布到队列[%s]失败：%s", r.Topic, err.Error()))
 	}
 	return rs
-
 }
 
-// Adds 批量生产
-func (r *RedisDelayQueue) Adds(values ...interface{}) int64 {
+// TryAdds 批量生产，使用默认延迟时间，队列容纳不下整批消息时按 Overflow 策略处理
+func (r *RedisDelayQueue) TryAdds(ctx context.Context, values ...interface{}) (int64, error) {
 	if values == nil || len(values) == 0 {
-		return 0
+		return 0, nil
 	}
+	return r.add(ctx, time.Now().Unix()+r.Delay, values...)
+}
 
-	var target = time.Now().Unix() + r.Delay
-
+func (r *RedisDelayQueue) add(ctx context.Context, target int64, values ...interface{}) (int64, error) {
+	if err := queue.WaitCapacity(ctx, r.Topic, r.Overflow, r.MaxLength, int64(len(values)), time.Duration(r.OverflowTimeout)*time.Millisecond, r.Count); err != nil {
+		return 0, err
+	}
 	var rs int64
 	for i := 0; i < r.RetryTimesWhenSendFailed; i++ {
 		// 添加到有序集合的成员数量，不包括已经存在更新分数的成员
-		rs = r.client.WithDB(r.DB).WithContext(r.ctx).ZAdd(r.key, float64(target), values)
+		rs = r.client.WithDB(r.DB).WithContext(r.ctx).ZAdd(r.key, float64(target), values...)
 		if rs >= 0 {
-			return rs
+			r.Metrics.Produced(ctx, "redis_delay", r.Topic, int64(len(values)))
+			if r.MaxLength > 0 && r.Overflow == queue.OverflowDropOldest {
+				// 丢弃最晚到期的消息，即将到期的消息优先保留
+				pipe := r.client.WithDB(r.DB).WithContext(r.ctx).Pipeline()
+				pipe.ZRemRangeByRank(r.ctx, r.key, r.MaxLength, -1)
+				pipe.Exec(r.ctx)
+			}
+			return rs, nil
 		}
 
-		r.logger.Debug(fmt.Sprintf("发布到队列[%s]失败！", r.Topic))
+		r.logger.Warning(fmt.Sprintf("发布到队列[%s]失败！", r.Topic))
 
 		if i < r.RetryTimesWhenSendFailed {
 			time.Sleep(time.Duration(r.RetryIntervalWhenSendFailed) * time.Millisecond)
 		}
 	}
-	return rs
+	return rs, queue.ErrPublishFailed
 
 }
 
@@ -116,6 +139,7 @@ func (r *RedisDelayQueue) TakeOne(timeout ...int64) string {
 
 		rs := r.client.WithDB(r.DB).WithContext(r.ctx).ZRangeByScore(r.key, 0, score, 0, 1)
 		if len(rs) > 0 && r.TryPop(rs[0]) {
+			r.Metrics.Consumed(r.ctx, "redis_delay", r.Topic, "", 1)
 			return rs[0]
 		}
 		// 是否需要等待
@@ -165,7 +189,7 @@ func (r *RedisDelayQueue) Take(count int64) []string {
 	}
 	var score = time.Now().Unix()
 
-	rs := r.client.WithDB(r.DB).WithContext(r.ctx).ZRangeByScore(r.key, 0, score, 0, 1)
+	rs := r.client.WithDB(r.DB).WithContext(r.ctx).ZRangeByScore(r.key, 0, score, 0, count)
 	if len(rs) <= 0 {
 		return nil
 	}
@@ -177,6 +201,7 @@ func (r *RedisDelayQueue) Take(count int64) []string {
 			arr = append(arr, item)
 		}
 	}
+	r.Metrics.Consumed(r.ctx, "redis_delay", r.Topic, "", int64(len(arr)))
 	return arr
 }
 
@@ -190,6 +215,22 @@ func (r *RedisDelayQueue) Acknowledge(keys ...string) int64 {
 	return -1
 }
 
+// Stats 队列长度及已到期未取走的消息数
+func (r *RedisDelayQueue) Stats() []queue.Stats {
+	pipe := r.client.WithDB(r.DB).WithContext(r.ctx).Pipeline()
+	length := pipe.ZCard(r.ctx, r.key)
+	due := pipe.ZCount(r.ctx, r.key, "0", fmt.Sprintf("%d", time.Now().Unix()))
+	if _, err := pipe.Exec(r.ctx); err != nil && err != redis.Nil {
+		return nil
+	}
+	return []queue.Stats{{Topic: r.Topic, Length: length.Val(), Due: due.Val()}}
+}
+
+// MetricsAsync 后台定时采集队列状态指标，interval 为采集间隔
+func (r *RedisDelayQueue) MetricsAsync(ctx context.Context, interval time.Duration) {
+	r.Metrics.Poll(ctx, "redis_delay", r.key, interval, r.Stats)
+}
+
 func (r *RedisDelayQueue) TransferAsync(ctx context.Context) {
 	go func() {
 		for {
@@ -0,0 +1,107 @@
+package queue_delay
+
+import (
+	"context"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/utils/cache"
+)
+
+func init() {
+	queue.Register("delay", func(client cache.ICache, logger glog.ILogger) queue.Broker {
+		return NewDelayQueue(client, logger)
+	})
+}
+
+// Producer 创建生产者
+func (r *DelayQueue) Producer(topic string) queue.Producer {
+	return &delayProducer{queue: r.GetDelayQueue(topic)}
+}
+
+// Consumer 创建消费者，延迟队列没有消费组，group 被忽略
+func (r *DelayQueue) Consumer(topic string, group string) queue.Consumer {
+	return &delayConsumer{queue: r.GetDelayQueue(topic)}
+}
+
+type delayProducer struct {
+	queue *RedisDelayQueue
+}
+
+func (p *delayProducer) Topic() string {
+	return p.queue.Topic
+}
+
+// Publish 发布延迟消息，消息未指定 Delay 时使用队列默认延迟时间
+func (p *delayProducer) Publish(ctx context.Context, msgs ...*queue.Message) ([]string, error) {
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		var delay = msg.Delay
+		if delay <= 0 {
+			delay = p.queue.Delay
+		}
+		msg.Topic = p.queue.Topic
+		data, err := queue.Encode(msg)
+		if err != nil {
+			return ids, err
+		}
+		if _, err = p.queue.TryAdd(ctx, string(data), delay); err != nil {
+			return ids, err
+		}
+		ids = append(ids, msg.Id)
+	}
+	return ids, nil
+}
+
+type delayConsumer struct {
+	queue *RedisDelayQueue
+}
+
+func (c *delayConsumer) Topic() string {
+	return c.queue.Topic
+}
+
+func (c *delayConsumer) Receive(ctx context.Context, count int64, timeout time.Duration) ([]*queue.Message, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	first := c.queue.TakeOne(int64(timeout / time.Second))
+	if len(first) == 0 {
+		return nil, nil
+	}
+	values := []string{first}
+	if count > 1 {
+		values = append(values, c.queue.Take(count-1)...)
+	}
+
+	msgs := make([]*queue.Message, 0, len(values))
+	for _, value := range values {
+		msg := queue.Decode([]byte(value))
+		msg.Topic = c.queue.Topic
+		msg.Receipt = value
+		msg.Attempts++
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// Ack 消费获取时已从有序集合删除，无需确认。Ack 之前进程退出时消息丢失，最多投递一次
+func (c *delayConsumer) Ack(ctx context.Context, msgs ...*queue.Message) error {
+	return nil
+}
+
+// Nack 重新放回队列，立即到期
+func (c *delayConsumer) Nack(ctx context.Context, msgs ...*queue.Message) error {
+	for _, msg := range msgs {
+		data, err := queue.Encode(msg)
+		if err != nil {
+			return err
+		}
+		if _, err = c.queue.TryAdd(ctx, string(data), 0); err != nil {
+			return err
+		}
+		c.queue.Metrics.Retried(ctx, "redis_delay", c.queue.Topic, "", 1)
+	}
+	return nil
+}
@@ -2,12 +2,14 @@ package queue_delay
 
 import (
 	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
 	"github.com/donetkit/contrib/utils/cache"
 )
 
 type DelayQueue struct {
-	client cache.ICache
-	logger glog.ILogger
+	client  cache.ICache
+	logger  glog.ILogger
+	metrics *queue.Metrics
 }
 
 func NewDelayQueue(client cache.ICache, logger glog.ILogger) *DelayQueue {
@@ -17,6 +19,14 @@ func NewDelayQueue(client cache.ICache, logger glog.ILogger) *DelayQueue {
 	}
 }
 
+// AddMetrics 设置指标，记录生产、消费数
+func (r *DelayQueue) AddMetrics(metrics *queue.Metrics) *DelayQueue {
+	r.metrics = metrics
+	return r
+}
+
 func (r *DelayQueue) GetDelayQueue(topic string) *RedisDelayQueue {
-	return New(r.client, topic, r.logger)
+	delay := New(r.client, topic, r.logger)
+	delay.Metrics = r.metrics
+	return delay
 }
@@ -0,0 +1,49 @@
+package queue_delay
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/db/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func newQueue(t *testing.T) *RedisDelayQueue {
+	server := miniredis.RunT(t)
+	port, _ := strconv.Atoi(server.Port())
+	client := redis.New(redis.WithAddr(server.Host()), redis.WithPort(port), redis.WithLogger(glog.New()))
+	return New(client, "delay", glog.New())
+}
+
+func TestDropLatestDue(t *testing.T) {
+	q := newQueue(t)
+	q.MaxLength = 2
+	_, err := q.TryAdd(context.Background(), "c", 30)
+	assert.Nil(t, err)
+	_, err = q.TryAdd(context.Background(), "a", 10)
+	assert.Nil(t, err)
+	// 超过最大长度，丢弃最晚到期的 c
+	_, err = q.TryAdd(context.Background(), "b", 20)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), q.Count())
+	assert.Equal(t, []string{"a", "b"}, q.client.ZRangeByScore(q.key, 0, math.MaxInt64, 0, 10))
+}
+
+func TestBatchTooLarge(t *testing.T) {
+	q := newQueue(t)
+	q.MaxLength = 2
+	q.Overflow = queue.OverflowReject
+	_, err := q.TryAdds(context.Background(), "a", "b", "c")
+	assert.True(t, errors.Is(err, queue.ErrBatchTooLarge))
+	assert.Equal(t, int64(0), q.Count())
+
+	q.Overflow = queue.OverflowBlock
+	_, err = q.TryAdds(context.Background(), "a", "b", "c")
+	assert.True(t, errors.Is(err, queue.ErrBatchTooLarge))
+}
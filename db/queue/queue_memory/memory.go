@@ -0,0 +1,235 @@
+package queue_memory
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/utils/cache"
+)
+
+func init() {
+	queue.Register("memory", func(client cache.ICache, logger glog.ILogger) queue.Broker {
+		return NewMemoryQueue()
+	})
+}
+
+// MemoryQueue 进程内队列，用于测试和单机部署
+// 每个消费组收到主题的全部消息，组内多个消费者竞争消费；主题还没有消费组时消息暂存，交给第一个创建的消费组
+type MemoryQueue struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	seq    int64
+}
+
+type memoryTopic struct {
+	groups map[string]*memoryGroup
+	// 还没有消费组时发布的消息
+	pending []*memoryEntry
+}
+
+type memoryEntry struct {
+	msg *queue.Message
+	due time.Time
+}
+
+type memoryGroup struct {
+	ready    []*memoryEntry
+	inflight map[string]*queue.Message
+	notify   chan struct{}
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{topics: make(map[string]*memoryTopic)}
+}
+
+// Producer 创建生产者
+func (r *MemoryQueue) Producer(topic string) queue.Producer {
+	return &memoryProducer{broker: r, topic: topic}
+}
+
+// Consumer 创建消费者，创建时即注册消费组
+func (r *MemoryQueue) Consumer(topic string, group string) queue.Consumer {
+	r.mu.Lock()
+	r.group(topic, group)
+	r.mu.Unlock()
+	return &memoryConsumer{broker: r, topic: topic, group: group}
+}
+
+// Count 消费组内未消费和未确认的消息个数
+func (r *MemoryQueue) Count(topic string, group string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.topics[topic]
+	if !ok {
+		return 0
+	}
+	g, ok := t.groups[group]
+	if !ok {
+		return 0
+	}
+	return int64(len(g.ready) + len(g.inflight))
+}
+
+// DeleteTopic 删除主题及其消费组
+func (r *MemoryQueue) DeleteTopic(topic string) error {
+	r.mu.Lock()
+	delete(r.topics, topic)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *MemoryQueue) topic(topic string) *memoryTopic {
+	t, ok := r.topics[topic]
+	if !ok {
+		t = &memoryTopic{groups: make(map[string]*memoryGroup)}
+		r.topics[topic] = t
+	}
+	return t
+}
+
+// group 返回消费组，不存在时创建，第一个消费组接收暂存的消息
+func (r *MemoryQueue) group(topic string, group string) *memoryGroup {
+	t := r.topic(topic)
+	g, ok := t.groups[group]
+	if !ok {
+		g = &memoryGroup{ready: t.pending, inflight: make(map[string]*queue.Message), notify: make(chan struct{})}
+		t.groups[group] = g
+		t.pending = nil
+	}
+	return g
+}
+
+func (g *memoryGroup) push(msg *queue.Message, due time.Time) {
+	g.ready = append(g.ready, &memoryEntry{msg: msg, due: due})
+	close(g.notify)
+	g.notify = make(chan struct{})
+}
+
+func copyMessage(msg *queue.Message) *queue.Message {
+	m := *msg
+	if msg.Headers != nil {
+		m.Headers = make(map[string]string, len(msg.Headers))
+		for k, v := range msg.Headers {
+			m.Headers[k] = v
+		}
+	}
+	return &m
+}
+
+type memoryProducer struct {
+	broker *MemoryQueue
+	topic  string
+}
+
+func (p *memoryProducer) Topic() string {
+	return p.topic
+}
+
+func (p *memoryProducer) Publish(ctx context.Context, msgs ...*queue.Message) ([]string, error) {
+	p.broker.mu.Lock()
+	defer p.broker.mu.Unlock()
+	t := p.broker.topic(p.topic)
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		msg.Topic = p.topic
+		if _, err := queue.Encode(msg); err != nil {
+			return ids, err
+		}
+		due := time.Now().Add(time.Duration(msg.Delay) * time.Second)
+		if len(t.groups) == 0 {
+			t.pending = append(t.pending, &memoryEntry{msg: copyMessage(msg), due: due})
+		}
+		for _, g := range t.groups {
+			g.push(copyMessage(msg), due)
+		}
+		ids = append(ids, msg.Id)
+	}
+	return ids, nil
+}
+
+type memoryConsumer struct {
+	broker *MemoryQueue
+	topic  string
+	group  string
+}
+
+func (c *memoryConsumer) Topic() string {
+	return c.topic
+}
+
+func (c *memoryConsumer) Receive(ctx context.Context, count int64, timeout time.Duration) ([]*queue.Message, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		c.broker.mu.Lock()
+		g := c.broker.group(c.topic, c.group)
+		now := time.Now()
+		var msgs []*queue.Message
+		var next time.Time
+		rest := g.ready[:0]
+		for _, e := range g.ready {
+			if int64(len(msgs)) < count && !e.due.After(now) {
+				c.broker.seq++
+				msg := e.msg
+				msg.Receipt = strconv.FormatInt(c.broker.seq, 10)
+				msg.Attempts++
+				g.inflight[msg.Receipt] = msg
+				msgs = append(msgs, copyMessage(msg))
+				continue
+			}
+			if e.due.After(now) && (next.IsZero() || e.due.Before(next)) {
+				next = e.due
+			}
+			rest = append(rest, e)
+		}
+		g.ready = rest
+		notify := g.notify
+		c.broker.mu.Unlock()
+
+		if len(msgs) > 0 || timeout <= 0 || !now.Before(deadline) {
+			return msgs, nil
+		}
+		if next.IsZero() || next.After(deadline) {
+			next = deadline
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (c *memoryConsumer) Ack(ctx context.Context, msgs ...*queue.Message) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	g := c.broker.group(c.topic, c.group)
+	for _, msg := range msgs {
+		delete(g.inflight, msg.Receipt)
+	}
+	return nil
+}
+
+// Nack 重新放回消费组，立即可被再次消费
+func (c *memoryConsumer) Nack(ctx context.Context, msgs ...*queue.Message) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	g := c.broker.group(c.topic, c.group)
+	for _, msg := range msgs {
+		if m, ok := g.inflight[msg.Receipt]; ok {
+			delete(g.inflight, msg.Receipt)
+			g.push(m, time.Now())
+		}
+	}
+	return nil
+}
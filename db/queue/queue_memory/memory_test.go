@@ -0,0 +1,129 @@
+package queue_memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishReceiveAck(t *testing.T) {
+	broker := NewMemoryQueue()
+	consumer := broker.Consumer("topic", "group")
+	producer := broker.Producer("topic")
+
+	msg := queue.NewMessage([]byte("hello"))
+	msg.SetHeader("k", "v")
+	ids, err := producer.Publish(context.Background(), msg)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{msg.Id}, ids)
+
+	msgs, err := consumer.Receive(context.Background(), 10, time.Second)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "hello", string(msgs[0].Body))
+	assert.Equal(t, "v", msgs[0].GetHeader("k"))
+	assert.Equal(t, int64(1), msgs[0].Attempts)
+	assert.Equal(t, int64(1), broker.Count("topic", "group"))
+
+	assert.Nil(t, consumer.Ack(context.Background(), msgs...))
+	assert.Equal(t, int64(0), broker.Count("topic", "group"))
+}
+
+func TestNackRedelivers(t *testing.T) {
+	broker := NewMemoryQueue()
+	consumer := broker.Consumer("topic", "")
+	broker.Producer("topic").Publish(context.Background(), queue.NewMessage([]byte("retry")))
+
+	msgs, _ := consumer.Receive(context.Background(), 1, 0)
+	assert.Len(t, msgs, 1)
+	assert.Nil(t, consumer.Nack(context.Background(), msgs...))
+
+	msgs, _ = consumer.Receive(context.Background(), 1, 0)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, int64(2), msgs[0].Attempts)
+}
+
+func TestPublishBeforeGroup(t *testing.T) {
+	broker := NewMemoryQueue()
+	producer := broker.Producer("topic")
+	producer.Publish(context.Background(), queue.NewMessage([]byte("early")))
+	assert.Equal(t, int64(0), broker.Count("topic", ""))
+
+	// 第一个消费组接收暂存的消息，之后不再暂存
+	c1 := broker.Consumer("topic", "g1")
+	c2 := broker.Consumer("topic", "g2")
+	producer.Publish(context.Background(), queue.NewMessage([]byte("late")))
+	assert.Equal(t, int64(2), broker.Count("topic", "g1"))
+	assert.Equal(t, int64(1), broker.Count("topic", "g2"))
+	assert.Empty(t, broker.topics["topic"].pending)
+	_, ok := broker.topics["topic"].groups[""]
+	assert.False(t, ok)
+
+	msgs, _ := c1.Receive(context.Background(), 2, 0)
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, "early", string(msgs[0].Body))
+	msgs, _ = c2.Receive(context.Background(), 2, 0)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "late", string(msgs[0].Body))
+}
+
+func TestGroupsFanOut(t *testing.T) {
+	broker := NewMemoryQueue()
+	c1 := broker.Consumer("topic", "g1")
+	c2 := broker.Consumer("topic", "g2")
+	broker.Producer("topic").Publish(context.Background(), queue.NewMessage([]byte("x")))
+
+	m1, _ := c1.Receive(context.Background(), 1, 0)
+	m2, _ := c2.Receive(context.Background(), 1, 0)
+	assert.Len(t, m1, 1)
+	assert.Len(t, m2, 1)
+}
+
+func TestDelayAndTimeout(t *testing.T) {
+	broker := NewMemoryQueue()
+	consumer := broker.Consumer("topic", "")
+	msg := queue.NewMessage([]byte("later"))
+	msg.Delay = 1
+	broker.Producer("topic").Publish(context.Background(), msg)
+
+	msgs, _ := consumer.Receive(context.Background(), 1, 0)
+	assert.Len(t, msgs, 0)
+
+	start := time.Now()
+	msgs, _ = consumer.Receive(context.Background(), 1, 3*time.Second)
+	assert.Len(t, msgs, 1)
+	assert.True(t, time.Since(start) < 2*time.Second)
+}
+
+func TestConsumeByName(t *testing.T) {
+	broker, err := queue.NewBroker("memory", nil, nil)
+	assert.Nil(t, err)
+	_, err = queue.NewBroker("unknown", nil, nil)
+	assert.True(t, errors.Is(err, queue.ErrUnknownBroker))
+
+	broker.Producer("topic").Publish(context.Background(), queue.NewMessage([]byte("a")))
+	ctx, cancel := context.WithCancel(context.Background())
+	var attempts int64
+	done := make(chan struct{})
+	go func() {
+		queue.Consume(ctx, broker.Consumer("topic", ""), func(ctx context.Context, msg *queue.Message) error {
+			attempts = msg.Attempts
+			if msg.Attempts < 2 {
+				return errors.New("fail once")
+			}
+			cancel()
+			return nil
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consume did not finish")
+	}
+	assert.Equal(t, int64(2), attempts)
+}
@@ -0,0 +1,64 @@
+package queue_outbox
+
+import (
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/tracer"
+)
+
+// Option for outbox
+type Option func(*Outbox)
+
+// WithLogger set logger function
+func WithLogger(logger glog.ILogger) Option {
+	return func(o *Outbox) {
+		o.logger = logger.WithField("Queue-Outbox", "Queue-Outbox")
+	}
+}
+
+// WithTracer 写入时保存链路信息，转发时作为生产者链路的父链路
+func WithTracer(tracer *tracer.Server) Option {
+	return func(o *Outbox) {
+		o.tracer = tracer
+	}
+}
+
+// WithBatchSize 每次转发的最大消息数。默认100
+func WithBatchSize(batchSize int) Option {
+	return func(o *Outbox) {
+		if batchSize > 0 {
+			o.batchSize = batchSize
+		}
+	}
+}
+
+// WithInterval 没有消息时的轮询间隔。默认1秒
+func WithInterval(interval time.Duration) Option {
+	return func(o *Outbox) {
+		if interval > 0 {
+			o.interval = interval
+		}
+	}
+}
+
+// WithRetention 已发送消息的保留时间，0表示不清理。默认7天
+func WithRetention(retention time.Duration) Option {
+	return func(o *Outbox) {
+		o.retention = retention
+	}
+}
+
+// WithMaxAttempts 最大发送失败次数，达到后消息转为 StatusFailed，避免阻塞同一聚合键的后续消息，0表示不限制。默认10次
+func WithMaxAttempts(maxAttempts int64) Option {
+	return func(o *Outbox) {
+		o.maxAttempt = maxAttempts
+	}
+}
+
+// WithSkipLocked 是否使用 FOR UPDATE SKIP LOCKED，默认按数据库类型判断，mysql 和 postgres 使用
+func WithSkipLocked(skipLocked bool) Option {
+	return func(o *Outbox) {
+		o.skipLocked = &skipLocked
+	}
+}
@@ -0,0 +1,253 @@
+package queue_outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/db/queue/queue_stream"
+	"github.com/donetkit/contrib/tracer"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusPending = 0 // 待发送
+	StatusSent    = 1 // 已发送
+	StatusFailed  = 2 // 发送失败次数达到上限，不再转发，需人工处理
+)
+
+// Record 发件箱记录，与业务数据在同一个事务内写入
+type Record struct {
+	Id           uint64 `gorm:"primaryKey;autoIncrement"`
+	MessageId    string `gorm:"size:64;not null"`
+	Topic        string `gorm:"size:255;not null"`
+	AggregateKey string `gorm:"size:255;not null;index:idx_queue_outbox_pending,priority:2"` // 聚合键，同一聚合键的消息按写入顺序发送
+	ContentType  string `gorm:"size:64"`
+	Headers      string `gorm:"type:text"`
+	Body         []byte
+	Status       int    `gorm:"not null;default:0;index:idx_queue_outbox_pending,priority:1"`
+	Attempts     int64  `gorm:"not null;default:0"` // 发送失败次数
+	LastError    string `gorm:"size:512"`
+	StreamId     string `gorm:"size:64"` // 发送后的 Stream 消息编号
+	CreatedAt    time.Time
+	SentAt       *time.Time
+}
+
+func (Record) TableName() string {
+	return "queue_outbox"
+}
+
+func (r *Record) message() *queue.Message {
+	msg := &queue.Message{
+		Id:          r.MessageId,
+		Topic:       r.Topic,
+		Key:         r.AggregateKey,
+		ContentType: r.ContentType,
+		Body:        r.Body,
+		Timestamp:   r.CreatedAt.UnixMilli(),
+	}
+	if len(r.Headers) > 0 {
+		json.Unmarshal([]byte(r.Headers), &msg.Headers)
+	}
+	return msg
+}
+
+// Outbox 事务发件箱。业务在自己的事务内调用 Add 写入消息，Relay 将消息转发到 Redis Stream
+// 转发至少一次，进程在发送后提交前退出会导致重复发送，消费端可配合 queue.Dedup 去重
+type Outbox struct {
+	db         *gorm.DB
+	streams    *queue_stream.StreamQueue
+	logger     glog.ILoggerEntry
+	tracer     *tracer.Server
+	batchSize  int
+	interval   time.Duration
+	retention  time.Duration
+	maxAttempt int64
+	skipLocked *bool
+	locker     sync.Mutex
+	queues     map[string]*queue_stream.RedisStream
+	nextClean  time.Time
+}
+
+func New(db *gorm.DB, streams *queue_stream.StreamQueue, opts ...Option) *Outbox {
+	o := &Outbox{
+		db:         db,
+		streams:    streams,
+		batchSize:  100,
+		interval:   time.Second,
+		retention:  7 * 24 * time.Hour,
+		maxAttempt: 10,
+		queues:     make(map[string]*queue_stream.RedisStream),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// AutoMigrate 创建发件箱表
+func (o *Outbox) AutoMigrate() error {
+	return o.db.AutoMigrate(&Record{})
+}
+
+// Add 在调用方的事务内写入消息，value 可以是 *queue.Message 或任意可序列化的值
+// 消息Key作为聚合键，为空时每条消息独立，不保证顺序
+func (o *Outbox) Add(tx *gorm.DB, topic string, value interface{}) error {
+	msg, err := queue.NewEnvelope(value)
+	if err != nil {
+		return err
+	}
+	msg.Topic = topic
+	queue.Inject(tx.Statement.Context, o.tracer, msg)
+	if _, err = queue.Encode(msg); err != nil {
+		return err
+	}
+	var key = msg.Key
+	if len(key) == 0 {
+		key = msg.Id
+	}
+	var headers string
+	if len(msg.Headers) > 0 {
+		data, err := json.Marshal(msg.Headers)
+		if err != nil {
+			return err
+		}
+		headers = string(data)
+	}
+	return tx.Create(&Record{
+		MessageId:    msg.Id,
+		Topic:        topic,
+		AggregateKey: key,
+		ContentType:  msg.ContentType,
+		Headers:      headers,
+		Body:         msg.Body,
+		Status:       StatusPending,
+	}).Error
+}
+
+// supportSkipLocked 数据库是否支持 SELECT ... FOR UPDATE SKIP LOCKED
+// 不支持时只能单个 Relay 运行
+func (o *Outbox) supportSkipLocked() bool {
+	if o.skipLocked != nil {
+		return *o.skipLocked
+	}
+	switch o.db.Dialector.Name() {
+	case "mysql", "postgres":
+		return true
+	}
+	return false
+}
+
+func (o *Outbox) stream(topic string) *queue_stream.RedisStream {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	stream, ok := o.queues[topic]
+	if !ok {
+		stream = o.streams.GetStreamQueue(topic)
+		o.queues[topic] = stream
+	}
+	return stream
+}
+
+// Relay 转发一批消息，返回发送成功的个数
+// 每个聚合键只取最早的一条待发送消息，发送失败时后续消息不会越过它，保证同一聚合键有序
+// 失败次数达到 WithMaxAttempts 后消息转为 StatusFailed，同一聚合键的后续消息继续发送
+func (o *Outbox) Relay(ctx context.Context) (int, error) {
+	var sent int
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		head := tx.Session(&gorm.Session{NewDB: true}).Model(&Record{}).
+			Select("MIN(id)").
+			Where("status = ?", StatusPending).
+			Group("aggregate_key")
+		query := tx.Where("status = ? AND id IN (?)", StatusPending, head).Order("id").Limit(o.batchSize)
+		if o.supportSkipLocked() {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		var records []Record
+		if err := query.Find(&records).Error; err != nil {
+			return err
+		}
+		for i := range records {
+			record := &records[i]
+			msg := record.message()
+			id, err := o.stream(record.Topic).TryAdd(queue.Extract(ctx, o.tracer, msg), msg)
+			if err != nil {
+				if o.logger != nil {
+					o.logger.Errorf("发件箱消息发送失败：%s %s %s", record.Topic, record.MessageId, err.Error())
+				}
+				values := map[string]interface{}{
+					"attempts":   gorm.Expr("attempts + 1"),
+					"last_error": err.Error(),
+				}
+				if o.maxAttempt > 0 && record.Attempts+1 >= o.maxAttempt {
+					values["status"] = StatusFailed
+					if o.logger != nil {
+						o.logger.Errorf("发件箱消息发送失败%d次，不再转发：%s %s", record.Attempts+1, record.Topic, record.MessageId)
+					}
+				}
+				if err := tx.Model(record).Updates(values).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Model(record).Updates(map[string]interface{}{
+				"status":    StatusSent,
+				"stream_id": id,
+				"sent_at":   time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+			sent++
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// Clean 删除保留时间之前已发送的消息
+func (o *Outbox) Clean(ctx context.Context) (int64, error) {
+	if o.retention <= 0 {
+		return 0, nil
+	}
+	rs := o.db.WithContext(ctx).
+		Where("status = ? AND sent_at < ?", StatusSent, time.Now().Add(-o.retention)).
+		Delete(&Record{})
+	return rs.RowsAffected, rs.Error
+}
+
+// RelayAsync 转发大循环，没有消息时按间隔轮询，每分钟清理一次已发送消息
+func (o *Outbox) RelayAsync(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return // 退出了...
+			default:
+			}
+			sent, err := o.Relay(ctx)
+			if err != nil && o.logger != nil {
+				o.logger.Error(fmt.Sprintf("发件箱转发失败：%s", err.Error()))
+			}
+			if now := time.Now(); now.After(o.nextClean) {
+				o.nextClean = now.Add(time.Minute)
+				if _, err = o.Clean(ctx); err != nil && o.logger != nil {
+					o.logger.Error(fmt.Sprintf("发件箱清理失败：%s", err.Error()))
+				}
+			}
+			if sent > 0 && err == nil {
+				continue
+			}
+			// 没有消息，歇一会
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(o.interval):
+			}
+		}
+	}()
+}
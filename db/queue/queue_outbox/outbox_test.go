@@ -0,0 +1,150 @@
+package queue_outbox
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/db/queue/queue_stream"
+	"github.com/donetkit/contrib/db/redis"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newOutbox(t *testing.T, opts ...Option) *Outbox {
+	server := miniredis.RunT(t)
+	port, _ := strconv.Atoi(server.Port())
+	client := redis.New(redis.WithAddr(server.Host()), redis.WithPort(port))
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	assert.Nil(t, err)
+	// 内存数据库每个连接独立，只使用一个连接
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	o := New(db, queue_stream.NewStreamQueue(client, glog.New()), opts...)
+	assert.Nil(t, o.AutoMigrate())
+	return o
+}
+
+func (o *Outbox) add(t *testing.T, topic string, key string, body string) {
+	msg := queue.NewMessage([]byte(body))
+	msg.Key = key
+	assert.Nil(t, o.db.Transaction(func(tx *gorm.DB) error {
+		return o.Add(tx, topic, msg)
+	}))
+}
+
+// bodies 按 Stream 顺序返回主题的消息体
+func (o *Outbox) bodies(topic string) []string {
+	stream := o.stream(topic)
+	var list []string
+	for _, item := range stream.Range("", "") {
+		list = append(list, string(queue.Decode([]byte(stream.MessageValue(item))).Body))
+	}
+	return list
+}
+
+func (o *Outbox) record(t *testing.T, body string) Record {
+	var record Record
+	assert.Nil(t, o.db.Where("body = ?", []byte(body)).First(&record).Error)
+	return record
+}
+
+func TestRelayOrder(t *testing.T) {
+	o := newOutbox(t)
+	o.add(t, "orders", "a", "a1")
+	o.add(t, "orders", "a", "a2")
+	o.add(t, "orders", "b", "b1")
+	o.add(t, "orders", "a", "a3")
+
+	// 每次转发每个聚合键最早的一条待发送消息
+	sent, err := o.Relay(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"a1", "b1"}, o.bodies("orders"))
+
+	for _, want := range []int{1, 1, 0} {
+		sent, err = o.Relay(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, want, sent)
+	}
+	assert.Equal(t, []string{"a1", "b1", "a2", "a3"}, o.bodies("orders"))
+	record := o.record(t, "a3")
+	assert.Equal(t, StatusSent, record.Status)
+	assert.NotEmpty(t, record.StreamId)
+	assert.NotNil(t, record.SentAt)
+}
+
+func TestRelayFailed(t *testing.T) {
+	o := newOutbox(t, WithMaxAttempts(2))
+	// 队列已满，拒绝新消息
+	stream := o.stream("orders")
+	stream.Add("old")
+	stream.MaxLength = 1
+	stream.Overflow = queue.OverflowReject
+	o.add(t, "orders", "a", "a1")
+	o.add(t, "orders", "a", "a2")
+
+	sent, err := o.Relay(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sent)
+	record := o.record(t, "a1")
+	assert.Equal(t, StatusPending, record.Status)
+	assert.Equal(t, int64(1), record.Attempts)
+	assert.Contains(t, record.LastError, "is full")
+	// a2 排在发送失败的 a1 之后
+	assert.Equal(t, StatusPending, o.record(t, "a2").Status)
+	assert.Equal(t, int64(0), o.record(t, "a2").Attempts)
+
+	// 达到最大失败次数后 a1 转为失败，不再阻塞同一聚合键
+	sent, err = o.Relay(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sent)
+	record = o.record(t, "a1")
+	assert.Equal(t, StatusFailed, record.Status)
+	assert.Equal(t, int64(2), record.Attempts)
+
+	stream.MaxLength = 0
+	sent, err = o.Relay(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"old", "a2"}, o.bodies("orders"))
+	assert.Equal(t, StatusFailed, o.record(t, "a1").Status)
+}
+
+func TestRelayCrashBeforeMark(t *testing.T) {
+	o := newOutbox(t)
+	o.add(t, "orders", "a", "a1")
+
+	// 发送后、标记已发送前进程退出
+	crash := errors.New("crash")
+	assert.Nil(t, o.db.Callback().Update().Before("gorm:update").Register("crash", func(db *gorm.DB) {
+		if _, ok := db.Statement.Dest.(map[string]interface{})["status"]; ok {
+			db.AddError(crash)
+		}
+	}))
+	sent, err := o.Relay(context.Background())
+	assert.ErrorIs(t, err, crash)
+	assert.Equal(t, 0, sent)
+	assert.Equal(t, []string{"a1"}, o.bodies("orders"))
+	assert.Equal(t, StatusPending, o.record(t, "a1").Status)
+	assert.Nil(t, o.db.Callback().Update().Remove("crash"))
+
+	// 重新发送，消费端收到两条相同Id的消息
+	sent, err = o.Relay(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, StatusSent, o.record(t, "a1").Status)
+	stream := o.stream("orders")
+	items := stream.Range("", "")
+	assert.Len(t, items, 2)
+	first := queue.Decode([]byte(stream.MessageValue(items[0])))
+	second := queue.Decode([]byte(stream.MessageValue(items[1])))
+	assert.Equal(t, first.Id, second.Id)
+	assert.Equal(t, "a1", string(second.Body))
+}
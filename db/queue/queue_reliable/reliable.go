@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
 	"github.com/donetkit/contrib/db/queue/queue_delay"
+	"github.com/donetkit/contrib/tracer"
 	"github.com/donetkit/contrib/utils/cache"
 	"github.com/donetkit/contrib/utils/gjson"
 	"github.com/donetkit/contrib/utils/grand"
 	chost "github.com/donetkit/contrib/utils/host"
+	"github.com/go-redis/redis/v8"
 	"github.com/shirou/gopsutil/host"
 	"os"
 	"strings"
@@ -32,21 +35,27 @@ type RedisQueueStatus struct {
 }
 
 type RedisReliableQueue struct {
-	ctx                         context.Context   // Context
-	DB                          int               // redis DB 默认为 0
-	key                         string            // 消息队列key
-	ThrowOnFailure              bool              // 失败时抛出异常。默认false
-	RetryTimesWhenSendFailed    int               // 发送消息失败时的重试次数。默认3次
-	RetryIntervalWhenSendFailed int               // 重试间隔。默认1000ms
-	AckKey                      string            // 用于确认的列表
-	RetryInterval               int64             // 重新处理确认队列中死信的间隔。默认60s
-	MinPipeline                 int64             // 最小管道阈值，达到该值时使用管道，默认3
-	count                       int64             // 个数
-	IsEmpty                     bool              // 是否为空
-	Status                      RedisQueueStatus  // 消费状态
-	logger                      glog.ILoggerEntry // logger
-	l                           glog.ILogger      // logger
-	client                      cache.ICache      // cache client
+	ctx                         context.Context      // Context
+	DB                          int                  // redis DB 默认为 0
+	key                         string               // 消息队列key
+	ThrowOnFailure              bool                 // 失败时抛出异常。默认false
+	RetryTimesWhenSendFailed    int                  // 发送消息失败时的重试次数。默认3次
+	RetryIntervalWhenSendFailed int                  // 重试间隔。默认1000ms
+	AckKey                      string               // 用于确认的列表
+	RetryInterval               int64                // 重新处理确认队列中死信的间隔。默认60s
+	MinPipeline                 int64                // 最小管道阈值，达到该值时使用管道，默认3
+	MaxLength                   int64                // 最大队列长度，0表示不限制。默认0
+	Overflow                    queue.OverflowPolicy // 超过最大队列长度时的处理策略。默认丢弃最旧消息
+	OverflowTimeout             int64                // 阻塞策略等待消费者腾出空间的超时时间。默认5000ms
+	count                       int64                // 个数
+	IsEmpty                     bool                 // 是否为空
+	Status                      RedisQueueStatus     // 消费状态
+	Envelope                    bool                 // 生产时使用标准信封包装消息，携带消息头和链路信息。默认true，旧的消费者无法解析信封时设为false
+	Tracer                      *tracer.Server       // 链路追踪
+	Metrics                     *queue.Metrics       // 指标
+	logger                      glog.ILoggerEntry    // logger
+	l                           glog.ILogger         // logger
+	client                      cache.ICache         // cache client
 }
 
 func CreateStatus() RedisQueueStatus {
@@ -72,35 +81,100 @@ func New(client cache.ICache, key string, logger glog.ILogger) *RedisReliableQue
 		RetryIntervalWhenSendFailed: 1000,
 		RetryInterval:               60,
 		MinPipeline:                 3,
+		OverflowTimeout:             5000,
 		logger:                      logger.WithField("mq_redis_reliable", "mq_redis_reliable"),
 		l:                           logger,
 		AckKey:                      fmt.Sprintf("%s:Ack:%s", key, _Status.Key),
+		Envelope:                    true,
 		client:                      client,
 		ctx:                         context.Background(),
 	}
 }
 
-// Add 批量生产添加
+// Add 批量生产添加，MaxLength 已满被 Overflow 策略拒绝或等待超时时丢弃整批消息，记录警告日志并返回0，需要感知拒绝时使用 TryAdd
 func (r *RedisReliableQueue) Add(values ...interface{}) int64 {
+	return r.AddContext(r.ctx, values...)
+}
+
+// AddContext 批量生产添加，ctx 中的链路信息写入消息头，被拒绝时与 Add 相同丢弃消息
+func (r *RedisReliableQueue) AddContext(ctx context.Context, values ...interface{}) int64 {
+	rs, err := r.TryAdd(ctx, values...)
+	if err != nil {
+		r.logger.Warning(fmt.Sprintf("发布到队列[%s]失败：%s", r.key, err.Error()))
+	}
+	return rs
+}
+
+// TryAdd 批量生产添加，设置 MaxLength 后队列容纳不下时按 Overflow 策略处理，拒绝或等待超时返回 *queue.OverflowError
+// 返回插入后的队列长度
+func (r *RedisReliableQueue) TryAdd(ctx context.Context, values ...interface{}) (int64, error) {
 	if values == nil || len(values) == 0 {
-		return 0
+		return 0, nil
+	}
+	if err := queue.WaitCapacity(ctx, r.key, r.Overflow, r.MaxLength, int64(len(values)), time.Duration(r.OverflowTimeout)*time.Millisecond, r.Count); err != nil {
+		return 0, err
 	}
 
+	values = r.envelope(ctx, values)
 	var rs int64
 	for i := 0; i < r.RetryTimesWhenSendFailed; i++ {
 		// 返回插入后的LIST长度。Redis执行命令不会失败，因此正常插入不应该返回0，如果返回了0或者空，可能是中间代理出了问题
 		rs = r.client.WithDB(r.DB).WithContext(r.ctx).LPush(r.key, values...)
 		if rs > 0 {
-			return rs
+			r.Metrics.Produced(ctx, "redis_reliable", r.key, int64(len(values)))
+			if r.MaxLength > 0 && rs > r.MaxLength && r.Overflow == queue.OverflowDropOldest {
+				// 从右侧消费，保留左侧最新的消息
+				pipe := r.client.WithDB(r.DB).WithContext(r.ctx).Pipeline()
+				pipe.LTrim(r.ctx, r.key, 0, r.MaxLength-1)
+				pipe.Exec(r.ctx)
+				rs = r.MaxLength
+			}
+			return rs, nil
 		}
-		r.logger.Debug(fmt.Sprintf("发布到队列[%s]失败！", r.key))
+		r.logger.Warning(fmt.Sprintf("发布到队列[%s]失败！", r.key))
 
 		if i < r.RetryTimesWhenSendFailed {
 			time.Sleep(time.Millisecond * time.Duration(r.RetryIntervalWhenSendFailed))
 		}
 	}
-	return rs
+	return rs, queue.ErrPublishFailed
+}
 
+// Count 队列长度
+func (r *RedisReliableQueue) Count() int64 {
+	pipe := r.client.WithDB(r.DB).WithContext(r.ctx).Pipeline()
+	cmd := pipe.LLen(r.ctx, r.key)
+	pipe.Exec(r.ctx)
+	return cmd.Val()
+}
+
+// envelope 使用标准信封包装消息并注入链路信息，*queue.Message 总是按信封编码
+func (r *RedisReliableQueue) envelope(ctx context.Context, values []interface{}) []interface{} {
+	list := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		msg, ok := value.(*queue.Message)
+		if !ok {
+			if !r.Envelope {
+				list = append(list, value)
+				continue
+			}
+			var err error
+			if msg, err = queue.NewEnvelope(value); err != nil {
+				list = append(list, value)
+				continue
+			}
+		}
+		msg.Topic = r.key
+		_, span := queue.StartProducerSpan(ctx, r.Tracer, "redis_reliable", msg)
+		data, err := queue.Encode(msg)
+		span.End()
+		if err != nil {
+			list = append(list, value)
+			continue
+		}
+		list = append(list, string(data))
+	}
+	return list
 }
 
 // TakeOne 消费获取，从Key弹出并备份到AckKey，支持阻塞
@@ -120,6 +194,7 @@ func (r *RedisReliableQueue) TakeOne(timeout ...int64) string {
 	}
 	if len(rs) > 0 {
 		_Status.Consumes++
+		r.Metrics.Consumed(r.ctx, "redis_reliable", r.key, "", 1)
 	}
 	return rs
 }
@@ -139,6 +214,7 @@ func (r *RedisReliableQueue) Take(count ...int) []string {
 			values = append(values, rs)
 		}
 	}
+	r.Metrics.Consumed(r.ctx, "redis_reliable", r.key, "", int64(len(values)))
 	return values
 
 }
@@ -153,10 +229,24 @@ func (r *RedisReliableQueue) Acknowledge(keys ...string) int64 {
 			rs += val
 		}
 	}
+	r.Metrics.Acked(r.ctx, "redis_reliable", r.key, "", rs)
 	return rs
 
 }
 
+// requeue 消息重新放回队列并从AckKey中删除，不计入生产数和确认数
+func (r *RedisReliableQueue) requeue(ctx context.Context, msg *queue.Message) error {
+	data, err := queue.Encode(msg)
+	if err != nil {
+		return err
+	}
+	pipe := r.client.WithDB(r.DB).WithContext(ctx).Pipeline()
+	pipe.LPush(ctx, r.key, string(data))
+	pipe.LRem(ctx, r.AckKey, 1, msg.Receipt)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
 var _delay *queue_delay.RedisDelayQueue
 
 // InitDelay 初始化延迟队列功能。生产者自动初始化，消费者最好能够按队列初始化一次
@@ -188,15 +278,20 @@ func (r *RedisReliableQueue) Publish(messages map[string]interface{}, expire int
 	if messages == nil {
 		return 0
 	}
+	// 开启 Envelope 时消息体按信封保存，Consume 可以关联生产者链路
+	bodies := make(map[string]interface{}, len(messages))
+	for key, val := range messages {
+		bodies[key] = r.envelope(r.ctx, []interface{}{val})[0]
+	}
 	var keys []string
 	if expire > 0 {
-		for key, val := range messages {
+		for key, val := range bodies {
 			keys = append(keys, key)
 			r.client.WithDB(r.DB).WithContext(r.ctx).SetEX(key, val, time.Duration(expire)*time.Second)
 		}
 
 	} else {
-		for key, val := range messages {
+		for key, val := range bodies {
 			keys = append(keys, key)
 			r.client.WithDB(r.DB).WithContext(r.ctx).Set(key, val, 0)
 		}
@@ -225,6 +320,7 @@ func (r *RedisReliableQueue) Consume(fn func(value interface{}) int64, timeOut .
 		return 0
 	}
 	_Status.Consumes++
+	r.Metrics.Consumed(r.ctx, "redis_reliable", r.key, "", 1)
 	// 取出消息。如果重复消费，或者业务层已经删除消息，此时将拿不到
 	result, _ := r.client.WithDB(r.DB).WithContext(r.ctx).GetString(msgId)
 	if result == "" {
@@ -233,7 +329,14 @@ func (r *RedisReliableQueue) Consume(fn func(value interface{}) int64, timeOut .
 		return 0
 	}
 
+	// 消息体为标准信封时，消费者链路作为生产者链路的子链路
+	msg := queue.Decode([]byte(result))
+	msg.Topic = r.key
+	_, span := queue.StartConsumerSpan(r.ctx, r.Tracer, "redis_reliable", msg)
+	start := time.Now()
 	var rs = fn(result)
+	r.Metrics.Handled(r.ctx, "redis_reliable", r.key, "", time.Since(start))
+	span.End()
 
 	// 确认并删除消息
 	r.client.WithDB(r.DB).WithContext(r.ctx).Delete(msgId)
@@ -286,12 +389,13 @@ func (r *RedisReliableQueue) RetryAck() {
 		for _, item := range data {
 			r.logger.Debug(fmt.Sprintf("定时回滚死信：%s", item))
 		}
+		r.Metrics.Retried(r.ctx, "redis_reliable", r.key, "", int64(len(data)))
 		// 更新状态
 		r.UpdateStatus()
 		// 处理其它消费者遗留下来的死信，需要抢夺全局清理权，减少全局扫描次数
 		result := r.client.WithDB(r.DB).WithContext(r.ctx).SetNX(fmt.Sprintf("%s:AllStatus", _Key), _Status, time.Duration(r.RetryInterval)*time.Second)
 		if result {
-			r.RollbackAllAck()
+			r.Metrics.Retried(r.ctx, "redis_reliable", r.key, "", r.RollbackAllAck())
 		}
 
 	}
@@ -372,6 +476,40 @@ func (r *RedisReliableQueue) RollbackAllAck() int64 {
 
 }
 
+// Stats 队列长度及所有消费者确认列表中未确认的消息数
+func (r *RedisReliableQueue) Stats() []queue.Stats {
+	client := r.client.WithDB(r.DB).WithContext(r.ctx)
+	var ackKeys []string
+	var cursor uint64
+	for {
+		keys, next := client.Scan(cursor, fmt.Sprintf("%s:Ack:*", r.key), 1000)
+		ackKeys = append(ackKeys, keys...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	pipe := client.Pipeline()
+	length := pipe.LLen(r.ctx, r.key)
+	pending := make([]*redis.IntCmd, len(ackKeys))
+	for i, ackKey := range ackKeys {
+		pending[i] = pipe.LLen(r.ctx, ackKey)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil && err != redis.Nil {
+		return nil
+	}
+	stats := queue.Stats{Topic: r.key, Length: length.Val()}
+	for _, cmd := range pending {
+		stats.Pending += cmd.Val()
+	}
+	return []queue.Stats{stats}
+}
+
+// MetricsAsync 后台定时采集队列状态指标，interval 为采集间隔
+func (r *RedisReliableQueue) MetricsAsync(ctx context.Context, interval time.Duration) {
+	r.Metrics.Poll(ctx, "redis_reliable", r.key, interval, r.Stats)
+}
+
 func stringArray(keys *[]string, key string) bool {
 	for _, val := range *keys {
 		if val == key {
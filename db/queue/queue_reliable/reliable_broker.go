@@ -0,0 +1,108 @@
+package queue_reliable
+
+import (
+	"context"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/utils/cache"
+)
+
+func init() {
+	queue.Register("reliable", func(client cache.ICache, logger glog.ILogger) queue.Broker {
+		return NewReliableQueue(client, logger)
+	})
+}
+
+// Producer 创建生产者
+func (r *ReliableQueue) Producer(topic string) queue.Producer {
+	return &reliableProducer{topic: topic, queue: r.GetReliableQueue(topic)}
+}
+
+// Consumer 创建消费者，可靠队列没有消费组，group 被忽略
+func (r *ReliableQueue) Consumer(topic string, group string) queue.Consumer {
+	return &reliableConsumer{topic: topic, queue: r.GetReliableQueue(topic)}
+}
+
+type reliableProducer struct {
+	topic string
+	queue *RedisReliableQueue
+}
+
+func (p *reliableProducer) Topic() string {
+	return p.topic
+}
+
+func (p *reliableProducer) Publish(ctx context.Context, msgs ...*queue.Message) ([]string, error) {
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Delay > 0 {
+			return ids, queue.ErrDelayNotSupported
+		}
+		if _, err := p.queue.TryAdd(ctx, msg); err != nil {
+			return ids, err
+		}
+		ids = append(ids, msg.Id)
+	}
+	return ids, nil
+}
+
+type reliableConsumer struct {
+	topic string
+	queue *RedisReliableQueue
+}
+
+func (c *reliableConsumer) Topic() string {
+	return c.topic
+}
+
+func (c *reliableConsumer) Receive(ctx context.Context, count int64, timeout time.Duration) ([]*queue.Message, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	// 负数表示直接返回，不阻塞
+	var block int64 = -1
+	if timeout > 0 {
+		block = int64(timeout / time.Second)
+		if block == 0 {
+			block = 1
+		}
+	}
+	first := c.queue.TakeOne(block)
+	if len(first) == 0 {
+		return nil, nil
+	}
+	values := []string{first}
+	if count > 1 {
+		values = append(values, c.queue.Take(int(count-1))...)
+	}
+
+	msgs := make([]*queue.Message, 0, len(values))
+	for _, value := range values {
+		msg := queue.Decode([]byte(value))
+		msg.Topic = c.topic
+		msg.Receipt = value
+		msg.Attempts++
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (c *reliableConsumer) Ack(ctx context.Context, msgs ...*queue.Message) error {
+	for _, msg := range msgs {
+		c.queue.Acknowledge(msg.Receipt)
+	}
+	return nil
+}
+
+// Nack 重新放回队列尾部，投递次数随消息保存
+func (c *reliableConsumer) Nack(ctx context.Context, msgs ...*queue.Message) error {
+	for _, msg := range msgs {
+		if err := c.queue.requeue(ctx, msg); err != nil {
+			return err
+		}
+		c.queue.Metrics.Retried(ctx, "redis_reliable", c.queue.key, "", 1)
+	}
+	return nil
+}
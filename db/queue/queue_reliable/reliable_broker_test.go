@@ -0,0 +1,113 @@
+package queue_reliable
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/db/redis"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// provider 总是返回同一个 counters
+type provider struct {
+	noop.MeterProvider
+	meter *counters
+}
+
+func (p provider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return p.meter
+}
+
+// counters 记录各计数器的累计值
+type counters struct {
+	noop.Meter
+	locker sync.Mutex
+	values map[string]int64
+}
+
+func (m *counters) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return &counter{counters: m, name: name}, nil
+}
+
+func (m *counters) get(name string) int64 {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.values[name]
+}
+
+type counter struct {
+	noop.Int64Counter
+	counters *counters
+	name     string
+}
+
+func (c *counter) Add(_ context.Context, incr int64, _ ...metric.AddOption) {
+	c.counters.locker.Lock()
+	defer c.counters.locker.Unlock()
+	c.counters.values[c.name] += incr
+}
+
+func newQueue(t *testing.T) (*ReliableQueue, *counters, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	port, _ := strconv.Atoi(server.Port())
+	client := redis.New(redis.WithAddr(server.Host()), redis.WithPort(port), redis.WithLogger(glog.New()))
+	m := &counters{values: make(map[string]int64)}
+	return NewReliableQueue(client, glog.New()).AddMetrics(queue.NewMetrics(queue.WithMeterProvider(provider{meter: m}))), m, server
+}
+
+func TestConsumerAckNack(t *testing.T) {
+	broker, m, server := newQueue(t)
+	ctx := context.Background()
+	_, err := broker.Producer("orders").Publish(ctx, queue.NewMessage([]byte("a")), queue.NewMessage([]byte("b")))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), m.get("messaging.queue.produced"))
+
+	consumer := broker.Consumer("orders", "")
+	msgs, err := consumer.Receive(ctx, 2, time.Second)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, int64(2), m.get("messaging.queue.consumed"))
+	ackKey := consumer.(*reliableConsumer).queue.AckKey
+	ackList, _ := server.List(ackKey)
+	assert.Len(t, ackList, 2)
+
+	// 确认只计数一次
+	assert.Nil(t, consumer.Ack(ctx, msgs[0]))
+	assert.Equal(t, int64(1), m.get("messaging.queue.acked"))
+
+	// 拒绝后重新入队，只计入重试数
+	assert.Nil(t, consumer.Nack(ctx, msgs[1]))
+	assert.Equal(t, int64(1), m.get("messaging.queue.retried"))
+	assert.Equal(t, int64(2), m.get("messaging.queue.produced"))
+	assert.Equal(t, int64(1), m.get("messaging.queue.acked"))
+	ackList, _ = server.List(ackKey)
+	assert.Empty(t, ackList)
+
+	msgs, err = consumer.Receive(ctx, 1, time.Second)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "b", string(msgs[0].Body))
+	assert.Equal(t, int64(2), msgs[0].Attempts)
+}
+
+func TestStats(t *testing.T) {
+	broker, _, server := newQueue(t)
+	q := broker.GetReliableQueue("orders")
+	// 所有消费者的确认列表
+	for i := 0; i < 3; i++ {
+		_, err := server.Lpush(fmt.Sprintf("orders:Ack:%d", i), "x")
+		assert.Nil(t, err)
+	}
+	_, err := server.Lpush("orders", "y")
+	assert.Nil(t, err)
+	assert.Equal(t, []queue.Stats{{Topic: "orders", Length: 1, Pending: 3}}, q.Stats())
+}
@@ -2,12 +2,16 @@ package queue_reliable
 
 import (
 	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/tracer"
 	"github.com/donetkit/contrib/utils/cache"
 )
 
 type ReliableQueue struct {
-	client cache.ICache
-	logger glog.ILogger
+	client  cache.ICache
+	logger  glog.ILogger
+	tracer  *tracer.Server
+	metrics *queue.Metrics
 }
 
 func NewReliableQueue(client cache.ICache, logger glog.ILogger) *ReliableQueue {
@@ -17,6 +21,21 @@ func NewReliableQueue(client cache.ICache, logger glog.ILogger) *ReliableQueue {
 	}
 }
 
+// AddTrace 设置链路追踪，生产时注入链路信息
+func (r *ReliableQueue) AddTrace(tracer *tracer.Server) *ReliableQueue {
+	r.tracer = tracer
+	return r
+}
+
+// AddMetrics 设置指标，记录生产、消费、确认及回滚重试数
+func (r *ReliableQueue) AddMetrics(metrics *queue.Metrics) *ReliableQueue {
+	r.metrics = metrics
+	return r
+}
+
 func (r *ReliableQueue) GetReliableQueue(topic string) *RedisReliableQueue {
-	return New(r.client, topic, r.logger)
+	queue := New(r.client, topic, r.logger)
+	queue.Tracer = r.tracer
+	queue.Metrics = r.metrics
+	return queue
 }
@@ -0,0 +1,237 @@
+package queue_rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/shirou/gopsutil/host"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var clientSeq int64
+
+// Client RPC客户端。请求发布到服务主题，应答发布到客户端独有的应答主题
+type Client struct {
+	*config
+	broker   queue.Broker
+	topic    string
+	replyTo  string
+	producer queue.Producer
+	locker   sync.Mutex
+	pending  map[string]chan *queue.Message
+	closed   bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewClient 创建客户端并开始接收应答，topic 为服务端的请求主题
+// broker 一般为 queue_stream.StreamQueue，应答主题按 WithReplyTTL 过期，客户端运行期间定期续期
+func NewClient(broker queue.Broker, topic string, opts ...Option) *Client {
+	info, _ := host.Info()
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		config:   newConfig(opts),
+		broker:   broker,
+		topic:    topic,
+		replyTo:  fmt.Sprintf("%s:Reply:%s@%d#%d", topic, info.Hostname, os.Getpid(), atomic.AddInt64(&clientSeq, 1)),
+		producer: broker.Producer(topic),
+		pending:  make(map[string]chan *queue.Message),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	consumer := broker.Consumer(c.replyTo, c.group)
+	go c.receive(ctx, consumer)
+	return c
+}
+
+// ReplyTo 应答主题
+func (c *Client) ReplyTo() string {
+	return c.replyTo
+}
+
+// receive 应答大循环，按关联编号交给等待中的调用
+func (c *Client) receive(ctx context.Context, consumer queue.Consumer) {
+	defer close(c.done)
+	var expired time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return // 退出了...
+		default:
+		}
+		msgs, err := consumer.Receive(ctx, 10, time.Second)
+		if time.Since(expired) >= c.replyTTL/3 {
+			// 第一次接收后应答主题才会创建
+			expired = time.Now()
+			if err := expireTopic(c.broker, c.replyTo, c.replyTTL); err != nil && c.logger != nil {
+				c.logger.Error(fmt.Sprintf("续期应答主题失败：%s %s", c.replyTo, err.Error()))
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if c.logger != nil {
+				c.logger.Error(fmt.Sprintf("接收应答失败：%s %s", c.replyTo, err.Error()))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, msg := range msgs {
+			consumer.Ack(ctx, msg)
+			id := msg.GetHeader(HeaderCorrelationId)
+			c.locker.Lock()
+			ch, ok := c.pending[id]
+			delete(c.pending, id)
+			c.locker.Unlock()
+			if !ok {
+				// 调用已超时
+				if c.logger != nil {
+					c.logger.Debug(fmt.Sprintf("丢弃过期应答：%s %s", c.replyTo, id))
+				}
+				continue
+			}
+			ch <- msg
+		}
+	}
+}
+
+// Call 调用服务端方法，args 为请求内容，reply 不为空时将应答按 JSON 解码到 reply
+// ctx 没有截止时间时使用默认超时时间，超时返回 ErrTimeout，服务端返回错误时为 *RemoteError
+func (c *Client) Call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	msg, err := c.Invoke(ctx, method, args)
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return nil
+	}
+	return msg.Unmarshal(reply)
+}
+
+// Invoke 调用服务端方法，返回应答消息
+func (c *Client) Invoke(ctx context.Context, method string, args interface{}) (*queue.Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	ctx, span := c.startSpan(ctx, method)
+	defer span.End()
+
+	msg, err := c.request(ctx, method, args)
+	if err != nil {
+		return nil, c.fail(span, err)
+	}
+	ch := make(chan *queue.Message, 1)
+	c.locker.Lock()
+	if c.closed {
+		c.locker.Unlock()
+		return nil, c.fail(span, ErrClosed)
+	}
+	c.pending[msg.Id] = ch
+	c.locker.Unlock()
+
+	if _, err = c.producer.Publish(ctx, msg); err != nil {
+		c.remove(msg.Id)
+		return nil, c.fail(span, err)
+	}
+
+	select {
+	case rs, ok := <-ch:
+		if !ok {
+			return nil, c.fail(span, ErrClosed)
+		}
+		if message := rs.GetHeader(HeaderError); len(message) > 0 {
+			return nil, c.fail(span, &RemoteError{Method: method, Message: message})
+		}
+		return rs, nil
+	case <-ctx.Done():
+		c.remove(msg.Id)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, c.fail(span, ErrTimeout)
+		}
+		return nil, c.fail(span, ctx.Err())
+	}
+}
+
+func (c *Client) request(ctx context.Context, method string, args interface{}) (*queue.Message, error) {
+	msg, err := queue.NewEnvelope(args)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = queue.Encode(msg); err != nil {
+		return nil, err
+	}
+	msg.SetHeader(HeaderMethod, method)
+	msg.SetHeader(HeaderCorrelationId, msg.Id)
+	msg.SetHeader(HeaderReplyTo, c.replyTo)
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.SetHeader(HeaderDeadline, strconv.FormatInt(deadline.UnixMilli(), 10))
+	}
+	queue.Inject(ctx, c.tracer, msg)
+	return msg, nil
+}
+
+func (c *Client) remove(id string) {
+	c.locker.Lock()
+	delete(c.pending, id)
+	c.locker.Unlock()
+}
+
+func (c *Client) startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	if c.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return c.tracer.Tracer.Start(ctx, c.topic+"/"+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String(system),
+			semconv.RPCServiceKey.String(c.topic),
+			semconv.RPCMethodKey.String(method),
+		),
+	)
+}
+
+func (c *Client) fail(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
+
+// Close 停止接收应答，等待中的调用返回 ErrClosed；队列实现支持时删除应答主题
+func (c *Client) Close() error {
+	c.locker.Lock()
+	if c.closed {
+		c.locker.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.locker.Unlock()
+
+	c.cancel()
+	<-c.done
+	c.locker.Lock()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.locker.Unlock()
+	if deleter, ok := c.broker.(topicDeleter); ok {
+		return deleter.DeleteTopic(c.replyTo)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package queue_rpc
+
+import (
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/tracer"
+)
+
+type config struct {
+	logger      glog.ILoggerEntry
+	tracer      *tracer.Server
+	timeout     time.Duration
+	group       string
+	concurrency int
+	replyTTL    time.Duration
+}
+
+// Option for rpc client and server
+type Option func(*config)
+
+// WithLogger set logger function
+func WithLogger(logger glog.ILogger) Option {
+	return func(c *config) {
+		c.logger = logger.WithField("Queue-RPC", "Queue-RPC")
+	}
+}
+
+// WithTracer 客户端与服务端链路通过消息头传递，服务端链路为客户端链路的子链路
+func WithTracer(tracer *tracer.Server) Option {
+	return func(c *config) {
+		c.tracer = tracer
+	}
+}
+
+// WithTimeout 客户端调用的默认超时时间，ctx 没有截止时间时使用。默认30秒
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// WithGroup 服务端消费组，同一服务的多个实例使用相同的消费组分摊请求。默认 rpc
+func WithGroup(group string) Option {
+	return func(c *config) {
+		if len(group) > 0 {
+			c.group = group
+		}
+	}
+}
+
+// WithConcurrency 服务端并发处理请求的个数。默认1
+func WithConcurrency(concurrency int) Option {
+	return func(c *config) {
+		if concurrency > 0 {
+			c.concurrency = concurrency
+		}
+	}
+}
+
+// WithReplyTTL 应答主题的过期时间，客户端定期续期，服务端发送应答后续期，客户端与服务端应保持一致。默认1分钟
+func WithReplyTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		if ttl > 0 {
+			c.replyTTL = ttl
+		}
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		timeout:     30 * time.Second,
+		group:       "rpc",
+		concurrency: 1,
+		replyTTL:    time.Minute,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
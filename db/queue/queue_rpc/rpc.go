@@ -0,0 +1,54 @@
+package queue_rpc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/donetkit/contrib/db/queue"
+)
+
+// 请求及应答的消息头
+const (
+	HeaderMethod        = "rpc-method"         // 方法名
+	HeaderCorrelationId = "rpc-correlation-id" // 关联编号，应答与请求相同
+	HeaderReplyTo       = "rpc-reply-to"       // 应答主题
+	HeaderDeadline      = "rpc-deadline"       // 请求截止时间，毫秒时间戳，超过后服务端不再处理
+	HeaderError         = "rpc-error"          // 服务端处理错误
+)
+
+const system = "queue_rpc"
+
+var (
+	ErrTimeout        = errors.New("queue rpc: call timeout")
+	ErrClosed         = errors.New("queue rpc: client closed")
+	ErrMethodNotFound = errors.New("queue rpc: method not found")
+)
+
+// RemoteError 服务端处理函数返回的错误
+type RemoteError struct {
+	Method  string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("queue rpc: %s: %s", e.Method, e.Message)
+}
+
+// topicDeleter 支持删除主题的队列实现，客户端关闭时删除自己的应答主题
+type topicDeleter interface {
+	DeleteTopic(topic string) error
+}
+
+// topicExpirer 支持主题过期的队列实现，客户端异常退出后应答主题自动清理
+type topicExpirer interface {
+	ExpireTopic(topic string, ttl time.Duration) error
+}
+
+// expireTopic 续期临时主题，队列实现不支持过期时不做任何事
+func expireTopic(broker queue.Broker, topic string, ttl time.Duration) error {
+	if expirer, ok := broker.(topicExpirer); ok {
+		return expirer.ExpireTopic(topic, ttl)
+	}
+	return nil
+}
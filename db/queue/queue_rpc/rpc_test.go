@@ -0,0 +1,141 @@
+package queue_rpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/db/queue/queue_memory"
+	"github.com/donetkit/contrib/db/queue/queue_stream"
+	"github.com/donetkit/contrib/db/redis"
+	"github.com/donetkit/contrib/tracer"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type addArgs struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func startServer(t *testing.T, broker queue.Broker, opts ...Option) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	server := NewServer(broker, "calc", append([]Option{WithConcurrency(2)}, opts...)...)
+	// 先注册消费组，避免 Serve 启动前的请求进入默认组
+	broker.Consumer("calc", "rpc")
+	server.Handle("add", func(ctx context.Context, req *queue.Message) (interface{}, error) {
+		var args addArgs
+		if err := req.Unmarshal(&args); err != nil {
+			return nil, err
+		}
+		return args.A + args.B, nil
+	}).Handle("fail", func(ctx context.Context, req *queue.Message) (interface{}, error) {
+		return nil, errors.New("boom")
+	}).Handle("panic", func(ctx context.Context, req *queue.Message) (interface{}, error) {
+		panic("oops")
+	})
+	go server.Serve(ctx)
+}
+
+func TestCall(t *testing.T) {
+	broker := queue_memory.NewMemoryQueue()
+	startServer(t, broker)
+	client := NewClient(broker, "calc", WithTimeout(5*time.Second))
+	defer client.Close()
+
+	var sum int
+	assert.Nil(t, client.Call(context.Background(), "add", addArgs{A: 1, B: 2}, &sum))
+	assert.Equal(t, 3, sum)
+}
+
+func TestRemoteError(t *testing.T) {
+	broker := queue_memory.NewMemoryQueue()
+	startServer(t, broker)
+	client := NewClient(broker, "calc", WithTimeout(5*time.Second))
+	defer client.Close()
+
+	var remote *RemoteError
+	err := client.Call(context.Background(), "fail", nil, nil)
+	assert.True(t, errors.As(err, &remote))
+	assert.Equal(t, "fail", remote.Method)
+	assert.Equal(t, "boom", remote.Message)
+
+	err = client.Call(context.Background(), "panic", nil, nil)
+	assert.True(t, errors.As(err, &remote))
+	assert.Equal(t, "panic: oops", remote.Message)
+
+	err = client.Call(context.Background(), "missing", nil, nil)
+	assert.True(t, errors.As(err, &remote))
+	assert.Equal(t, ErrMethodNotFound.Error(), remote.Message)
+}
+
+func TestTimeoutAndClose(t *testing.T) {
+	broker := queue_memory.NewMemoryQueue()
+	client := NewClient(broker, "calc", WithTimeout(100*time.Millisecond))
+
+	err := client.Call(context.Background(), "add", addArgs{A: 1, B: 2}, nil)
+	assert.Equal(t, ErrTimeout, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.Call(ctx, "add", addArgs{A: 1, B: 2}, nil)
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Nil(t, client.Close())
+	err = client.Call(context.Background(), "add", addArgs{A: 1, B: 2}, nil)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestStreamTracing(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	port, _ := strconv.Atoi(redisServer.Port())
+	client := redis.New(redis.WithAddr(redisServer.Host()), redis.WithPort(port), redis.WithLogger(glog.New()))
+	recorder := tracetest.NewSpanRecorder()
+	tracerServer := tracer.New(
+		tracer.WithProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		tracer.WithPropagators(propagation.TraceContext{}),
+	)
+	broker := queue_stream.NewStreamQueue(client, glog.New())
+	startServer(t, broker, WithTracer(tracerServer))
+	rpcClient := NewClient(broker, "calc", WithTimeout(5*time.Second), WithTracer(tracerServer), WithReplyTTL(time.Minute))
+
+	var sum int
+	assert.Nil(t, rpcClient.Call(context.Background(), "add", addArgs{A: 1, B: 2}, &sum))
+	assert.Equal(t, 3, sum)
+
+	// 服务端链路为客户端链路的子链路
+	var clientSpan, serverSpan sdktrace.ReadOnlySpan
+	assert.Eventually(t, func() bool {
+		for _, span := range recorder.Ended() {
+			switch span.SpanKind() {
+			case trace.SpanKindClient:
+				clientSpan = span
+			case trace.SpanKindConsumer:
+				if span.Name() == "calc process" {
+					serverSpan = span
+				}
+			}
+		}
+		return clientSpan != nil && serverSpan != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "calc/add", clientSpan.Name())
+	assert.Equal(t, clientSpan.SpanContext().TraceID(), serverSpan.SpanContext().TraceID())
+	assert.Equal(t, clientSpan.SpanContext().SpanID(), serverSpan.Parent().SpanID())
+
+	// 应答主题会过期，客户端关闭时删除
+	assert.True(t, redisServer.TTL(rpcClient.ReplyTo()) > 0)
+	assert.Nil(t, rpcClient.Close())
+	assert.False(t, redisServer.Exists(rpcClient.ReplyTo()))
+
+	redisServer.Close()
+	assert.NotNil(t, broker.DeleteTopic("calc"))
+}
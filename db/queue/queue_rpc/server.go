@@ -0,0 +1,144 @@
+package queue_rpc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/donetkit/contrib/db/queue"
+)
+
+// HandlerFunc 服务端方法，返回值按 JSON 序列化后作为应答，返回错误时客户端收到 *RemoteError
+type HandlerFunc func(ctx context.Context, req *queue.Message) (interface{}, error)
+
+// Server RPC服务端。同一主题的多个服务实例使用相同消费组分摊请求
+type Server struct {
+	*config
+	broker    queue.Broker
+	topic     string
+	locker    sync.RWMutex
+	handlers  map[string]HandlerFunc
+	producers map[string]queue.Producer
+}
+
+// NewServer 创建服务端，topic 为请求主题
+func NewServer(broker queue.Broker, topic string, opts ...Option) *Server {
+	return &Server{
+		config:    newConfig(opts),
+		broker:    broker,
+		topic:     topic,
+		handlers:  make(map[string]HandlerFunc),
+		producers: make(map[string]queue.Producer),
+	}
+}
+
+// Handle 注册方法
+func (s *Server) Handle(method string, fn HandlerFunc) *Server {
+	s.locker.Lock()
+	s.handlers[method] = fn
+	s.locker.Unlock()
+	return s
+}
+
+// Serve 处理请求，阻塞直到 ctx 结束，返回 ctx.Err()
+func (s *Server) Serve(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < s.concurrency; i++ {
+		consumer := s.broker.Consumer(s.topic, s.group)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queue.Consume(ctx, consumer, s.handle, queue.Tracing(s.tracer, system))
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// handle 处理一个请求并发送应答，应答发送失败时返回错误，请求会被重新投递
+func (s *Server) handle(ctx context.Context, req *queue.Message) error {
+	replyTo := req.GetHeader(HeaderReplyTo)
+	if len(replyTo) == 0 {
+		if s.logger != nil {
+			s.logger.Warning(fmt.Sprintf("丢弃没有应答主题的请求：%s %s", s.topic, req.Id))
+		}
+		return nil
+	}
+	if value := req.GetHeader(HeaderDeadline); len(value) > 0 {
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			deadline := time.UnixMilli(ms)
+			if !time.Now().Before(deadline) {
+				// 客户端已超时，不再处理
+				if s.logger != nil {
+					s.logger.Debug(fmt.Sprintf("丢弃过期请求：%s %s", s.topic, req.Id))
+				}
+				return nil
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+	}
+
+	method := req.GetHeader(HeaderMethod)
+	result, err := s.invoke(ctx, method, req)
+
+	var reply *queue.Message
+	if err == nil {
+		reply, err = queue.NewEnvelope(result)
+	}
+	if err != nil {
+		reply = queue.NewMessage(nil)
+		reply.SetHeader(HeaderError, err.Error())
+	}
+	reply.SetHeader(HeaderMethod, method)
+	reply.SetHeader(HeaderCorrelationId, req.GetHeader(HeaderCorrelationId))
+	queue.Inject(ctx, s.tracer, reply)
+
+	if _, err = s.producer(replyTo).Publish(ctx, reply); err != nil {
+		if s.logger != nil {
+			s.logger.Error(fmt.Sprintf("发送应答失败：%s %s %s", replyTo, req.Id, err.Error()))
+		}
+		return err
+	}
+	// 客户端已退出时，应答重新创建的主题也会过期
+	if err = expireTopic(s.broker, replyTo, s.replyTTL); err != nil && s.logger != nil {
+		s.logger.Error(fmt.Sprintf("续期应答主题失败：%s %s", replyTo, err.Error()))
+	}
+	return nil
+}
+
+func (s *Server) invoke(ctx context.Context, method string, req *queue.Message) (result interface{}, err error) {
+	s.locker.RLock()
+	fn, ok := s.handlers[method]
+	s.locker.RUnlock()
+	if !ok {
+		return nil, ErrMethodNotFound
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			if s.logger != nil {
+				s.logger.Error(fmt.Sprintf("处理请求异常：%s %s %v", s.topic, method, r))
+			}
+		}
+	}()
+	return fn(ctx, req)
+}
+
+// producer 应答主题的生产者，客户端较多时定期清空
+func (s *Server) producer(replyTo string) queue.Producer {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	producer, ok := s.producers[replyTo]
+	if !ok {
+		if len(s.producers) >= 1024 {
+			s.producers = make(map[string]queue.Producer)
+		}
+		producer = s.broker.Producer(replyTo)
+		s.producers[replyTo] = producer
+	}
+	return producer
+}
@@ -0,0 +1,415 @@
+package queue_stream
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/go-redis/redis/v8"
+	"github.com/shirou/gopsutil/host"
+)
+
+var partitionSeq int64
+
+// PartitionedStream 分区Stream。消息按Key哈希到N个Stream，消费组内各消费者分摊分区，
+// 每个分区同一时刻只由一个消费者顺序处理，从而保证同一Key的消息有序
+type PartitionedStream struct {
+	ctx            context.Context   // Context
+	DB             int               // redis DB 默认为 0
+	Topic          string            // 消息队列主题
+	Group          string            // 消费者组
+	Partitions     int               // 分区数，创建后不可修改，否则Key与分区的对应关系会变化
+	BlockTime      int64             // 每个分区读取时的阻塞时间。默认5秒
+	BatchSize      int64             // 每个分区每次读取的消息个数。默认10
+	MaxRetry       int64             // 最大重试次数。超过该次数后，消息将被抛弃，默认10次
+	RetryInterval  int64             // 处理失败后原地重试的间隔。默认1000ms
+	Heartbeat      int64             // 消费者心跳及重新分配分区的间隔。默认5秒
+	SessionTimeout int64             // 消费者心跳超时时间，超时后其分区被重新分配。默认15秒
+	Metrics        *queue.Metrics    // 指标
+	consumer       string            // 消费者
+	next           uint64            // 无Key消息的轮询序号
+	streams        []*RedisStream    // 分区
+	client         cache.ICache      // redis client
+	logger         glog.ILoggerEntry // logger
+	locker         sync.Mutex
+	assigned       []int // 当前持有的分区
+}
+
+func NewPartitioned(client cache.ICache, topic string, partitions int, logger glog.ILogger) *PartitionedStream {
+	if partitions <= 0 {
+		partitions = 1
+	}
+	info, _ := host.Info()
+	p := &PartitionedStream{
+		ctx:            context.Background(),
+		Topic:          topic,
+		Partitions:     partitions,
+		BlockTime:      5,
+		BatchSize:      10,
+		MaxRetry:       10,
+		RetryInterval:  1000,
+		Heartbeat:      5,
+		SessionTimeout: 15,
+		consumer:       fmt.Sprintf("%s@%d#%d", info.Hostname, os.Getpid(), atomic.AddInt64(&partitionSeq, 1)),
+		client:         client,
+		logger:         logger.WithField("MQ-Redis-Stream-Partition", "MQ-Redis-Stream-Partition"),
+	}
+	for i := 0; i < partitions; i++ {
+		stream := New(client, partitionKey(topic, i), logger)
+		stream.Topic = topic
+		p.streams = append(p.streams, stream)
+	}
+	return p
+}
+
+func partitionKey(topic string, partition int) string {
+	return fmt.Sprintf("%s:%d", topic, partition)
+}
+
+// Partition Key对应的分区
+func Partition(key string, partitions int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(partitions))
+}
+
+// Assign 按消费者名称排序后轮流分配分区，所有消费者独立计算得到相同结果
+func Assign(members []string, partitions int) map[string][]int {
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+	rs := make(map[string][]int, len(sorted))
+	if len(sorted) == 0 {
+		return rs
+	}
+	for i := 0; i < partitions; i++ {
+		member := sorted[i%len(sorted)]
+		rs[member] = append(rs[member], i)
+	}
+	return rs
+}
+
+// Stream 获取分区
+func (p *PartitionedStream) Stream(partition int) *RedisStream {
+	return p.streams[partition]
+}
+
+// Count 所有分区的消息个数
+func (p *PartitionedStream) Count() int64 {
+	var count int64
+	for _, stream := range p.streams {
+		count += stream.Count()
+	}
+	return count
+}
+
+// Add 生产添加，相同Key的消息进入同一分区，Key为空时轮流写入各分区
+func (p *PartitionedStream) Add(key string, value interface{}) string {
+	return p.AddContext(p.ctx, key, value)
+}
+
+// AddContext 生产添加，ctx 中的链路信息写入消息头
+// value 为 *queue.Message 且 key 为空时使用消息的Key
+func (p *PartitionedStream) AddContext(ctx context.Context, key string, value interface{}) string {
+	id, _ := p.TryAdd(ctx, key, value)
+	return id
+}
+
+// TryAdd 生产添加，分区已满时按分区的 Overflow 策略处理
+func (p *PartitionedStream) TryAdd(ctx context.Context, key string, value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	if msg, ok := value.(*queue.Message); ok {
+		if len(key) == 0 {
+			key = msg.Key
+		}
+		msg.Key = key
+	} else if len(key) > 0 && p.streams[0].Envelope {
+		msg, err := queue.NewEnvelope(value)
+		if err == nil {
+			msg.Key = key
+			value = msg
+		}
+	}
+	var partition int
+	if len(key) > 0 {
+		partition = Partition(key, p.Partitions)
+	} else {
+		partition = int(atomic.AddUint64(&p.next, 1) % uint64(p.Partitions))
+	}
+	return p.streams[partition].TryAdd(ctx, value)
+}
+
+// SetGroup 设置消费组。如果消费组不存在则在各分区创建
+func (p *PartitionedStream) SetGroup(group string) bool {
+	if len(group) == 0 {
+		return false
+	}
+	p.Group = group
+	var rs bool
+	for _, stream := range p.streams {
+		if stream.SetGroup(group) {
+			rs = true
+		}
+	}
+	return rs
+}
+
+// Assigned 当前消费者持有的分区
+func (p *PartitionedStream) Assigned() []int {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return append([]int(nil), p.assigned...)
+}
+
+func (p *PartitionedStream) membersKey() string {
+	return fmt.Sprintf("%s:Members:%s", p.Topic, p.Group)
+}
+
+func (p *PartitionedStream) ownerKey(partition int) string {
+	return fmt.Sprintf("%s:Owner:%s:%d", p.Topic, p.Group, partition)
+}
+
+// members 发送心跳并返回存活的消费者，清理心跳超时的消费者
+func (p *PartitionedStream) members() []string {
+	client := p.client.WithDB(p.DB).WithContext(p.ctx)
+	now := time.Now().UnixMilli()
+	client.HashSet(p.membersKey(), p.consumer, now)
+	var members []string
+	for member, value := range client.HashAll(p.membersKey()) {
+		last, _ := strconv.ParseInt(value, 10, 64)
+		if now-last > p.SessionTimeout*1000 {
+			if p.logger != nil {
+				p.logger.Debug(fmt.Sprintf("%s 删除心跳超时消费者：%s", p.Group, member))
+			}
+			client.HashDel(p.membersKey(), member)
+			continue
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+// renewScript 分区锁仍属于当前消费者时续期
+const renewScript = `if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('pexpire', KEYS[1], ARGV[2]) else return 0 end`
+
+// own 获取或续期分区所有权，分区锁保证重新分配期间旧消费者处理完之前新消费者不会开始
+// 续期时比较和延长过期时间是一个原子操作，锁过期后被其它消费者获取时不会被抢回
+func (p *PartitionedStream) own(partition int) bool {
+	ttl := time.Duration(p.SessionTimeout) * time.Second
+	if p.client.WithDB(p.DB).WithContext(p.ctx).SetNX(p.ownerKey(partition), p.consumer, ttl) {
+		return true
+	}
+	pipe := p.client.WithDB(p.DB).Pipeline()
+	renewed := pipe.Eval(p.ctx, renewScript, []string{p.ownerKey(partition)}, p.consumer, ttl.Milliseconds())
+	if _, err := pipe.Exec(p.ctx); err != nil {
+		if p.logger != nil {
+			p.logger.Warning(fmt.Sprintf("%s 分区 %d 续期失败：%s", p.Group, partition, err.Error()))
+		}
+		return false
+	}
+	n, _ := renewed.Int64()
+	return n == 1
+}
+
+func (p *PartitionedStream) release(partition int) {
+	p.client.WithDB(p.DB).WithContext(p.ctx).ReleaseLock(p.ownerKey(partition), p.consumer)
+}
+
+type partitionWorker struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	stopped bool
+}
+
+// ConsumeBlock 分区消费大循环，消费者加入或离开时自动重新分配分区
+// 同一分区的消息按顺序逐条处理，处理失败时原地重试，不会越过失败的消息
+// OnMessage partition 分区 msg 消费消息信息 returns 返回True确认消费，返回False稍后重试
+func (p *PartitionedStream) ConsumeBlock(ctx context.Context, OnMessage func(partition int, msg redis.XMessage) bool) {
+	go func() {
+		// 自动创建消费组
+		p.SetGroup(p.Group)
+		workers := make(map[int]*partitionWorker)
+		defer func() {
+			for _, worker := range workers {
+				worker.cancel()
+				<-worker.done
+			}
+			p.client.WithDB(p.DB).WithContext(p.ctx).HashDel(p.membersKey(), p.consumer)
+			p.setAssigned(nil)
+		}()
+		for {
+			p.rebalance(ctx, workers, OnMessage)
+			select {
+			case <-ctx.Done():
+				return // 退出了...
+			case <-time.After(time.Duration(p.Heartbeat) * time.Second):
+			}
+		}
+	}()
+}
+
+func (p *PartitionedStream) rebalance(ctx context.Context, workers map[int]*partitionWorker, OnMessage func(partition int, msg redis.XMessage) bool) {
+	want := make(map[int]bool)
+	for _, partition := range Assign(p.members(), p.Partitions)[p.consumer] {
+		want[partition] = true
+	}
+	for partition, worker := range workers {
+		select {
+		case <-worker.done:
+			delete(workers, partition)
+			continue
+		default:
+		}
+		// 不再分配给自己或所有权已丢失，处理完当前消息后退出
+		if !worker.stopped && (!want[partition] || !p.own(partition)) {
+			worker.stopped = true
+			worker.cancel()
+		}
+	}
+	var assigned []int
+	for partition := 0; partition < p.Partitions; partition++ {
+		if !want[partition] {
+			continue
+		}
+		if worker, ok := workers[partition]; ok {
+			if !worker.stopped {
+				assigned = append(assigned, partition)
+			}
+			continue
+		}
+		if !p.own(partition) {
+			continue // 旧消费者仍在处理，下次心跳再试
+		}
+		if p.logger != nil {
+			p.logger.Debug(fmt.Sprintf("%s 分配分区：%s %d", p.Group, p.consumer, partition))
+		}
+		workerCtx, cancel := context.WithCancel(ctx)
+		worker := &partitionWorker{cancel: cancel, done: make(chan struct{})}
+		workers[partition] = worker
+		assigned = append(assigned, partition)
+		go func(partition int) {
+			defer close(worker.done)
+			defer p.release(partition)
+			p.work(workerCtx, partition, OnMessage)
+		}(partition)
+	}
+	p.setAssigned(assigned)
+}
+
+func (p *PartitionedStream) setAssigned(assigned []int) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.assigned = assigned
+}
+
+// work 顺序消费一个分区。分区内以分区名作为消费者名称，所有权转移后新消费者先处理旧消费者未确认的消息
+func (p *PartitionedStream) work(ctx context.Context, partition int, OnMessage func(partition int, msg redis.XMessage) bool) {
+	stream := p.streams[partition]
+	stream.Group = p.Group
+	consumer := partitionKey("partition", partition)
+	history := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		var msgs []redis.XMessage
+		if history {
+			msgs = stream.ReadGroupBlock(p.Group, consumer, p.BatchSize, 0, "0")
+			if len(msgs) == 0 {
+				history = false
+				continue
+			}
+		} else {
+			msgs = stream.ReadGroupBlock(p.Group, consumer, p.BatchSize, p.BlockTime*1000, ">")
+			if len(msgs) == 0 {
+				// 超时和出错都返回空，出错时歇一会并重建消费组，避免空转
+				if err := p.checkGroup(partition); err != nil {
+					if p.logger != nil {
+						p.logger.Error(fmt.Sprintf("%s 读取分区失败：%s %s", p.Group, stream.key, err.Error()))
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(time.Duration(p.RetryInterval) * time.Millisecond):
+					}
+					stream.GroupCreate(p.Group)
+					history = true
+				}
+				continue
+			}
+		}
+		for _, msg := range msgs {
+			if !p.process(ctx, partition, msg, OnMessage) {
+				return
+			}
+		}
+	}
+}
+
+// checkGroup 检查分区的消费组，Stream 被删除或消费组被销毁时返回 NOGROUP 错误
+func (p *PartitionedStream) checkGroup(partition int) error {
+	pipe := p.client.WithDB(p.DB).Pipeline()
+	pipe.XPending(p.ctx, p.streams[partition].key, p.Group)
+	_, err := pipe.Exec(p.ctx)
+	return err
+}
+
+// process 处理一条消息直到成功或超过最大重试次数，返回 false 表示已退出
+func (p *PartitionedStream) process(ctx context.Context, partition int, msg redis.XMessage, OnMessage func(partition int, msg redis.XMessage) bool) bool {
+	stream := p.streams[partition]
+	var retry int64
+	for {
+		p.Metrics.Consumed(ctx, "redis_stream", p.Topic, p.Group, 1)
+		start := time.Now()
+		result := stream.handle(ctx, []redis.XMessage{msg}, func(msgs []redis.XMessage) bool {
+			return OnMessage(partition, msgs[0])
+		})
+		p.Metrics.Handled(ctx, "redis_stream", p.Topic, p.Group, time.Since(start))
+		if result {
+			stream.Ack(p.Group, msg.ID)
+			p.Metrics.Acked(ctx, "redis_stream", p.Topic, p.Group, 1)
+			return true
+		}
+		retry++
+		if retry > p.MaxRetry {
+			if p.logger != nil {
+				p.logger.Debug(fmt.Sprintf("%s 删除多次失败消息：%s %s", p.Group, stream.key, msg.ID))
+			}
+			stream.Ack(p.Group, msg.ID)
+			p.Metrics.DeadLettered(ctx, "redis_stream", p.Topic, p.Group, 1)
+			return true
+		}
+		p.Metrics.Retried(ctx, "redis_stream", p.Topic, p.Group, 1)
+		select {
+		case <-ctx.Done():
+			return false // 消息保留在待处理列表，由新的所有者继续处理
+		case <-time.After(time.Duration(p.RetryInterval) * time.Millisecond):
+		}
+	}
+}
+
+// Stats 各分区的队列状态，Topic 为分区key
+func (p *PartitionedStream) Stats() []queue.Stats {
+	var stats []queue.Stats
+	for _, stream := range p.streams {
+		stats = append(stats, stream.Stats()...)
+	}
+	return stats
+}
+
+// MetricsAsync 后台定时采集各分区的队列状态指标，interval 为采集间隔
+func (p *PartitionedStream) MetricsAsync(ctx context.Context, interval time.Duration) {
+	p.Metrics.Poll(ctx, "redis_stream", p.Topic, interval, p.Stats)
+}
@@ -0,0 +1,229 @@
+package queue_stream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/db/redis"
+	"github.com/donetkit/contrib/utils/cache"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionIsStable(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("order-%d", i)
+		partition := Partition(key, 8)
+		assert.True(t, partition >= 0 && partition < 8)
+		assert.Equal(t, partition, Partition(key, 8))
+	}
+}
+
+func TestAssignCoversAllPartitions(t *testing.T) {
+	rs := Assign([]string{"c", "a", "b"}, 8)
+	assert.Equal(t, []int{0, 3, 6}, rs["a"])
+	assert.Equal(t, []int{1, 4, 7}, rs["b"])
+	assert.Equal(t, []int{2, 5}, rs["c"])
+
+	// 顺序不同得到相同结果
+	assert.Equal(t, rs, Assign([]string{"b", "c", "a"}, 8))
+
+	// 消费者离开后分区重新分配
+	rs = Assign([]string{"a", "c"}, 4)
+	assert.Equal(t, []int{0, 2}, rs["a"])
+	assert.Equal(t, []int{1, 3}, rs["c"])
+
+	assert.Empty(t, Assign(nil, 4))
+}
+
+var (
+	clientOnce sync.Once
+	testClient cache.ICache
+	topicSeq   int64
+)
+
+// newClient 包内测试共用一个 miniredis，redis.New 会替换全局连接，各测试使用不同的主题
+func newClient(t *testing.T) cache.ICache {
+	clientOnce.Do(func() {
+		server, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		port, _ := strconv.Atoi(server.Port())
+		testClient = redis.New(redis.WithAddr(server.Host()), redis.WithPort(port), redis.WithLogger(glog.New()))
+	})
+	return testClient
+}
+
+// newTopic 每次运行使用新的主题，避免上次运行未退出的消费者
+func newTopic(name string) string {
+	return fmt.Sprintf("%s-%d", name, atomic.AddInt64(&topicSeq, 1))
+}
+
+func TestPartitionOwner(t *testing.T) {
+	client, topic := newClient(t), newTopic("owner")
+	a := NewPartitioned(client, topic, 4, glog.New())
+	b := NewPartitioned(client, topic, 4, glog.New())
+	a.Group, b.Group = "group", "group"
+
+	assert.Equal(t, []string{a.consumer}, a.members())
+	assert.ElementsMatch(t, []string{a.consumer, b.consumer}, b.members())
+
+	// 心跳超时的消费者被清理
+	client.HashSet(a.membersKey(), "dead", time.Now().Add(-time.Minute).UnixMilli())
+	assert.ElementsMatch(t, []string{a.consumer, b.consumer}, a.members())
+	assert.Empty(t, client.HashGet(a.membersKey(), "dead"))
+
+	// 分区同一时刻只有一个所有者，释放后才能被其它消费者获取
+	assert.True(t, a.own(0))
+	assert.True(t, a.own(0))
+	assert.False(t, b.own(0))
+	b.release(0)
+	assert.False(t, b.own(0))
+	a.release(0)
+	assert.True(t, b.own(0))
+	assert.False(t, a.own(0))
+
+	// 续期延长过期时间
+	assert.Nil(t, client.SetEX(b.ownerKey(0), b.consumer, time.Second))
+	assert.True(t, b.own(0))
+	pipe := client.Pipeline()
+	ttl := pipe.PTTL(context.Background(), b.ownerKey(0))
+	_, err := pipe.Exec(context.Background())
+	assert.Nil(t, err)
+	assert.Greater(t, ttl.Val(), time.Second)
+
+	// 锁过期后被其它消费者获取，原所有者续期失败，不会抢回
+	assert.Nil(t, client.SetEX(b.ownerKey(0), a.consumer, time.Minute))
+	assert.False(t, b.own(0))
+	owner, _ := client.GetString(b.ownerKey(0))
+	assert.Equal(t, a.consumer, owner)
+}
+
+func TestPartitionRebalance(t *testing.T) {
+	client, topic := newClient(t), newTopic("rebalance")
+	a := NewPartitioned(client, topic, 4, glog.New())
+	b := NewPartitioned(client, topic, 4, glog.New())
+	for _, p := range []*PartitionedStream{a, b} {
+		p.Group = "group"
+		p.BlockTime = 1
+		p.SetGroup(p.Group)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	OnMessage := func(partition int, msg goredis.XMessage) bool { return true }
+	workersA := make(map[int]*partitionWorker)
+	workersB := make(map[int]*partitionWorker)
+
+	a.rebalance(ctx, workersA, OnMessage)
+	assert.Equal(t, []int{0, 1, 2, 3}, a.Assigned())
+
+	// b 加入后等待 a 交出分区
+	b.rebalance(ctx, workersB, OnMessage)
+	assert.Empty(t, b.Assigned())
+	want := Assign([]string{a.consumer, b.consumer}, 4)
+	a.rebalance(ctx, workersA, OnMessage)
+	assert.Equal(t, want[a.consumer], a.Assigned())
+	assert.Eventually(t, func() bool {
+		b.rebalance(ctx, workersB, OnMessage)
+		return assert.ObjectsAreEqual(want[b.consumer], b.Assigned())
+	}, 5*time.Second, 100*time.Millisecond)
+
+	cancel()
+	for _, worker := range workersA {
+		<-worker.done
+	}
+	for _, worker := range workersB {
+		<-worker.done
+	}
+}
+
+func TestPartitionOrder(t *testing.T) {
+	client, topic := newClient(t), newTopic("order")
+	p := NewPartitioned(client, topic, 2, glog.New())
+	p.Group = "group"
+	p.BlockTime = 1
+	p.RetryInterval = 10
+
+	var locker sync.Mutex
+	received := make(map[string][]string)
+	failed := false
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.SetGroup(p.Group)
+	for i := 1; i <= 5; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			p.Add(key, fmt.Sprintf("%s%d", key, i))
+		}
+	}
+	p.ConsumeBlock(ctx, func(partition int, msg goredis.XMessage) bool {
+		value := string(queue.Decode([]byte(p.streams[partition].MessageValue(msg))).Body)
+		locker.Lock()
+		defer locker.Unlock()
+		// 失败的消息原地重试，后续消息不会越过它
+		if value == "a2" && !failed {
+			failed = true
+			return false
+		}
+		key := value[:1]
+		received[key] = append(received[key], value)
+		return true
+	})
+	assert.Eventually(t, func() bool {
+		locker.Lock()
+		defer locker.Unlock()
+		return len(received["a"])+len(received["b"])+len(received["c"]) == 15
+	}, 5*time.Second, 10*time.Millisecond)
+	locker.Lock()
+	defer locker.Unlock()
+	assert.True(t, failed)
+	for _, key := range []string{"a", "b", "c"} {
+		var want []string
+		for i := 1; i <= 5; i++ {
+			want = append(want, fmt.Sprintf("%s%d", key, i))
+		}
+		assert.Equal(t, want, received[key])
+	}
+}
+
+func TestPartitionGroupRecreated(t *testing.T) {
+	client, topic := newClient(t), newTopic("recreated")
+	p := NewPartitioned(client, topic, 1, glog.New())
+	p.Group = "group"
+	p.BlockTime = 1
+	p.RetryInterval = 10
+	p.SetGroup(p.Group)
+	assert.Nil(t, p.checkGroup(0))
+
+	received := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.ConsumeBlock(ctx, func(partition int, msg goredis.XMessage) bool {
+		received <- string(queue.Decode([]byte(p.streams[partition].MessageValue(msg))).Body)
+		return true
+	})
+	p.Add("a", "a1")
+	assert.Equal(t, "a1", <-received)
+
+	// 消费组被销毁后从头重建，至少投递一次
+	p.Stream(0).GroupDestroy(p.Group)
+	assert.NotNil(t, p.checkGroup(0))
+	p.Add("a", "a2")
+	for _, want := range []string{"a1", "a2"} {
+		select {
+		case value := <-received:
+			assert.Equal(t, want, value)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message after the group is recreated")
+		}
+	}
+	assert.Nil(t, p.checkGroup(0))
+}
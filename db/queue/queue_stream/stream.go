@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/tracer"
 	"github.com/donetkit/contrib/utils/cache"
 	"github.com/go-redis/redis/v8"
 	"github.com/shirou/gopsutil/host"
+	"go.opentelemetry.io/otel/trace"
 	"os"
 	"strconv"
 	"strings"
@@ -15,25 +18,31 @@ import (
 )
 
 type RedisStream struct {
-	ctx                         context.Context   // Context
-	DB                          int               // redis DB 默认为 0
-	key                         string            // 消息队列key
-	Topic                       string            // 消息队列主题
-	ThrowOnFailure              bool              // 失败时抛出异常。默认false
-	RetryTimesWhenSendFailed    int               // 发送消息失败时的重试次数。默认3次
-	RetryIntervalWhenSendFailed int               // 重试间隔。默认1000ms
-	count                       int64             // 数量
-	RetryInterval               int64             // 重新处理确认队列中死信的间隔。默认60s
-	MaxLength                   int64             // 最大队列长度。要保留的消息个数，超过则移除较老消息，非精确，实际上略大于该值，默认100万
-	MaxRetry                    int64             // 最大重试次数。超过该次数后，消息将被抛弃，默认10次
-	BlockTime                   int64             // 异步消费时的阻塞时间。默认15秒
-	StartId                     string            // 开始编号。独立消费时使用，消费组消费时不使用，默认0-0
-	Group                       string            // 消费者组。指定消费组后，不再使用独立消费。通过SetGroup可自动创建消费组
-	consumer                    string            // 消费者
-	client                      cache.ICache      // redis client
-	FromLastOffset              bool              // 首次消费时的消费策略  默认值false，表示从头部开始消费，等同于RocketMQ/Java版的CONSUME_FROM_FIRST_OFFSET  一个新的订阅组第一次启动从队列的最前位置开始消费，后续再启动接着上次消费的进度开始消费。
-	setGroupId                  int64             // 设置消费组Id
-	logger                      glog.ILoggerEntry // logger
+	ctx                         context.Context      // Context
+	DB                          int                  // redis DB 默认为 0
+	key                         string               // 消息队列key
+	Topic                       string               // 消息队列主题
+	ThrowOnFailure              bool                 // 失败时抛出异常。默认false
+	RetryTimesWhenSendFailed    int                  // 发送消息失败时的重试次数。默认3次
+	RetryIntervalWhenSendFailed int                  // 重试间隔。默认1000ms
+	count                       int64                // 数量
+	RetryInterval               int64                // 重新处理确认队列中死信的间隔。默认60s
+	MaxLength                   int64                // 最大队列长度。要保留的消息个数，超过则移除较老消息，非精确，实际上略大于该值，默认100万
+	Overflow                    queue.OverflowPolicy // 超过最大队列长度时的处理策略。默认丢弃最旧消息
+	OverflowTimeout             int64                // 阻塞策略等待消费者腾出空间的超时时间。默认5000ms
+	MaxRetry                    int64                // 最大重试次数。超过该次数后，消息将被抛弃，默认10次
+	MaxLag                      int64                // Stats 统计消费组积压数的上限。默认10000
+	BlockTime                   int64                // 异步消费时的阻塞时间。默认15秒
+	StartId                     string               // 开始编号。独立消费时使用，消费组消费时不使用，默认0-0
+	Group                       string               // 消费者组。指定消费组后，不再使用独立消费。通过SetGroup可自动创建消费组
+	consumer                    string               // 消费者
+	client                      cache.ICache         // redis client
+	FromLastOffset              bool                 // 首次消费时的消费策略  默认值false，表示从头部开始消费，等同于RocketMQ/Java版的CONSUME_FROM_FIRST_OFFSET  一个新的订阅组第一次启动从队列的最前位置开始消费，后续再启动接着上次消费的进度开始消费。
+	setGroupId                  int64                // 设置消费组Id
+	Envelope                    bool                 // 生产时使用标准信封包装消息，携带消息头和链路信息。默认true，旧的消费者无法解析信封时设为false
+	Tracer                      *tracer.Server       // 链路追踪
+	Metrics                     *queue.Metrics       // 指标
+	logger                      glog.ILoggerEntry    // logger
 }
 
 func New(client cache.ICache, key string, logger glog.ILogger) *RedisStream {
@@ -47,9 +56,12 @@ func New(client cache.ICache, key string, logger glog.ILogger) *RedisStream {
 		Topic:                       key,
 		RetryInterval:               60,
 		MaxLength:                   1_000_000,
+		OverflowTimeout:             5000,
 		MaxRetry:                    10,
+		MaxLag:                      10000,
 		BlockTime:                   15,
 		StartId:                     "0-0",
+		Envelope:                    true,
 		client:                      client,
 		ctx:                         context.Background(),
 	}
@@ -242,35 +254,101 @@ func (r *RedisStream) Acknowledge(keys ...string) int64 {
 	return rs
 }
 
-// Add 生产添加
+// Add 生产添加，MaxLength 已满被 Overflow 策略拒绝或等待超时时丢弃消息，记录警告日志并返回空，需要感知拒绝时使用 TryAdd
 func (r *RedisStream) Add(value interface{}, msgId ...string) string {
+	return r.AddContext(r.ctx, value, msgId...)
+}
+
+// AddContext 生产添加，ctx 中的链路信息写入消息头，被拒绝时与 Add 相同丢弃消息
+func (r *RedisStream) AddContext(ctx context.Context, value interface{}, msgId ...string) string {
+	id, err := r.TryAdd(ctx, value, msgId...)
+	if err != nil && r.logger != nil {
+		r.logger.Warning(fmt.Sprintf("发布到队列[%s]失败：%s", r.key, err.Error()))
+	}
+	return id
+}
+
+// TryAdd 生产添加，队列超过 MaxLength 时按 Overflow 策略处理，拒绝或等待超时返回 *queue.OverflowError
+func (r *RedisStream) TryAdd(ctx context.Context, value interface{}, msgId ...string) (string, error) {
 	if value == nil {
-		return "" //, errors.New("argument null exception error: value is null")
+		return "", nil //, errors.New("argument null exception error: value is null")
+	}
+	if err := queue.WaitCapacity(ctx, r.Topic, r.Overflow, r.MaxLength, 1, r.overflowTimeout(), r.Count); err != nil {
+		return "", err
 	}
 
-	// 自动修剪超长部分，每1000次生产，修剪一次
+	var id = ""
+	if len(msgId) > 0 {
+		id = msgId[0]
+	}
+	value, span := r.envelope(ctx, value)
+	defer span.End()
+	id = r.AddInternal(value, id, r.trim(1), true)
+	if len(id) == 0 {
+		return "", queue.ErrPublishFailed
+	}
+	r.Metrics.Produced(ctx, "redis_stream", r.Topic, 1)
+	return id, nil
+}
+
+// trim 是否自动修剪超长部分，每1000次生产，修剪一次。只有 OverflowDropOldest 策略修剪
+func (r *RedisStream) trim(n int64) bool {
+	if r.MaxLength <= 0 || r.Overflow != queue.OverflowDropOldest {
+		return false
+	}
 	if r.count <= 0 {
 		r.count = r.Count()
 	}
-	atomic.AddInt64(&r.count, 1)
+	var count = atomic.AddInt64(&r.count, n)
+	if r.MaxLength < 1000 && count%r.MaxLength*2 < n {
+		r.count = r.Count() + n
+		return true
+	}
+	if r.MaxLength >= 1000 && count%1000 < n {
+		r.count = r.Count() + n
+		return true
+	}
+	return false
+}
+
+func (r *RedisStream) overflowTimeout() time.Duration {
+	return time.Duration(r.OverflowTimeout) * time.Millisecond
+}
 
-	var trim = false
-	if r.MaxLength > 0 {
-		if r.MaxLength < 1000 && r.count%r.MaxLength*2 == 0 {
-			r.count = r.Count() + 1
-			trim = true
+// envelope 使用标准信封包装消息并开始生产者链路，*queue.Message 总是按信封编码
+func (r *RedisStream) envelope(ctx context.Context, value interface{}) (interface{}, trace.Span) {
+	msg, ok := value.(*queue.Message)
+	if !ok {
+		if !r.Envelope {
+			return value, trace.SpanFromContext(context.Background())
 		}
-		if r.MaxLength > 1000 && r.count%1000 == 0 {
-			r.count = r.Count() + 1
-			trim = true
+		var err error
+		if msg, err = queue.NewEnvelope(value); err != nil {
+			return value, trace.SpanFromContext(context.Background())
 		}
 	}
+	msg.Topic = r.Topic
+	_, span := queue.StartProducerSpan(ctx, r.Tracer, "redis_stream", msg)
+	data, err := queue.Encode(msg)
+	if err != nil {
+		return value, span
+	}
+	return string(data), span
+}
 
-	var id = ""
-	if len(msgId) > 0 {
-		id = msgId[0]
+// MessageValue 消息内容，生产时以队列key为字段名
+func (r *RedisStream) MessageValue(msg redis.XMessage) string {
+	if val, ok := msg.Values[r.key]; ok {
+		if str, ok := val.(string); ok {
+			return str
+		}
+	}
+	for _, val := range msg.Values {
+		if str, ok := val.(string); ok {
+			return str
+		}
 	}
-	return r.AddInternal(value, id, trim, true)
+	return ""
 }
 
 func (r *RedisStream) AddInternal(value interface{}, msgId string, trim bool, retryOnFailed bool) string {
@@ -280,56 +358,47 @@ func (r *RedisStream) AddInternal(value interface{}, msgId string, trim bool, re
 			return id
 		}
 		if i < r.RetryTimesWhenSendFailed {
-			time.Sleep(time.Second * time.Duration(r.RetryIntervalWhenSendFailed))
+			time.Sleep(time.Millisecond * time.Duration(r.RetryIntervalWhenSendFailed))
 		}
 	}
 
 	return ""
 }
 
-// Adds 批量生产添加
+// Adds 批量生产添加，整批被 Overflow 策略拒绝时丢弃，记录警告日志并返回0，需要感知拒绝时使用 TryAdds
 func (r *RedisStream) Adds(values []interface{}) int {
-	if len(values) == 0 {
-		return 0
-	}
-	// 量少时直接插入，而不用管道
-	if len(values) <= 2 {
-		for _, value := range values {
-			r.Add(value)
-		}
-		return len(values)
+	count, err := r.TryAdds(r.ctx, values)
+	if err != nil && r.logger != nil {
+		r.logger.Warning(fmt.Sprintf("批量发布到队列[%s]失败：%s", r.key, err.Error()))
 	}
+	return count
+}
 
-	// 自动修剪超长部分，每1000次生产，修剪一次
-	if r.count <= 0 {
-		r.count = r.Count()
+// TryAdds 批量生产添加，队列容纳不下整批消息时按 Overflow 策略处理，返回写入的个数
+func (r *RedisStream) TryAdds(ctx context.Context, values []interface{}) (int, error) {
+	if len(values) == 0 {
+		return 0, nil
 	}
-
-	var trim = false
-	if r.MaxLength > 0 && r.count > 0 && r.count%1000 == 0 {
-		r.count = r.Count() + 1
-		trim = true
+	if err := queue.WaitCapacity(ctx, r.Topic, r.Overflow, r.MaxLength, int64(len(values)), r.overflowTimeout(), r.Count); err != nil {
+		return 0, err
 	}
 
-	// 开启管道
-	pipe := r.client.WithDB(r.DB).WithContext(r.ctx).Pipeline()
+	var trim = r.trim(int64(len(values)))
+	var count int
 	for _, item := range values {
-		atomic.AddInt64(&r.count, 1)
-		r.AddInternal(item, "", trim, false)
+		value, span := r.envelope(ctx, item)
+		id := r.AddInternal(value, "", trim, false)
+		span.End()
 		trim = false
+		if len(id) > 0 {
+			count++
+		}
 	}
-	cmder, _ := pipe.Exec(r.ctx)
-
-	perm, err := cmder[0].(*redis.StringCmd).Result()
-	if err == redis.Nil {
-
-	}
-	if r.logger != nil {
-		r.logger.Debug("从redis中获取到的值：", perm)
+	r.Metrics.Produced(ctx, "redis_stream", r.Topic, int64(count))
+	if count < len(values) {
+		return count, queue.ErrPublishFailed
 	}
-
-	return len(values)
-
+	return count, nil
 }
 
 // Take 批量消费获取，前移指针StartId
@@ -389,12 +458,14 @@ func (r *RedisStream) RetryAck() int {
 						//Delete(item.Id);
 						r.Claim(r.Group, r.consumer, xPendingExt.ID, r.RetryInterval*1000)
 						r.Ack(r.Group, xPendingExt.ID)
+						r.Metrics.DeadLettered(r.ctx, "redis_stream", r.Topic, r.Group, 1)
 
 					} else {
 						if r.logger != nil {
 							r.logger.Debug(fmt.Sprintf("%s 定时回滚：%v", r.Group, xPendingExt))
 						}
 						r.Claim(r.Group, r.consumer, xPendingExt.ID, r.RetryInterval*1000)
+						r.Metrics.Retried(r.ctx, "redis_stream", r.Topic, r.Group, 1)
 						count++
 					}
 				}
@@ -537,12 +608,16 @@ func (r *RedisStream) ConsumeBlock(ctx context.Context, OnMessage func(msg []red
 				}
 
 				// 处理消息
-				result := OnMessage(mqMsg)
+				r.Metrics.Consumed(ctx, "redis_stream", r.Topic, r.Group, int64(len(mqMsg)))
+				start := time.Now()
+				result := r.handle(ctx, mqMsg, OnMessage)
+				r.Metrics.Handled(ctx, "redis_stream", r.Topic, r.Group, time.Since(start))
 				if result {
 					// 确认消息
 					for _, msg := range mqMsg {
 						r.Acknowledge(msg.ID)
 					}
+					r.Metrics.Acked(ctx, "redis_stream", r.Topic, r.Group, int64(len(mqMsg)))
 				}
 			}
 
@@ -551,6 +626,27 @@ func (r *RedisStream) ConsumeBlock(ctx context.Context, OnMessage func(msg []red
 
 }
 
+// handle 处理一批消息，消费者链路关联各消息的生产者链路
+func (r *RedisStream) handle(ctx context.Context, msgs []redis.XMessage, OnMessage func(msg []redis.XMessage) bool) bool {
+	if r.Tracer == nil {
+		return OnMessage(msgs)
+	}
+	var links []trace.Link
+	for _, item := range msgs {
+		msg := queue.Decode([]byte(r.MessageValue(item)))
+		remote := trace.SpanContextFromContext(queue.Extract(context.Background(), r.Tracer, msg))
+		if remote.IsValid() {
+			links = append(links, trace.Link{SpanContext: remote})
+		}
+	}
+	_, span := r.Tracer.Tracer.Start(ctx, r.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+	)
+	defer span.End()
+	return OnMessage(msgs)
+}
+
 // Delete 删除指定消息
 // id 消息Id
 func (r *RedisStream) Delete(id ...string) int64 {
@@ -586,3 +682,25 @@ func (r *RedisStream) Range(startId string, endId string, count ...int64) []redi
 func (r *RedisStream) RangeTimeSpan(start int64, end int64, count ...int64) []redis.XMessage {
 	return r.Range(fmt.Sprintf("%d-0", start), fmt.Sprintf("%d-0", end), count...)
 }
+
+// Stats 队列长度及各消费组的待确认数、积压数，Topic 为队列key
+func (r *RedisStream) Stats() []queue.Stats {
+	client := r.client.WithDB(r.DB).WithContext(r.ctx)
+	info := client.XInfoStream(r.key)
+	if info == nil {
+		return nil
+	}
+	stats := []queue.Stats{{Topic: r.key, Length: info.Length}}
+	for _, group := range r.GetGroups() {
+		item := queue.Stats{Topic: r.key, Group: group.Name, Pending: group.Pending}
+		item.Lag = queue.StreamLag(client, r.key, group.LastDeliveredID, info.LastGeneratedID, r.MaxLag)
+		stats[0].Pending += group.Pending
+		stats = append(stats, item)
+	}
+	return stats
+}
+
+// MetricsAsync 后台定时采集队列状态指标，interval 为采集间隔
+func (r *RedisStream) MetricsAsync(ctx context.Context, interval time.Duration) {
+	r.Metrics.Poll(ctx, "redis_stream", r.key, interval, r.Stats)
+}
@@ -0,0 +1,137 @@
+package queue_stream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/go-redis/redis/v8"
+)
+
+func init() {
+	queue.Register("stream", func(client cache.ICache, logger glog.ILogger) queue.Broker {
+		return NewStreamQueue(client, logger)
+	})
+}
+
+// Producer 创建生产者
+func (r *StreamQueue) Producer(topic string) queue.Producer {
+	return &streamProducer{stream: r.GetStreamQueue(topic)}
+}
+
+// Consumer 创建消费者，group 为空时独立消费
+func (r *StreamQueue) Consumer(topic string, group string) queue.Consumer {
+	stream := r.GetStreamQueue(topic)
+	stream.Group = group
+	return &streamConsumer{stream: stream}
+}
+
+type streamProducer struct {
+	stream *RedisStream
+}
+
+func (p *streamProducer) Topic() string {
+	return p.stream.Topic
+}
+
+func (p *streamProducer) Publish(ctx context.Context, msgs ...*queue.Message) ([]string, error) {
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Delay > 0 {
+			return ids, queue.ErrDelayNotSupported
+		}
+		id, err := p.stream.TryAdd(ctx, msg)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+type streamConsumer struct {
+	stream *RedisStream
+	once   sync.Once
+}
+
+func (c *streamConsumer) Topic() string {
+	return c.stream.Topic
+}
+
+func (c *streamConsumer) Receive(ctx context.Context, count int64, timeout time.Duration) ([]*queue.Message, error) {
+	if len(c.stream.Group) > 0 {
+		// 自动创建消费组
+		c.once.Do(func() { c.stream.SetGroup(c.stream.Group) })
+	}
+	var rs []redis.XMessage
+	if timeout > 0 {
+		var block = int64(timeout / time.Second)
+		if block == 0 {
+			block = 1
+		}
+		rs = c.stream.TakeMessageBlock(count, block)
+	} else {
+		rs = c.stream.Take(count)
+	}
+	if len(rs) == 0 {
+		return nil, nil
+	}
+
+	// 消费组内从待处理列表获取投递次数
+	var retry map[string]int64
+	if len(c.stream.Group) > 0 {
+		pending := c.stream.Pending(c.stream.Group, rs[0].ID, rs[len(rs)-1].ID, int64(len(rs)))
+		retry = make(map[string]int64, len(pending))
+		for _, p := range pending {
+			retry[p.ID] = p.RetryCount
+		}
+	}
+
+	msgs := make([]*queue.Message, 0, len(rs))
+	for _, item := range rs {
+		msg := queue.Decode([]byte(c.stream.MessageValue(item)))
+		msg.Topic = c.stream.Topic
+		msg.Receipt = item.ID
+		// 信封中是 Nack 重新发布前的投递次数
+		if n, ok := retry[item.ID]; ok && n > 0 {
+			msg.Attempts += n
+		} else {
+			msg.Attempts++
+		}
+		if len(msg.Id) == 0 {
+			msg.Id = item.ID
+		}
+		msgs = append(msgs, msg)
+	}
+	c.stream.Metrics.Consumed(ctx, "redis_stream", c.stream.Topic, c.stream.Group, int64(len(msgs)))
+	return msgs, nil
+}
+
+func (c *streamConsumer) Ack(ctx context.Context, msgs ...*queue.Message) error {
+	var n int64
+	for _, msg := range msgs {
+		n += c.stream.Ack(c.stream.Group, msg.Receipt)
+	}
+	c.stream.Metrics.Acked(ctx, "redis_stream", c.stream.Topic, c.stream.Group, n)
+	return nil
+}
+
+// Nack 重新发布消息后确认原消息，立即重新投递，投递次数保存在信封中
+func (c *streamConsumer) Nack(ctx context.Context, msgs ...*queue.Message) error {
+	for _, msg := range msgs {
+		msg.Topic = c.stream.Topic
+		data, err := queue.Encode(msg)
+		if err != nil {
+			return err
+		}
+		if id := c.stream.AddInternal(string(data), "", false, true); len(id) == 0 {
+			return queue.ErrPublishFailed
+		}
+		c.stream.Ack(c.stream.Group, msg.Receipt)
+		c.stream.Metrics.Retried(ctx, "redis_stream", c.stream.Topic, c.stream.Group, 1)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package queue_stream
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// provider 总是返回同一个 counters
+type provider struct {
+	noop.MeterProvider
+	meter *counters
+}
+
+func (p provider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return p.meter
+}
+
+// counters 记录各计数器的累计值
+type counters struct {
+	noop.Meter
+	locker sync.Mutex
+	values map[string]int64
+}
+
+func (m *counters) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return &counter{counters: m, name: name}, nil
+}
+
+func (m *counters) get(name string) int64 {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.values[name]
+}
+
+type counter struct {
+	noop.Int64Counter
+	counters *counters
+	name     string
+}
+
+func (c *counter) Add(_ context.Context, incr int64, _ ...metric.AddOption) {
+	c.counters.locker.Lock()
+	defer c.counters.locker.Unlock()
+	c.counters.values[c.name] += incr
+}
+
+func TestConsumerNackRedelivers(t *testing.T) {
+	m := &counters{values: make(map[string]int64)}
+	broker := NewStreamQueue(newClient(t), glog.New()).AddMetrics(queue.NewMetrics(queue.WithMeterProvider(provider{meter: m})))
+	topic := newTopic("nack")
+	ctx := context.Background()
+	consumer := broker.Consumer(topic, "g")
+	_, err := broker.Producer(topic).Publish(ctx, queue.NewMessage([]byte("a")))
+	assert.Nil(t, err)
+
+	msgs, err := consumer.Receive(ctx, 1, time.Second)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, int64(1), msgs[0].Attempts)
+	assert.Equal(t, int64(1), m.get("messaging.queue.consumed"))
+	id := msgs[0].Id
+
+	// 拒绝后立即重新投递，不等待 RetryAck
+	assert.Nil(t, consumer.Nack(ctx, msgs...))
+	msgs, err = consumer.Receive(ctx, 1, time.Second)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, id, msgs[0].Id)
+	assert.Equal(t, "a", string(msgs[0].Body))
+	assert.Equal(t, int64(2), msgs[0].Attempts)
+	assert.Nil(t, consumer.Ack(ctx, msgs...))
+	assert.Equal(t, int64(2), m.get("messaging.queue.consumed"))
+	assert.Equal(t, int64(1), m.get("messaging.queue.retried"))
+	assert.Equal(t, int64(1), m.get("messaging.queue.acked"))
+
+	// 原消息已确认，待处理列表为空
+	assert.Equal(t, int64(0), broker.GetStreamQueue(topic).GetPending("g").Count)
+}
@@ -1,13 +1,20 @@
 package queue_stream
 
 import (
+	"context"
+	"time"
+
 	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue"
+	"github.com/donetkit/contrib/tracer"
 	"github.com/donetkit/contrib/utils/cache"
 )
 
 type StreamQueue struct {
-	client cache.ICache
-	logger glog.ILogger
+	client  cache.ICache
+	logger  glog.ILogger
+	tracer  *tracer.Server
+	metrics *queue.Metrics
 }
 
 func NewStreamQueue(client cache.ICache, logger glog.ILogger) *StreamQueue {
@@ -17,6 +24,50 @@ func NewStreamQueue(client cache.ICache, logger glog.ILogger) *StreamQueue {
 	}
 }
 
+// AddTrace 设置链路追踪，生产时注入链路信息，消费时关联生产者链路
+func (r *StreamQueue) AddTrace(tracer *tracer.Server) *StreamQueue {
+	r.tracer = tracer
+	return r
+}
+
+// AddMetrics 设置指标，记录生产、消费、确认、重试及死信数
+func (r *StreamQueue) AddMetrics(metrics *queue.Metrics) *StreamQueue {
+	r.metrics = metrics
+	return r
+}
+
 func (r *StreamQueue) GetStreamQueue(topic string) *RedisStream {
-	return New(r.client, topic, r.logger)
+	stream := New(r.client, topic, r.logger)
+	stream.Tracer = r.tracer
+	stream.Metrics = r.metrics
+	return stream
+}
+
+// GetPartitionedQueue 获取分区Stream，partitions 为分区数
+func (r *StreamQueue) GetPartitionedQueue(topic string, partitions int) *PartitionedStream {
+	stream := NewPartitioned(r.client, topic, partitions, r.logger)
+	stream.Metrics = r.metrics
+	for _, item := range stream.streams {
+		item.Tracer = r.tracer
+		item.Metrics = r.metrics
+	}
+	return stream
+}
+
+// DeleteTopic 删除主题及其消费组，用于清理临时主题，如 RPC 客户端的应答主题
+func (r *StreamQueue) DeleteTopic(topic string) error {
+	ctx := context.Background()
+	pipe := r.client.Pipeline()
+	pipe.Del(ctx, topic)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ExpireTopic 设置主题的过期时间，临时主题的所有者异常退出没有删除时自动清理
+func (r *StreamQueue) ExpireTopic(topic string, ttl time.Duration) error {
+	ctx := context.Background()
+	pipe := r.client.Pipeline()
+	pipe.Expire(ctx, topic, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
 }
@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/donetkit/contrib/tracer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Inject 将 ctx 中的链路信息写入消息头
+func Inject(ctx context.Context, tracerServer *tracer.Server, msg *Message) {
+	if tracerServer == nil || tracerServer.Propagators == nil {
+		return
+	}
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	tracerServer.Propagators.Inject(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+// Extract 从消息头读取生产者的链路信息
+func Extract(ctx context.Context, tracerServer *tracer.Server, msg *Message) context.Context {
+	if tracerServer == nil || tracerServer.Propagators == nil || msg.Headers == nil {
+		return ctx
+	}
+	return tracerServer.Propagators.Extract(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+// StartProducerSpan 开始生产者链路，并将链路信息写入消息头
+// system 为队列类型，如 redis_stream
+func StartProducerSpan(ctx context.Context, tracerServer *tracer.Server, system string, msg *Message) (context.Context, trace.Span) {
+	if tracerServer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	ctx, span := tracerServer.Tracer.Start(ctx, msg.Topic+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messageAttributes(system, msg)...),
+	)
+	Inject(ctx, tracerServer, msg)
+	return ctx, span
+}
+
+// StartConsumerSpan 开始消费者链路
+// ctx 中没有链路时作为生产者链路的子链路，否则作为 ctx 的子链路并关联生产者链路
+func StartConsumerSpan(ctx context.Context, tracerServer *tracer.Server, system string, msg *Message) (context.Context, trace.Span) {
+	if tracerServer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messageAttributes(system, msg)...),
+		trace.WithAttributes(semconv.MessagingOperationProcess),
+	}
+	remote := trace.SpanContextFromContext(Extract(context.Background(), tracerServer, msg))
+	if remote.IsValid() {
+		if trace.SpanContextFromContext(ctx).IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: remote}))
+		} else {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, remote)
+		}
+	}
+	return tracerServer.Tracer.Start(ctx, msg.Topic+" process", opts...)
+}
+
+// Tracing 消费链路中间件，为每条消息开始消费者链路并记录处理错误
+func Tracing(tracerServer *tracer.Server, system string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			ctx, span := StartConsumerSpan(ctx, tracerServer, system, msg)
+			defer span.End()
+			err := next(ctx, msg)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		}
+	}
+}
+
+func messageAttributes(system string, msg *Message) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String(system),
+		semconv.MessagingDestinationKey.String(msg.Topic),
+		semconv.MessagingMessageIDKey.String(msg.Id),
+		semconv.MessagingMessagePayloadSizeBytesKey.Int(len(msg.Body)),
+	}
+	if msg.Attempts > 1 {
+		attrs = append(attrs, attribute.Int64("messaging.attempts", msg.Attempts))
+	}
+	return attrs
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/queue/queue_admin"
+	rredis "github.com/donetkit/contrib/db/redis"
+	"github.com/donetkit/contrib/server/webserve"
+)
+
+const usage = `usage: admin [flags] <command> [args]
+
+commands:
+  topics [match]                       列出队列
+  info <key>                           队列详情、消费组积压及消费者
+  peek <key> [count]                   查看消息
+  dead <key> <group> [idleSeconds]     查看死信
+  replay <key> <group> [id...]         重新投递死信，可靠队列的 group 为消费者状态Key
+  reset <key> <group> [startId]        重置消费组消费位置，默认 $
+  clean <key> <group> [idleSeconds]    删除没有待确认消息的空闲消费者，默认空闲1小时
+  serve                                启动管理接口
+
+flags:
+`
+
+var logs = glog.New()
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1", "redis address")
+	port := flag.Int("port", 6379, "redis port")
+	password := flag.String("password", "", "redis password")
+	db := flag.Int("db", 0, "redis db")
+	listen := flag.Int("listen", 8080, "admin http port for serve")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client := rredis.New(rredis.WithLogger(logs), rredis.WithAddr(*addr), rredis.WithPort(*port), rredis.WithPassword(*password), rredis.WithDB(*db))
+	admin := queue_admin.New(client, queue_admin.WithDB(*db), queue_admin.WithLogger(logs))
+
+	if args[0] == "serve" {
+		mux := http.NewServeMux()
+		mux.Handle("/queue/", http.StripPrefix("/queue", admin.Handler()))
+		webserve.New(webserve.WithServiceName("queue-admin"), webserve.WithPort(*listen), webserve.WithLogger(logs)).AddHandler(mux).Run()
+		return
+	}
+
+	data, err := run(context.Background(), admin, args[0], args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	out, _ := json.MarshalIndent(data, "", "  ")
+	fmt.Println(string(out))
+}
+
+func run(ctx context.Context, admin *queue_admin.Admin, command string, args []string) (interface{}, error) {
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	number := func(i int, def int64) int64 {
+		var value int64
+		if _, err := fmt.Sscan(arg(i), &value); err != nil {
+			return def
+		}
+		return value
+	}
+	switch command {
+	case "topics":
+		return admin.Topics(ctx, arg(0))
+	case "info":
+		return admin.Info(ctx, arg(0))
+	case "peek":
+		return admin.Peek(ctx, arg(0), "", number(1, 10))
+	case "dead":
+		return admin.DeadLetters(ctx, arg(0), arg(1), time.Duration(number(2, 60))*time.Second, 100)
+	case "replay":
+		var ids []string
+		if len(args) > 2 {
+			ids = args[2:]
+		}
+		count, err := admin.Replay(ctx, arg(0), arg(1), ids...)
+		return map[string]int64{"count": count}, err
+	case "reset":
+		return map[string]bool{"ok": true}, admin.ResetGroup(ctx, arg(0), arg(1), arg(2))
+	case "clean":
+		return admin.DeleteConsumers(ctx, arg(0), arg(1), "", time.Duration(number(2, 3600))*time.Second)
+	}
+	return nil, fmt.Errorf("unknown command: %s", command)
+}
@@ -32,7 +32,7 @@ func main() {
 
 	var RedisClient = rredis.New(rredis.WithLogger(logs), rredis.WithAddr("127.0.0.1"), rredis.WithDB(13), rredis.WithPassword(""), rredis.WithTracer(traceServer))
 
-	fullRedis := queue_stream.NewStreamQueue(RedisClient, logs)
+	fullRedis := queue_stream.NewStreamQueue(RedisClient, logs).AddTrace(traceServer)
 
 	queue1 := fullRedis.GetStreamQueue(topic)
 	queue1.BlockTime = 5
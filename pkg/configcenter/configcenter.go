@@ -0,0 +1,239 @@
+// Package configcenter binds the values under a KV prefix to a Go struct and
+// keeps it up to date, e.g.
+//
+//	type Config struct {
+//		DB struct {
+//			Host string `yaml:"host"`
+//			Port int    `yaml:"port"`
+//		} `yaml:"db"`
+//		Timeout time.Duration `yaml:"timeout"`
+//	}
+//
+//	center := configcenter.New[Config](consulClient, "config/user-service/")
+//	center.Validate(func(c *Config) error { ... })
+//	center.Subscribe(func(old, new *Config) { pool.Resize(new.DB) })
+//	if err := center.Start(); err != nil { ... }
+//	defer center.Stop()
+//	timeout := center.Get().Timeout
+//
+// The key relative to the prefix is the path of its value, config/user-service/db
+// may hold the yaml or json of the whole db section and config/user-service/db/port
+// only the port. Changes are watched with discovery.KVWatcher when the KV
+// implements it (consul blocking queries, etcd watches, the memory registry)
+// and polled otherwise.
+package configcenter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery"
+)
+
+// Validator is implemented by configs validating themselves
+type Validator interface {
+	Validate() error
+}
+
+// ChangeFunc is called with the previous and the current config, in order
+// and never concurrently. The configs must not be modified.
+type ChangeFunc[T any] func(old, new *T)
+
+type Center[T any] struct {
+	kv     discovery.KV
+	prefix string
+	options
+
+	value atomic.Pointer[T]
+
+	locker      sync.RWMutex
+	validators  []func(v *T) error
+	subscribers []ChangeFunc[T]
+	err         error
+	cancel      context.CancelFunc
+	done        chan struct{}
+
+	// serializes the updates and the subscribers
+	applying sync.Mutex
+	loaded   bool
+	raw      map[string][]byte
+}
+
+func New[T any](kv discovery.KV, prefix string, opts ...Option) *Center[T] {
+	c := &Center[T]{
+		kv:      kv,
+		prefix:  prefix,
+		options: options{interval: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(&c.options)
+	}
+	c.value.Store(new(T))
+	return c
+}
+
+// Validate adds a validator, a config failing any validator is rejected and
+// the previous one is kept
+func (c *Center[T]) Validate(fn func(v *T) error) *Center[T] {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.validators = append(c.validators, fn)
+	return c
+}
+
+// Subscribe adds a callback called when the config changes
+func (c *Center[T]) Subscribe(fn ChangeFunc[T]) *Center[T] {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.subscribers = append(c.subscribers, fn)
+	return c
+}
+
+// Get returns the current config, the zero value before Start. The config
+// must not be modified.
+func (c *Center[T]) Get() *T {
+	return c.value.Load()
+}
+
+// Err returns the error of the latest update, nil when it was applied
+func (c *Center[T]) Err() error {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	return c.err
+}
+
+// Start loads the config and watches the changes in the background. The
+// watch is not started when the config cannot be loaded or is invalid.
+func (c *Center[T]) Start() error {
+	if err := c.Reload(); err != nil {
+		return err
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if c.cancel != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel, c.done = cancel, make(chan struct{})
+	go c.run(ctx, c.done)
+	return nil
+}
+
+// Stop stops watching the changes
+func (c *Center[T]) Stop() {
+	c.locker.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel, c.done = nil, nil
+	c.locker.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// Reload reads and applies the values under the prefix
+func (c *Center[T]) Reload() error {
+	values, err := c.kv.List(c.prefix)
+	if err != nil && !errors.Is(err, discovery.ErrNotFound) {
+		c.setErr(err)
+		return err
+	}
+	return c.apply(values)
+}
+
+func (c *Center[T]) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	if watcher, ok := c.kv.(discovery.KVWatcher); ok {
+		_ = watcher.WatchList(ctx, c.prefix, func(values map[string][]byte) {
+			_ = c.apply(values)
+		})
+		return
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = c.Reload()
+		}
+	}
+}
+
+// apply decodes and validates values, then swaps the config and calls the
+// subscribers with the previous and the current config, except for the first
+// config loaded
+func (c *Center[T]) apply(values map[string][]byte) error {
+	c.applying.Lock()
+	defer c.applying.Unlock()
+	if c.loaded && reflect.DeepEqual(c.raw, values) {
+		return nil
+	}
+	v, err := c.decode(values)
+	if err != nil {
+		if c.logger != nil {
+			c.logger.Warningf("config %s rejected: %s", c.prefix, err.Error())
+		}
+		c.setErr(err)
+		return err
+	}
+	c.setErr(nil)
+	c.raw = values
+	old := c.value.Load()
+	// the first config is not a change
+	if !c.loaded {
+		c.loaded = true
+		c.value.Store(v)
+		return nil
+	}
+	if reflect.DeepEqual(old, v) {
+		return nil
+	}
+	c.value.Store(v)
+	if c.logger != nil {
+		c.logger.Infof("config %s updated", c.prefix)
+	}
+	c.locker.RLock()
+	subscribers := c.subscribers
+	c.locker.RUnlock()
+	for _, fn := range subscribers {
+		fn(old, v)
+	}
+	return nil
+}
+
+func (c *Center[T]) decode(values map[string][]byte) (*T, error) {
+	doc, err := document(c.prefix, values)
+	if err != nil {
+		return nil, err
+	}
+	v := new(T)
+	if err = decode(doc, v, c.jsonTags); err != nil {
+		return nil, err
+	}
+	if validator, ok := interface{}(v).(Validator); ok {
+		if err = validator.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	c.locker.RLock()
+	validators := c.validators
+	c.locker.RUnlock()
+	for _, fn := range validators {
+		if err = fn(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (c *Center[T]) setErr(err error) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.err = err
+}
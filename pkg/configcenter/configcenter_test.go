@@ -0,0 +1,118 @@
+package configcenter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/discovery/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+type config struct {
+	DB struct {
+		Host string `yaml:"host" json:"host"`
+		Port int    `yaml:"port" json:"port"`
+	} `yaml:"db" json:"db"`
+	Timeout time.Duration `yaml:"timeout"`
+	Tags    []string      `yaml:"tags" json:"tags"`
+}
+
+func (c *config) Validate() error {
+	if c.DB.Port < 0 {
+		return errors.New("negative port")
+	}
+	return nil
+}
+
+type change struct {
+	old, new *config
+}
+
+func TestCenter(t *testing.T) {
+	registry := memory.NewRegistry()
+	defer registry.Close()
+	_ = registry.Set("app/", "timeout: 3s\ndb:\n  host: a\n  port: 1")
+	_ = registry.Set("app/db/port", "3306")
+	_ = registry.Set("app/tags", `["x", "y"]`)
+	_ = registry.Set("other/db/port", "1")
+
+	changes := make(chan change, 10)
+	center := New[config](registry, "app/").
+		Validate(func(c *config) error {
+			if c.DB.Host == "" {
+				return errors.New("host required")
+			}
+			return nil
+		}).
+		Subscribe(func(old, new *config) { changes <- change{old, new} })
+	assert.Nil(t, center.Start())
+	defer center.Stop()
+
+	first := center.Get()
+	assert.Equal(t, "a", first.DB.Host)
+	assert.Equal(t, 3306, first.DB.Port)
+	assert.Equal(t, 3*time.Second, first.Timeout)
+	assert.Equal(t, []string{"x", "y"}, first.Tags)
+	assert.Len(t, changes, 0)
+
+	_ = registry.Set("app/db", `{"host": "b"}`)
+	c := <-changes
+	assert.Same(t, first, c.old)
+	assert.Equal(t, "b", c.new.DB.Host)
+	assert.Equal(t, 3306, c.new.DB.Port)
+	assert.Same(t, c.new, center.Get())
+
+	// invalid values keep the previous config
+	_ = registry.Set("app/db/port", "-1")
+	assert.Eventually(t, func() bool { return center.Err() != nil }, time.Second, 10*time.Millisecond)
+	assert.EqualError(t, center.Err(), "negative port")
+	_ = registry.Set("app/db/port", "not a port")
+	assert.Eventually(t, func() bool { return center.Err() != nil && center.Err().Error() != "negative port" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3306, center.Get().DB.Port)
+
+	_ = registry.Delete("app/db/port")
+	c = <-changes
+	assert.Equal(t, 3306, c.old.DB.Port)
+	assert.Equal(t, 1, c.new.DB.Port)
+	assert.Nil(t, center.Err())
+
+	// unrelated keys do not notify
+	_ = registry.Set("other/db/port", "2")
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, changes, 0)
+}
+
+func TestCenterStartInvalid(t *testing.T) {
+	registry := memory.NewRegistry()
+	defer registry.Close()
+	_ = registry.Set("app/db/port", "-1")
+	center := New[config](registry, "app/")
+	assert.EqualError(t, center.Start(), "negative port")
+	assert.Equal(t, 0, center.Get().DB.Port)
+
+	// an empty prefix is the zero config
+	center = New[config](registry, "none/")
+	assert.Nil(t, center.Start())
+	center.Stop()
+}
+
+// pollKV hides the KVWatcher of the registry
+type pollKV struct {
+	discovery.KV
+}
+
+func TestCenterPoll(t *testing.T) {
+	registry := memory.NewRegistry()
+	defer registry.Close()
+	_ = registry.Set("app/db", `{"host": "a", "port": 1}`)
+	center := New[config](pollKV{registry}, "app/", WithInterval(10*time.Millisecond), WithJSONTags())
+	assert.Nil(t, center.Start())
+	defer center.Stop()
+	assert.Equal(t, "a", center.Get().DB.Host)
+
+	_ = registry.Set("app/db/host", "b")
+	assert.Eventually(t, func() bool { return center.Get().DB.Host == "b" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, center.Get().DB.Port)
+}
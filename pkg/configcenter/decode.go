@@ -0,0 +1,114 @@
+package configcenter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// document merges the values under prefix into one yaml mapping. The key
+// relative to prefix is the path of the value, e.g. prefix/db/host sets
+// db.host, and the value of prefix itself is merged at the root. Longer keys
+// are merged later and override the fields set by shorter ones.
+func document(prefix string, values map[string][]byte) (*yaml.Node, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, key := range keys {
+		// consul folders have no value
+		if len(strings.TrimSpace(string(values[key]))) == 0 {
+			continue
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(values[key], &doc); err != nil {
+			return nil, fmt.Errorf("config center: decode %s: %w", key, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		value := doc.Content[0]
+
+		var path []string
+		for _, part := range strings.Split(strings.TrimPrefix(key, prefix), "/") {
+			if len(part) > 0 {
+				path = append(path, part)
+			}
+		}
+		if len(path) == 0 {
+			if value.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("config center: decode %s: not a mapping", key)
+			}
+			merge(root, value)
+			continue
+		}
+		parent := root
+		for _, name := range path[:len(path)-1] {
+			child := lookup(parent, name)
+			if child == nil || child.Kind != yaml.MappingNode {
+				child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				set(parent, name, child)
+			}
+			parent = child
+		}
+		name := path[len(path)-1]
+		if child := lookup(parent, name); child != nil && child.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			merge(child, value)
+			continue
+		}
+		set(parent, name, value)
+	}
+	return root, nil
+}
+
+// decode decodes the document into out by the yaml tags, or by the json tags
+func decode(doc *yaml.Node, out interface{}, jsonTags bool) error {
+	if !jsonTags {
+		return doc.Decode(out)
+	}
+	var v interface{}
+	if err := doc.Decode(&v); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func merge(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		name, value := src.Content[i].Value, src.Content[i+1]
+		if child := lookup(dst, name); child != nil && child.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			merge(child, value)
+			continue
+		}
+		set(dst, name, value)
+	}
+}
+
+func lookup(m *yaml.Node, name string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == name {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func set(m *yaml.Node, name string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == name {
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, value)
+}
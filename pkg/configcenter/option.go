@@ -0,0 +1,39 @@
+package configcenter
+
+import (
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+)
+
+type options struct {
+	interval time.Duration
+	jsonTags bool
+	logger   glog.ILoggerEntry
+}
+
+// Option for config center
+type Option func(*options)
+
+// WithInterval set the polling interval for a KV without change notification, default 10 seconds
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) {
+		if interval > 0 {
+			o.interval = interval
+		}
+	}
+}
+
+// WithJSONTags decode the values by the json tags of the struct instead of the yaml tags
+func WithJSONTags() Option {
+	return func(o *options) {
+		o.jsonTags = true
+	}
+}
+
+// WithLogger set logger function
+func WithLogger(logger glog.ILogger) Option {
+	return func(o *options) {
+		o.logger = logger.WithField("ConfigCenter", "ConfigCenter")
+	}
+}
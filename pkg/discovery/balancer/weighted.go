@@ -0,0 +1,218 @@
+// Package balancer provides a weighted round-robin gRPC balancer driven by the
+// weights of discovered service instances. With selectors set on the resolver
+// state, every RPC is only sent to the instances selected for its context.
+package balancer
+
+import (
+	"sync"
+
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/balancer/weightedroundrobin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+// Name is the name of the weighted round-robin balancer
+const Name = "discovery_weighted_round_robin"
+
+func init() {
+	balancer.Register(&builder{})
+}
+
+// SetWeight returns a copy of addr carrying the weight of the instance
+func SetWeight(addr resolver.Address, weight uint32) resolver.Address {
+	return weightedroundrobin.SetAddrInfo(addr, weightedroundrobin.AddrInfo{Weight: weight})
+}
+
+// GetWeight returns the weight carried by addr, 1 when not set
+func GetWeight(addr resolver.Address) uint32 {
+	if weight := weightedroundrobin.GetAddrInfo(addr).Weight; weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+type instanceKey struct{}
+
+// SetInstance returns a copy of addr carrying the instance seen by the selectors
+func SetInstance(addr resolver.Address, instance servicediscovery.ServiceInstance) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(instanceKey{}, instance)
+	return addr
+}
+
+// GetInstance returns the instance carried by addr, nil when not set
+func GetInstance(addr resolver.Address) servicediscovery.ServiceInstance {
+	instance, _ := addr.BalancerAttributes.Value(instanceKey{}).(servicediscovery.ServiceInstance)
+	return instance
+}
+
+type selectorsKey struct{}
+
+// selectors is stored by pointer, the attributes of a resolver state are compared
+type selectors struct {
+	list []servicediscovery.Selector
+}
+
+// SetSelectors returns a copy of state carrying the selectors applied to the
+// instances of the addresses for every RPC. The route of the RPC is read from
+// its outgoing metadata, see servicediscovery.RouteFromHeader.
+func SetSelectors(state resolver.State, list ...servicediscovery.Selector) resolver.State {
+	state.Attributes = state.Attributes.WithValue(selectorsKey{}, &selectors{list: list})
+	return state
+}
+
+// addrInfo holds the latest weight and instance of every address and the
+// selectors. The base balancer keeps the address a SubConn was created with,
+// so changes are read from here.
+type addrInfo struct {
+	locker    sync.RWMutex
+	weights   map[string]uint32
+	instances map[string]servicediscovery.ServiceInstance
+	selectors []servicediscovery.Selector
+}
+
+func (a *addrInfo) update(state resolver.State) {
+	weights := make(map[string]uint32, len(state.Addresses))
+	instances := make(map[string]servicediscovery.ServiceInstance, len(state.Addresses))
+	for _, addr := range state.Addresses {
+		weights[addr.Addr] = GetWeight(addr)
+		if instance := GetInstance(addr); instance != nil {
+			instances[addr.Addr] = instance
+		}
+	}
+	var list []servicediscovery.Selector
+	if s, ok := state.Attributes.Value(selectorsKey{}).(*selectors); ok {
+		list = s.list
+	}
+	a.locker.Lock()
+	a.weights, a.instances, a.selectors = weights, instances, list
+	a.locker.Unlock()
+}
+
+func (a *addrInfo) weight(addr resolver.Address) uint32 {
+	a.locker.RLock()
+	defer a.locker.RUnlock()
+	if weight, ok := a.weights[addr.Addr]; ok {
+		return weight
+	}
+	return GetWeight(addr)
+}
+
+func (a *addrInfo) instance(addr resolver.Address) servicediscovery.ServiceInstance {
+	a.locker.RLock()
+	defer a.locker.RUnlock()
+	if instance, ok := a.instances[addr.Addr]; ok {
+		return instance
+	}
+	return GetInstance(addr)
+}
+
+type builder struct{}
+
+func (*builder) Name() string {
+	return Name
+}
+
+func (*builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	info := &addrInfo{}
+	b := base.NewBalancerBuilder(Name, &pickerBuilder{info: info}, base.Config{HealthCheck: true})
+	return &weightedBalancer{Balancer: b.Build(cc, opts), info: info}
+}
+
+type weightedBalancer struct {
+	balancer.Balancer
+	info *addrInfo
+}
+
+func (b *weightedBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	b.info.update(s.ResolverState)
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type pickerBuilder struct {
+	info *addrInfo
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	peers := make([]*peer, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		peers = append(peers, &peer{subConn: sc, weight: int64(pb.info.weight(sci.Address)), instance: pb.info.instance(sci.Address)})
+	}
+	pb.info.locker.RLock()
+	defer pb.info.locker.RUnlock()
+	return &picker{peers: peers, selectors: pb.info.selectors}
+}
+
+type peer struct {
+	subConn  balancer.SubConn
+	weight   int64
+	current  int64
+	instance servicediscovery.ServiceInstance
+}
+
+// picker implements the smooth weighted round-robin of nginx: every pick adds
+// each weight to its current value and picks the largest, which is then
+// lowered by the total weight. Picks are spread evenly instead of in bursts.
+type picker struct {
+	locker    sync.Mutex
+	peers     []*peer
+	selectors []servicediscovery.Selector
+}
+
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	peers := p.peers
+	if len(p.selectors) > 0 {
+		peers = p.selected(info)
+		if len(peers) == 0 {
+			return balancer.PickResult{}, status.Error(codes.Unavailable, "discovery balancer: no instance selected")
+		}
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	var (
+		best  *peer
+		total int64
+	)
+	for _, peer := range peers {
+		peer.current += peer.weight
+		total += peer.weight
+		if best == nil || peer.current > best.current {
+			best = peer
+		}
+	}
+	best.current -= total
+	return balancer.PickResult{SubConn: best.subConn}, nil
+}
+
+// selected returns the peers of the instances selected for the RPC, the
+// weighted round-robin then runs among them only
+func (p *picker) selected(info balancer.PickInfo) []*peer {
+	ctx := info.Ctx
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		ctx = servicediscovery.WithRoute(ctx, servicediscovery.RouteFromHeader(md))
+	}
+	instances := make([]servicediscovery.ServiceInstance, 0, len(p.peers))
+	for _, peer := range p.peers {
+		if peer.instance != nil {
+			instances = append(instances, peer.instance)
+		}
+	}
+	ids := make(map[string]bool)
+	for _, instance := range servicediscovery.Select(ctx, instances, p.selectors...) {
+		ids[instance.GetId()] = true
+	}
+	peers := make([]*peer, 0, len(ids))
+	for _, peer := range p.peers {
+		if peer.instance != nil && ids[peer.instance.GetId()] {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
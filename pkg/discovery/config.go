@@ -1,10 +1,14 @@
 package discovery
 
+import "github.com/donetkit/contrib/pkg/health"
+
 type Config struct {
 	Id                 string
 	Name               string
 	RegisterAddr       string
 	RegisterPort       int
+	ServiceAddr        string // 注册的服务地址，默认 CheckAddr
+	ServicePort        int    // 注册的服务端口，默认 CheckPort
 	CheckAddr          string
 	CheckPort          int
 	Tags               []string
@@ -14,6 +18,50 @@ type Config struct {
 	HttpRouter         HttpRouter
 	CheckHealthyStatus bool
 	CheckResponse      *CheckResponse
-	CheckType          string // 检查类型 HTTP TCP GRPC
+	CheckType          string // 检查类型 HTTP TCP GRPC TTL
 	CheckPath          string
+	TTL                int                 // CheckType 为 TTL 时的过期时间(秒)，注册后自动上报
+	Checks             []*Check            // CheckType 之外的其它检查
+	Metadata           map[string]string   // 服务元数据
+	Weights            *Weights            // 服务权重
+	Health             *health.Manager     // 服务自检，CheckHealthyStatus 时使用，默认按 CheckType 创建
+	HealthActions      []health.ChangeFunc // 自检状态变化时的动作，默认 health.DeregisterAction
+}
+
+// Address 注册的服务地址和端口，未设置 ServiceAddr、ServicePort 时使用 CheckAddr、CheckPort
+func (c *Config) Address() (string, int) {
+	address, port := c.ServiceAddr, c.ServicePort
+	if len(address) == 0 {
+		address = c.CheckAddr
+	}
+	if port == 0 {
+		port = c.CheckPort
+	}
+	return address, port
+}
+
+// Weight 没有 warning 状态的注册中心使用的实例权重，为 Weights.Passing，未设置时为 10
+func (c *Config) Weight() float64 {
+	if c.Weights == nil || c.Weights.Passing <= 0 {
+		return 10
+	}
+	return float64(c.Weights.Passing)
+}
+
+// Weights 服务权重，检查全部通过时为 Passing，存在 warning 检查时为 Warning
+type Weights struct {
+	Passing int
+	Warning int
+}
+
+// Check 健康检查
+type Check struct {
+	Id       string // 默认 service:服务ID:Name
+	Name     string
+	Type     string // 检查类型 HTTP TCP GRPC TTL
+	Target   string // HTTP 为 URL，TCP 为 host:port，GRPC 为 host:port 或 host:port/service
+	Interval int    // 检查间隔(秒)，默认 IntervalTime
+	Timeout  int    // 超时时间(秒)，默认 TimeOut
+	TTL      int    // TTL 检查的过期时间(秒)，注册后自动上报
+	Notes    string
 }
@@ -1,14 +1,15 @@
 package consul
 
 import (
+	"context"
 	"fmt"
 	discovery2 "github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/health"
 	"github.com/donetkit/contrib/utils/host"
 	"github.com/donetkit/contrib/utils/uuid"
 	consulApi "github.com/hashicorp/consul/api"
 	"github.com/pkg/errors"
-	"net"
-	"os"
+	"sync"
 	"time"
 )
 
@@ -40,6 +41,10 @@ HTTP
 type Client struct {
 	client  *consulApi.Client
 	options *discovery2.Config
+
+	locker    sync.Mutex
+	ttlCancel context.CancelFunc
+	ttlDone   chan struct{}
 }
 
 func New(opts ...discovery2.Option) (*Client, error) {
@@ -79,72 +84,60 @@ func (s *Client) SetTags(tags ...string) {
 	s.options.Tags = tags
 }
 
-func (s *Client) checkHealthyStatus() {
-	if s.options.CheckHealthyStatus {
-		switch s.options.CheckType {
-		case "HTTP":
-			s.checkHealthyHttp()
-		case "TCP":
-			s.checkHealthyTCP()
-		case "GRPC":
-			s.checkHealthyGRPC()
-		}
-	}
+// ApiClient 返回 consul 客户端，用于选主等直接使用 consul 的功能
+func (s *Client) ApiClient() *consulApi.Client {
+	return s.client
+}
 
+// Health 返回服务自检，未开启 CheckHealthyStatus 时为 nil
+func (s *Client) Health() *health.Manager {
+	return s.options.Health
 }
 
-func (s *Client) checkHealthyHttp() {
-	go func() {
-		time.Sleep(time.Second * 5)
-		ticker := time.NewTicker(time.Duration(s.options.IntervalTime) * time.Second)
-		for {
-			select {
-			case <-ticker.C:
-				var timeNow = time.Now().Add(time.Duration(s.options.IntervalTime*s.options.CheckResponse.RetryCount)*time.Second*-1).UnixNano() / 1000 / 1000
-				if timeNow > s.options.CheckResponse.GetOnTime() {
-					ticker.Stop()
-					os.Exit(3)
-				}
-			}
+// checkHealthyStatus 按 CheckType 自检，失败时执行 HealthActions，默认注销服务，恢复后重新注册
+func (s *Client) checkHealthyStatus() {
+	if !s.options.CheckHealthyStatus {
+		return
+	}
+	interval := time.Duration(s.options.IntervalTime) * time.Second
+	manager := s.options.Health
+	if manager == nil {
+		manager = health.New(
+			health.WithInterval(interval),
+			health.WithInitialDelay(5*time.Second),
+			health.WithTimeout(time.Duration(s.options.TimeOut)*time.Second),
+			health.WithFailureThreshold(s.options.CheckResponse.RetryCount))
+		s.options.Health = manager
+	}
+	addr := fmt.Sprintf("%s:%d", s.options.CheckAddr, s.options.CheckPort)
+	switch s.options.CheckType {
+	case "HTTP":
+		// 直接请求检查地址，不依赖 consul 的调用，注销后 consul 不再检查时也能发现恢复
+		manager.AddLiveness("discovery-http", health.HTTPChecker(s.options.CheckPath))
+	case "TCP":
+		manager.AddLiveness("discovery-tcp", health.TCPChecker(addr))
+	case "GRPC":
+		if len(s.options.CheckPath) > 0 {
+			addr = s.options.CheckPath
 		}
-	}()
+		manager.AddLiveness("discovery-grpc", health.GRPCChecker(addr))
+	}
+	actions := s.options.HealthActions
+	if len(actions) == 0 {
+		actions = []health.ChangeFunc{health.DeregisterAction(s)}
+	}
+	for _, action := range actions {
+		manager.OnChange(action)
+	}
+	manager.Start()
 }
 
-func (s *Client) checkHealthyTCP() {
-	go func() {
-		time.Sleep(time.Second * 5)
-		ticker := time.NewTicker(time.Duration(s.options.IntervalTime) * time.Second)
-		for {
-			select {
-			case <-ticker.C:
-				conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", s.options.CheckAddr, s.options.CheckPort), 3*time.Second)
-				if err == nil {
-					conn.Close()
-					s.options.CheckResponse.Result()
-				}
-				var timeNow = time.Now().Add(time.Duration(s.options.IntervalTime*s.options.CheckResponse.RetryCount)*time.Second*-1).UnixNano() / 1000 / 1000
-				if timeNow > s.options.CheckResponse.GetOnTime() {
-					ticker.Stop()
-					os.Exit(3)
-				}
-			}
-		}
-	}()
+// EnableMaintenance 服务进入维护模式，consul 中为 critical，不再被发现
+func (s *Client) EnableMaintenance(reason string) error {
+	return s.client.Agent().EnableServiceMaintenance(s.options.Id, reason)
 }
 
-func (s *Client) checkHealthyGRPC() {
-	go func() {
-		time.Sleep(time.Second * 5)
-		ticker := time.NewTicker(time.Duration(s.options.IntervalTime) * time.Second)
-		for {
-			select {
-			case <-ticker.C:
-				var timeNow = time.Now().Add(time.Duration(s.options.IntervalTime*s.options.CheckResponse.RetryCount)*time.Second*-1).UnixNano() / 1000 / 1000
-				if timeNow > s.options.CheckResponse.GetOnTime() {
-					ticker.Stop()
-					os.Exit(3)
-				}
-			}
-		}
-	}()
+// DisableMaintenance 服务退出维护模式
+func (s *Client) DisableMaintenance() error {
+	return s.client.Agent().DisableServiceMaintenance(s.options.Id)
 }
@@ -1,13 +1,28 @@
 package consul
 
 import (
+	"context"
 	"fmt"
+	discovery2 "github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/health"
 	consulApi "github.com/hashicorp/consul/api"
 	"github.com/pkg/errors"
+	"time"
 )
 
 func (s *Client) Register() error {
+	svcReg := s.registration()
+	err := s.client.Agent().ServiceRegister(svcReg)
+	if err != nil {
+		return errors.Wrap(err, "register service error")
+	}
+	s.startTTL(svcReg)
+	return nil
+}
+
+func (s *Client) registration() *consulApi.AgentServiceRegistration {
 	check := &consulApi.AgentServiceCheck{
+		CheckID:                        "service:" + s.options.Id,
 		Timeout:                        fmt.Sprintf("%ds", s.options.TimeOut),        // 超时时间
 		Interval:                       fmt.Sprintf("%ds", s.options.IntervalTime),   // 健康检查间隔
 		DeregisterCriticalServiceAfter: fmt.Sprintf("%ds", s.options.DeregisterTime), //check失败后多少秒删除本服务，注销时间，相当于过期时间
@@ -19,27 +34,161 @@ func (s *Client) Register() error {
 		s.options.CheckPath = fmt.Sprintf("%s:%d", s.options.CheckAddr, s.options.CheckPort)
 		check.TCP = s.options.CheckPath
 	case "GRPC":
-		check.HTTP = s.options.CheckPath
+		// 原生 gRPC 检查，调用 grpc.health.v1.Health/Check
+		if len(s.options.CheckPath) == 0 {
+			s.options.CheckPath = fmt.Sprintf("%s:%d", s.options.CheckAddr, s.options.CheckPort)
+		}
+		check.GRPC = s.options.CheckPath
+	case "TTL":
+		// TTL 检查由服务上报状态，不需要检查间隔和超时
+		ttl := s.options.TTL
+		if ttl <= 0 {
+			// WithCheckType("TTL") 未设置 TTL
+			ttl = s.options.IntervalTime
+		}
+		check.Timeout, check.Interval = "", ""
+		check.TTL = fmt.Sprintf("%ds", ttl)
+	}
+
+	var checks consulApi.AgentServiceChecks
+	for i, c := range s.options.Checks {
+		checks = append(checks, s.agentCheck(i, c))
 	}
 
+	address, port := s.options.Address()
 	svcReg := &consulApi.AgentServiceRegistration{
 		ID:                s.options.Id,
 		Name:              s.options.Name,
 		Tags:              s.options.Tags,
-		Port:              s.options.CheckPort,
-		Address:           s.options.CheckAddr,
+		Port:              port,
+		Address:           address,
+		Meta:              s.options.Metadata,
 		EnableTagOverride: true,
 		Check:             check,
-		Checks:            nil,
+		Checks:            checks,
 	}
-	err := s.client.Agent().ServiceRegister(svcReg)
-	if err != nil {
-		return errors.Wrap(err, "register service error")
+	if s.options.Weights != nil {
+		svcReg.Weights = &consulApi.AgentWeights{Passing: s.options.Weights.Passing, Warning: s.options.Weights.Warning}
+	}
+	return svcReg
+}
+
+func (s *Client) agentCheck(i int, c *discovery2.Check) *consulApi.AgentServiceCheck {
+	id := c.Id
+	if len(id) == 0 {
+		name := c.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("%d", i+1)
+		}
+		id = fmt.Sprintf("service:%s:%s", s.options.Id, name)
+	}
+	interval, timeout := c.Interval, c.Timeout
+	if interval <= 0 {
+		interval = s.options.IntervalTime
+	}
+	if timeout <= 0 {
+		timeout = s.options.TimeOut
+	}
+	check := &consulApi.AgentServiceCheck{
+		CheckID:                        id,
+		Name:                           c.Name,
+		Notes:                          c.Notes,
+		Timeout:                        fmt.Sprintf("%ds", timeout),
+		Interval:                       fmt.Sprintf("%ds", interval),
+		DeregisterCriticalServiceAfter: fmt.Sprintf("%ds", s.options.DeregisterTime),
+	}
+	switch c.Type {
+	case "HTTP":
+		check.HTTP = c.Target
+	case "TCP":
+		check.TCP = c.Target
+	case "GRPC":
+		check.GRPC = c.Target
+	case "TTL":
+		ttl := c.TTL
+		if ttl <= 0 {
+			ttl = s.options.IntervalTime
+		}
+		check.Timeout, check.Interval = "", ""
+		check.TTL = fmt.Sprintf("%ds", ttl)
+	}
+	return check
+}
+
+// startTTL 启动 TTL 上报协程，每隔最短 TTL 的一半上报一次，注册后立即上报
+func (s *Client) startTTL(svcReg *consulApi.AgentServiceRegistration) {
+	s.stopTTL()
+	var ids []string
+	var interval time.Duration
+	for _, check := range append(consulApi.AgentServiceChecks{svcReg.Check}, svcReg.Checks...) {
+		if check == nil || len(check.TTL) == 0 {
+			continue
+		}
+		ttl, err := time.ParseDuration(check.TTL)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, check.CheckID)
+		if interval == 0 || ttl/2 < interval {
+			interval = ttl / 2
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.locker.Lock()
+	s.ttlCancel, s.ttlDone = cancel, done
+	s.locker.Unlock()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			status, output := s.ttlStatus()
+			for _, id := range ids {
+				// 上报失败时等待下次上报，超过 TTL 后 consul 标记为 critical
+				_ = s.client.Agent().UpdateTTLOpts(id, output, status, (&consulApi.QueryOptions{}).WithContext(ctx))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ttlStatus 开启服务自检时按自检结果上报，否则上报 passing
+func (s *Client) ttlStatus() (string, string) {
+	if m := s.options.Health; m != nil {
+		if m.Liveness() == health.StatusDown {
+			return consulApi.HealthCritical, "liveness down"
+		}
+		if m.Readiness() == health.StatusDown {
+			return consulApi.HealthCritical, "readiness down"
+		}
+	}
+	return consulApi.HealthPassing, "ok"
+}
+
+func (s *Client) stopTTL() {
+	s.locker.Lock()
+	cancel, done := s.ttlCancel, s.ttlDone
+	s.ttlCancel, s.ttlDone = nil, nil
+	s.locker.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
 	}
-	return nil
 }
 
 func (s *Client) Deregister() error {
+	s.stopTTL()
 	err := s.client.Agent().ServiceDeregister(s.options.Id)
 	if err != nil {
 		return errors.Wrapf(err, "deregister service error[key=%s]", s.options.Id)
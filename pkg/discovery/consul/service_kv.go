@@ -1,8 +1,11 @@
 package consul
 
 import (
+	"context"
+	"time"
+
+	discovery2 "github.com/donetkit/contrib/pkg/discovery"
 	consulApi "github.com/hashicorp/consul/api"
-	"github.com/pkg/errors"
 )
 
 func (s *Client) Get(key string) ([]byte, error) {
@@ -11,7 +14,7 @@ func (s *Client) Get(key string) ([]byte, error) {
 		return nil, err
 	}
 	if kv == nil {
-		return nil, errors.New("not found value")
+		return nil, discovery2.ErrNotFound
 	}
 	return kv.Value, nil
 }
@@ -37,12 +40,48 @@ func (s *Client) List(key string) (map[string][]byte, error) {
 		return nil, err
 	}
 	if p == nil {
-		return nil, errors.New("not found value")
+		return nil, discovery2.ErrNotFound
+	}
+	return kvValues(p), nil
+
+}
+
+// WatchList 使用 consul 阻塞查询监听前缀下的键值，请求失败时 1 秒后重试
+func (s *Client) WatchList(ctx context.Context, key string, fn func(values map[string][]byte)) error {
+	var index uint64
+	first := true
+	for {
+		opts := (&consulApi.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Minute}).WithContext(ctx)
+		p, meta, err := s.client.KV().List(key, opts)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		// 等待超时时索引不变；索引变小时说明 consul 重建了数据，从头查询
+		if !first && meta.LastIndex == index {
+			continue
+		}
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+		first = false
+		fn(kvValues(p))
 	}
+}
+
+func kvValues(p consulApi.KVPairs) map[string][]byte {
 	values := make(map[string][]byte, len(p))
 	for _, v := range p {
 		values[v.Key] = v.Value
 	}
-	return values, nil
-
+	return values
 }
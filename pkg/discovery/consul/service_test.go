@@ -0,0 +1,300 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/health"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// agent records the requests of a fake consul agent
+type agent struct {
+	locker   sync.Mutex
+	register *api.AgentServiceRegistration
+	updates  map[string][]string
+	// 依次记录的 register、deregister 调用
+	calls []string
+}
+
+func newAgent(t *testing.T) (*agent, []discovery.Option) {
+	a := &agent{updates: make(map[string][]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.locker.Lock()
+		defer a.locker.Unlock()
+		switch {
+		case r.URL.Path == "/v1/agent/service/register":
+			a.register = &api.AgentServiceRegistration{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(a.register))
+			a.calls = append(a.calls, "register")
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+			a.calls = append(a.calls, "deregister")
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/"):
+			var update struct{ Status string }
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&update))
+			id := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/update/")
+			a.updates[id] = append(a.updates[id], update.Status)
+		}
+	}))
+	t.Cleanup(server.Close)
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return a, []discovery.Option{discovery.WithRegisterAddr(host), discovery.WithRegisterPort(p)}
+}
+
+// WithCheckGrpc 的参数仍是交给 router 的路径，不作为 gRPC 服务名
+func TestCheckGrpcPath(t *testing.T) {
+	var url string
+	client, err := New(
+		discovery.WithCheckAddr("10.0.0.1"),
+		discovery.WithCheckPort(9000),
+		discovery.WithCheckGrpc(func(r *discovery.CheckResponse) { url = r.Url }, "/health/svc.health"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/health/svc.health", url)
+	assert.Equal(t, "GRPC", client.options.CheckType)
+	assert.Equal(t, "10.0.0.1:9000", client.options.CheckPath)
+}
+
+func TestRegister(t *testing.T) {
+	a, opts := newAgent(t)
+	client, err := New(append(opts,
+		discovery.WithId("svc-1"),
+		discovery.WithName("svc"),
+		discovery.WithCheckAddr("10.0.0.1"),
+		discovery.WithCheckPort(9000),
+		discovery.WithServiceAddr("192.168.0.1"),
+		discovery.WithServicePort(8080),
+		discovery.WithMetadata(map[string]string{"zone": "a"}),
+		discovery.WithMetadata(map[string]string{"version": "v2"}),
+		discovery.WithWeights(10, 1),
+		discovery.WithCheckGrpcService("demo.Echo"),
+		discovery.WithChecks(
+			&discovery.Check{Name: "db", Type: "TCP", Target: "10.0.0.2:3306"},
+			&discovery.Check{Id: "heartbeat", Type: "TTL", TTL: 2},
+		))...)
+	assert.Nil(t, err)
+	assert.Nil(t, client.Register())
+	defer client.stopTTL()
+
+	a.locker.Lock()
+	reg := a.register
+	a.locker.Unlock()
+	assert.Equal(t, "192.168.0.1", reg.Address)
+	assert.Equal(t, 8080, reg.Port)
+	assert.Equal(t, map[string]string{"zone": "a", "version": "v2"}, reg.Meta)
+	assert.Equal(t, &api.AgentWeights{Passing: 10, Warning: 1}, reg.Weights)
+	assert.Equal(t, "service:svc-1", reg.Check.CheckID)
+	assert.Equal(t, "10.0.0.1:9000/demo.Echo", reg.Check.GRPC)
+	assert.Len(t, reg.Checks, 2)
+	assert.Equal(t, "service:svc-1:db", reg.Checks[0].CheckID)
+	assert.Equal(t, "10.0.0.2:3306", reg.Checks[0].TCP)
+	assert.Equal(t, "heartbeat", reg.Checks[1].CheckID)
+	assert.Equal(t, "2s", reg.Checks[1].TTL)
+	assert.Empty(t, reg.Checks[1].Interval)
+
+	// the ttl check is updated at once and every second
+	assert.Eventually(t, func() bool {
+		a.locker.Lock()
+		defer a.locker.Unlock()
+		return len(a.updates["heartbeat"]) >= 2
+	}, 3*time.Second, 10*time.Millisecond)
+	a.locker.Lock()
+	assert.Equal(t, api.HealthPassing, a.updates["heartbeat"][0])
+	a.locker.Unlock()
+
+	assert.Nil(t, client.Deregister())
+	a.locker.Lock()
+	n := len(a.updates["heartbeat"])
+	a.locker.Unlock()
+	time.Sleep(1100 * time.Millisecond)
+	a.locker.Lock()
+	assert.Equal(t, n, len(a.updates["heartbeat"]))
+	a.locker.Unlock()
+}
+
+func TestHealthHTTP(t *testing.T) {
+	a, opts := newAgent(t)
+	var down atomic.Bool
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer service.Close()
+	host, port, _ := net.SplitHostPort(service.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	// 不在后台执行，由测试调用 Check
+	manager := health.New(health.WithInitialDelay(time.Hour), health.WithFailureThreshold(1))
+	client, err := New(append(opts,
+		discovery.WithId("svc-1"),
+		discovery.WithCheckAddr(host),
+		discovery.WithCheckPort(p),
+		discovery.WithCheckHTTP(func(r *discovery.CheckResponse) {}, "/health"),
+		discovery.WithHealthManager(manager),
+		discovery.WithEnableHealthyStatus())...)
+	assert.Nil(t, err)
+	defer manager.Stop()
+	assert.Nil(t, client.Register())
+	calls := func() []string {
+		a.locker.Lock()
+		defer a.locker.Unlock()
+		return append([]string(nil), a.calls...)
+	}
+
+	ctx := context.Background()
+	manager.Check(ctx)
+	assert.Equal(t, health.StatusUp, manager.Liveness())
+	assert.Equal(t, []string{"register"}, calls())
+
+	// 服务不可用时注销
+	down.Store(true)
+	manager.Check(ctx)
+	assert.Equal(t, health.StatusDown, manager.Liveness())
+	assert.Equal(t, []string{"register", "deregister"}, calls())
+
+	// 注销后 consul 不再检查，自检仍能发现恢复并重新注册
+	down.Store(false)
+	manager.Check(ctx)
+	assert.Equal(t, health.StatusUp, manager.Liveness())
+	assert.Equal(t, []string{"register", "deregister", "register"}, calls())
+}
+
+func TestRegisterDefaults(t *testing.T) {
+	_, opts := newAgent(t)
+	client, err := New(append(opts, discovery.WithCheckAddr("10.0.0.1"), discovery.WithCheckPort(9000), discovery.WithCheckTTL(10))...)
+	assert.Nil(t, err)
+	reg := client.registration()
+	assert.Equal(t, "10.0.0.1", reg.Address)
+	assert.Equal(t, 9000, reg.Port)
+	assert.Equal(t, "10s", reg.Check.TTL)
+	assert.Nil(t, reg.Weights)
+	assert.Nil(t, reg.Checks)
+
+	// 未设置 TTL 时使用检查间隔
+	client, err = New(append(opts, discovery.WithCheckType("TTL"))...)
+	assert.Nil(t, err)
+	reg = client.registration()
+	assert.Equal(t, "15s", reg.Check.TTL)
+	assert.Empty(t, reg.Check.Interval)
+}
+
+func TestServiceInstance(t *testing.T) {
+	entry := &api.ServiceEntry{
+		Node: &api.Node{Address: "10.0.0.1", Datacenter: "dc1"},
+		Service: &api.AgentService{
+			ID:      "svc-1",
+			Service: "svc",
+			Port:    8080,
+			Tags:    []string{"v1"},
+			Meta:    map[string]string{"zone": "a"},
+			Weights: api.AgentWeights{Passing: 10, Warning: 1},
+		},
+		Checks: api.HealthChecks{{Status: api.HealthPassing}},
+	}
+	instance, ok := serviceInstance(entry)
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1", instance.Host)
+	assert.Equal(t, "dc1", instance.ClusterName)
+	assert.Equal(t, float64(10), instance.Weight)
+	assert.Equal(t, map[string]string{"zone": "a"}, instance.Metadata)
+
+	entry.Service.Address = "192.168.0.1"
+	entry.Checks = append(entry.Checks, &api.HealthCheck{Status: api.HealthWarning})
+	instance, ok = serviceInstance(entry)
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.0.1", instance.Host)
+	assert.Equal(t, float64(1), instance.Weight)
+
+	// 警告状态的权重为 0 时过滤
+	entry.Service.Weights.Warning = 0
+	_, ok = serviceInstance(entry)
+	assert.False(t, ok)
+
+	// 未设置权重时为 1
+	entry.Service.Weights = api.AgentWeights{}
+	instance, ok = serviceInstance(entry)
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), instance.Weight)
+
+	entry.Checks = append(entry.Checks, &api.HealthCheck{Status: api.HealthCritical})
+	_, ok = serviceInstance(entry)
+	assert.False(t, ok)
+}
+
+// kvAgent is a fake consul agent serving blocking queries of one key
+type kvAgent struct {
+	locker  sync.Mutex
+	changed chan struct{}
+	index   uint64
+	value   string
+}
+
+func (a *kvAgent) set(value string) {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	a.index++
+	a.value = value
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+func (a *kvAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.locker.Lock()
+	if r.URL.Query().Get("index") == strconv.FormatUint(a.index, 10) {
+		changed := a.changed
+		a.locker.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(100 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		a.locker.Lock()
+	}
+	defer a.locker.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(a.index, 10))
+	if len(a.value) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(api.KVPairs{{Key: "app/port", Value: []byte(a.value)}})
+}
+
+func TestWatchList(t *testing.T) {
+	a := &kvAgent{changed: make(chan struct{}), index: 1}
+	server := httptest.NewServer(a)
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client, err := New(discovery.WithRegisterAddr(host), discovery.WithRegisterPort(p))
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	values := make(chan map[string][]byte, 10)
+	done := make(chan error)
+	go func() {
+		done <- client.WatchList(ctx, "app/", func(v map[string][]byte) { values <- v })
+	}()
+	assert.Equal(t, map[string][]byte{}, <-values)
+
+	// wait timeouts with the same index do not call fn
+	time.Sleep(250 * time.Millisecond)
+	assert.Len(t, values, 0)
+	a.set("80")
+	assert.Equal(t, map[string][]byte{"app/port": []byte("80")}, <-values)
+	a.set("81")
+	assert.Equal(t, map[string][]byte{"app/port": []byte("81")}, <-values)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
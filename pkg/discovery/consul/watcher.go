@@ -20,6 +20,11 @@ type Watcher struct {
 	services map[string][]*servicediscovery.Service
 }
 
+// Watch 监听服务变化，WatchService 指定服务，否则监听全部服务
+func (s *Client) Watch(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+	return newWatcher(s.client, opts...)
+}
+
 func newWatcher(client *api.Client, opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
 	var wo servicediscovery.WatchOptions
 	for _, o := range opts {
@@ -157,17 +162,8 @@ func (cw *Watcher) serviceHandler(idx uint64, data interface{}) {
 	for _, e := range entries {
 
 		serviceName = e.Service.Service
-		// service ID is now the node id
-		id := e.Service.ID
 		key := e.Service.Service
 
-		address := e.Service.Address
-
-		// use node address
-		if len(address) == 0 {
-			address = e.Node.Address
-		}
-
 		svc, ok := serviceMap[key]
 		if !ok {
 			svc = &servicediscovery.Service{
@@ -176,32 +172,12 @@ func (cw *Watcher) serviceHandler(idx uint64, data interface{}) {
 			serviceMap[key] = svc
 		}
 
-		var del bool
-
-		for _, check := range e.Checks {
-			// delete the node if the status is critical
-			if check.Status == "critical" {
-				del = true
-				break
-			}
-		}
-
-		// if delete then skip the node
-		if del {
+		// if critical then skip the node
+		instance, ok := serviceInstance(e)
+		if !ok {
 			continue
 		}
-
-		svc.Nodes = append(svc.Nodes, &servicediscovery.DefaultServiceInstance{
-			Id:          id,
-			ServiceName: serviceName,
-			Host:        address,
-			Port:        uint64(e.Service.Port),
-			ClusterName: "",
-			Enable:      true,
-			Weight:      10,
-			Healthy:     true,
-			Metadata:    nil,
-		})
+		svc.Nodes = append(svc.Nodes, instance)
 	}
 
 	cw.locker.RLock()
@@ -282,6 +258,52 @@ func (cw *Watcher) serviceHandler(idx uint64, data interface{}) {
 	cw.locker.Unlock()
 }
 
+// serviceInstance converts a service entry, false when a check is critical.
+// The weight is Weights.Warning when a check is warning, Weights.Passing otherwise
+func serviceInstance(e *api.ServiceEntry) (*servicediscovery.DefaultServiceInstance, bool) {
+	var warning bool
+	for _, check := range e.Checks {
+		switch check.Status {
+		case api.HealthCritical:
+			return nil, false
+		case api.HealthWarning:
+			warning = true
+		}
+	}
+
+	address := e.Service.Address
+	// use node address
+	if len(address) == 0 {
+		address = e.Node.Address
+	}
+
+	weight := e.Service.Weights.Passing
+	if warning {
+		weight = e.Service.Weights.Warning
+	}
+	if e.Service.Weights.Passing == 0 && e.Service.Weights.Warning == 0 {
+		// 未设置权重，与 consul 的默认权重相同
+		weight = 1
+	} else if weight <= 0 {
+		// 权重为 0 的实例不参与负载均衡
+		return nil, false
+	}
+
+	return &servicediscovery.DefaultServiceInstance{
+		// service ID is now the node id
+		Id:          e.Service.ID,
+		ServiceName: e.Service.Service,
+		Host:        address,
+		Port:        uint64(e.Service.Port),
+		ClusterName: e.Node.Datacenter,
+		Tags:        e.Service.Tags,
+		Enable:      true,
+		Weight:      float64(weight),
+		Healthy:     true,
+		Metadata:    e.Service.Meta,
+	}, true
+}
+
 func CopyService(service *servicediscovery.Service) *servicediscovery.Service {
 	// copy service
 	s := new(servicediscovery.Service)
@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Resolver 域名解析，默认 net.DefaultResolver
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Option for dns discovery
+type Option func(*Client)
+
+// WithResolver set resolver function
+func WithResolver(resolver Resolver) Option {
+	return func(c *Client) {
+		c.resolver = resolver
+	}
+}
+
+// WithInterval set the interval of resolving, default 10 seconds
+func WithInterval(interval time.Duration) Option {
+	return func(c *Client) {
+		if interval > 0 {
+			c.interval = interval
+		}
+	}
+}
+
+// WithDomain set the domain appended to service names, e.g. service.consul or svc.cluster.local
+func WithDomain(domain string) Option {
+	return func(c *Client) {
+		c.domain = domain
+	}
+}
+
+// WithProto set the SRV proto, default tcp
+func WithProto(proto string) Option {
+	return func(c *Client) {
+		c.proto = proto
+	}
+}
+
+// WithPort set the port of instances resolved from A/AAAA records, default 80
+func WithPort(port uint64) Option {
+	return func(c *Client) {
+		c.port = port
+	}
+}
+
+// WithTimeout set the timeout of one resolving, default 3 seconds
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package dns 基于 DNS 的服务发现，定时解析服务名的 SRV 记录，没有 SRV 记录时解析 A/AAAA 记录，
+// 服务由 DNS 管理，Register、Deregister 不做任何操作
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+)
+
+// ErrServiceRequired DNS 不能列出全部服务，Watch 必须指定服务
+var ErrServiceRequired = errors.New("dns discovery: watch requires a service name")
+
+type Client struct {
+	resolver Resolver
+	interval time.Duration
+	timeout  time.Duration
+	domain   string
+	proto    string
+	port     uint64
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{
+		resolver: net.DefaultResolver,
+		interval: 10 * time.Second,
+		timeout:  3 * time.Second,
+		proto:    "tcp",
+		port:     80,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Register 服务由 DNS 管理，不做任何操作
+func (c *Client) Register() error {
+	return nil
+}
+
+// Deregister 服务由 DNS 管理，不做任何操作
+func (c *Client) Deregister() error {
+	return nil
+}
+
+func (c *Client) host(service string) string {
+	if len(c.domain) == 0 {
+		return service
+	}
+	return service + "." + strings.TrimPrefix(c.domain, ".")
+}
+
+// Resolve 解析服务实例，优先 SRV 记录，只保留优先级最高(Priority 最小)的记录
+func (c *Client) Resolve(ctx context.Context, service string) ([]servicediscovery.ServiceInstance, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	var records []*net.SRV
+	var err error
+	if len(c.domain) == 0 {
+		// 未设置域名时服务名即 SRV 记录名，如 _http._tcp.example.com
+		_, records, err = c.resolver.LookupSRV(ctx, "", "", service)
+	} else {
+		_, records, err = c.resolver.LookupSRV(ctx, service, c.proto, strings.TrimPrefix(c.domain, "."))
+	}
+	if err == nil && len(records) > 0 {
+		return srvInstances(service, records), nil
+	}
+	if err != nil && !notFound(err) {
+		return nil, err
+	}
+	hosts, err := c.resolver.LookupHost(ctx, c.host(service))
+	if err != nil {
+		if notFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	instances := make([]servicediscovery.ServiceInstance, 0, len(hosts))
+	for _, host := range hosts {
+		instances = append(instances, instance(service, host, c.port, 1))
+	}
+	return instances, nil
+}
+
+func srvInstances(service string, records []*net.SRV) []servicediscovery.ServiceInstance {
+	priority := records[0].Priority
+	for _, record := range records {
+		if record.Priority < priority {
+			priority = record.Priority
+		}
+	}
+	var instances []servicediscovery.ServiceInstance
+	for _, record := range records {
+		if record.Priority != priority {
+			continue
+		}
+		weight := float64(record.Weight)
+		if weight == 0 {
+			weight = 1
+		}
+		instances = append(instances, instance(service, strings.TrimSuffix(record.Target, "."), uint64(record.Port), weight))
+	}
+	return instances
+}
+
+func instance(service, host string, port uint64, weight float64) servicediscovery.ServiceInstance {
+	return &servicediscovery.DefaultServiceInstance{
+		Id:          net.JoinHostPort(host, strconv.FormatUint(port, 10)),
+		ServiceName: service,
+		Host:        host,
+		Port:        port,
+		Enable:      true,
+		Healthy:     true,
+		Weight:      weight,
+	}
+}
+
+func notFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// Watch 定时解析 WatchService 指定的服务，解析失败时保留上次的结果，域名不存在时删除服务
+func (c *Client) Watch(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+	var wo servicediscovery.WatchOptions
+	for _, o := range opts {
+		o(&wo)
+	}
+	if len(wo.Service) == 0 {
+		return nil, ErrServiceRequired
+	}
+	service := wo.Service
+	return servicediscovery.NewPollWatcher("dns", c.interval, func(ctx context.Context) (servicediscovery.Snapshot, error) {
+		instances, err := c.Resolve(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		if len(instances) == 0 {
+			return servicediscovery.Snapshot{}, nil
+		}
+		return servicediscovery.Snapshot{service: instances}, nil
+	}, opts...), nil
+}
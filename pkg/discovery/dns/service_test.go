@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeResolver struct {
+	locker sync.Mutex
+	srv    map[string][]*net.SRV
+	hosts  map[string][]string
+	err    error
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	target := "_" + service + "._" + proto + "." + name
+	if records, ok := r.srv[target]; ok {
+		return target, records, nil
+	}
+	return "", nil, &net.DNSError{Err: "no such host", Name: target, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if hosts, ok := r.hosts[host]; ok {
+		return hosts, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *fakeResolver) set(fn func(r *fakeResolver)) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	fn(r)
+}
+
+func next(t *testing.T, watcher servicediscovery.Watcher) *servicediscovery.Result {
+	results := make(chan *servicediscovery.Result, 1)
+	go func() {
+		result, err := watcher.Next()
+		assert.Nil(t, err)
+		results <- result
+	}()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(3 * time.Second):
+		t.Fatal("no watch result")
+		return nil
+	}
+}
+
+func TestResolve(t *testing.T) {
+	resolver := &fakeResolver{
+		srv: map[string][]*net.SRV{"_api._tcp.example.com": {
+			{Target: "a.example.com.", Port: 8080, Priority: 10, Weight: 30},
+			{Target: "b.example.com.", Port: 8080, Priority: 10},
+			{Target: "backup.example.com.", Port: 8080, Priority: 20, Weight: 10},
+		}},
+		hosts: map[string][]string{"web.example.com": {"10.0.0.1", "10.0.0.2"}},
+	}
+	client := New(WithResolver(resolver), WithDomain("example.com"), WithPort(8000))
+
+	instances, err := client.Resolve(context.Background(), "api")
+	assert.Nil(t, err)
+	assert.Len(t, instances, 2)
+	assert.Equal(t, "a.example.com:8080", instances[0].GetId())
+	assert.Equal(t, float64(30), instances[0].GetWeight())
+	assert.Equal(t, float64(1), instances[1].GetWeight())
+
+	instances, err = client.Resolve(context.Background(), "web")
+	assert.Nil(t, err)
+	assert.Len(t, instances, 2)
+	assert.Equal(t, "10.0.0.1", instances[0].GetHost())
+	assert.Equal(t, uint64(8000), instances[0].GetPort())
+
+	instances, err = client.Resolve(context.Background(), "missing")
+	assert.Nil(t, err)
+	assert.Empty(t, instances)
+}
+
+func TestWatch(t *testing.T) {
+	resolver := &fakeResolver{hosts: map[string][]string{"web.example.com": {"10.0.0.1"}}}
+	client := New(WithResolver(resolver), WithDomain("example.com"), WithInterval(10*time.Millisecond))
+
+	_, err := client.Watch()
+	assert.Equal(t, ErrServiceRequired, err)
+
+	watcher, err := client.Watch(servicediscovery.WatchService("web"))
+	assert.Nil(t, err)
+	defer watcher.Stop()
+
+	result := next(t, watcher)
+	assert.Equal(t, "create", result.Action)
+	assert.Len(t, result.Service.Nodes, 1)
+
+	// failures keep the instances
+	resolver.set(func(r *fakeResolver) { r.err = errors.New("timeout") })
+	time.Sleep(50 * time.Millisecond)
+	resolver.set(func(r *fakeResolver) {
+		r.err = nil
+		r.hosts["web.example.com"] = []string{"10.0.0.1", "10.0.0.2"}
+	})
+	result = next(t, watcher)
+	assert.Equal(t, "create", result.Action)
+	assert.Len(t, result.Service.Nodes, 2)
+
+	// one delete for each instance
+	resolver.set(func(r *fakeResolver) { delete(r.hosts, "web.example.com") })
+	for i := 0; i < 2; i++ {
+		result = next(t, watcher)
+		assert.Equal(t, "delete", result.Action)
+		assert.Equal(t, "web", result.Service.Name)
+		assert.Len(t, result.Service.Nodes, 1)
+	}
+}
@@ -1 +1,94 @@
 package etcd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	discovery2 "github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/utils/host"
+	"github.com/donetkit/contrib/utils/uuid"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Prefix 服务注册的 key 前缀，实例保存在 Prefix + 服务名 + "/" + 实例ID
+const Prefix = "/services/"
+
+type Client struct {
+	client  *clientv3.Client
+	options *discovery2.Config
+
+	locker  sync.Mutex
+	leaseId clientv3.LeaseID
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// New 创建 etcd 客户端，RegisterAddr:RegisterPort 为 etcd 地址，CheckAddr:CheckPort 为注册的服务地址，
+// DeregisterTime 为租约TTL，服务异常退出后超过TTL自动注销
+func New(opts ...discovery2.Option) (*Client, error) {
+	cfg := &discovery2.Config{
+		Id:             uuid.NewUUID(),
+		Name:           "Service",
+		RegisterAddr:   "127.0.0.1",
+		RegisterPort:   2379,
+		CheckAddr:      host.GetOutBoundIp(),
+		CheckPort:      80,
+		Tags:           []string{"v0.0.1"},
+		IntervalTime:   15,
+		DeregisterTime: 15,
+		TimeOut:        3,
+		CheckResponse:  &discovery2.CheckResponse{RetryCount: 3},
+		CheckType:      "TCP",
+	}
+	cfg.CheckResponse.SetHealthy("Healthy")
+	cfg.HttpRouter = func(r *discovery2.CheckResponse) {}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	etcdCli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{fmt.Sprintf("%s:%d", cfg.RegisterAddr, cfg.RegisterPort)},
+		DialTimeout: time.Duration(cfg.TimeOut) * time.Second,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create etcd client error")
+	}
+	return &Client{
+		options: cfg,
+		client:  etcdCli,
+	}, nil
+}
+
+// SetTags set tags []string
+func (s *Client) SetTags(tags ...string) {
+	s.options.Tags = tags
+}
+
+// Close 关闭 etcd 连接，未注销的服务在租约过期后删除
+func (s *Client) Close() error {
+	s.stopKeepAlive()
+	return s.client.Close()
+}
+
+func (s *Client) timeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(s.options.TimeOut)*time.Second)
+}
+
+func serviceKey(name, id string) string {
+	return Prefix + name + "/" + id
+}
+
+// parseKey 解析服务 key 中的服务名和实例ID
+func parseKey(key string) (name string, id string, ok bool) {
+	if !strings.HasPrefix(key, Prefix) {
+		return "", "", false
+	}
+	name, id, ok = strings.Cut(strings.TrimPrefix(key, Prefix), "/")
+	if !ok || len(name) == 0 || len(id) == 0 {
+		return "", "", false
+	}
+	return name, id, true
+}
@@ -0,0 +1,110 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Register 以租约注册服务并保持续约，租约丢失(如 etcd 长时间不可用)后重新注册
+func (s *Client) Register() error {
+	leaseId, err := s.register()
+	if err != nil {
+		return err
+	}
+	s.stopKeepAlive()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.locker.Lock()
+	s.leaseId, s.cancel, s.done = leaseId, cancel, done
+	s.locker.Unlock()
+	go s.keepAlive(ctx, leaseId, done)
+	return nil
+}
+
+func (s *Client) register() (clientv3.LeaseID, error) {
+	address, port := s.options.Address()
+	value, err := json.Marshal(&servicediscovery.DefaultServiceInstance{
+		Id:          s.options.Id,
+		ServiceName: s.options.Name,
+		Host:        address,
+		Port:        uint64(port),
+		Tags:        s.options.Tags,
+		Enable:      true,
+		Healthy:     true,
+		Weight:      s.options.Weight(),
+		Metadata:    s.options.Metadata,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "register service error")
+	}
+	ctx, cancel := s.timeout()
+	defer cancel()
+	lease, err := s.client.Grant(ctx, int64(s.options.DeregisterTime))
+	if err != nil {
+		return 0, errors.Wrap(err, "register service error")
+	}
+	if _, err = s.client.Put(ctx, serviceKey(s.options.Name, s.options.Id), string(value), clientv3.WithLease(lease.ID)); err != nil {
+		return 0, errors.Wrap(err, "register service error")
+	}
+	return lease.ID, nil
+}
+
+func (s *Client) keepAlive(ctx context.Context, leaseId clientv3.LeaseID, done chan struct{}) {
+	defer close(done)
+	for {
+		ch, err := s.client.KeepAlive(ctx, leaseId)
+		if err == nil {
+			for range ch {
+			}
+		}
+		// 续约结束: 注销、关闭或租约已过期
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			if leaseId, err = s.register(); err == nil {
+				s.locker.Lock()
+				s.leaseId = leaseId
+				s.locker.Unlock()
+				break
+			}
+		}
+	}
+}
+
+func (s *Client) stopKeepAlive() {
+	s.locker.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.locker.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (s *Client) Deregister() error {
+	s.stopKeepAlive()
+	ctx, cancel := s.timeout()
+	defer cancel()
+	if _, err := s.client.Delete(ctx, serviceKey(s.options.Name, s.options.Id)); err != nil {
+		return errors.Wrapf(err, "deregister service error[key=%s]", s.options.Id)
+	}
+	s.locker.Lock()
+	leaseId := s.leaseId
+	s.leaseId = 0
+	s.locker.Unlock()
+	if leaseId != 0 {
+		if _, err := s.client.Revoke(ctx, leaseId); err != nil {
+			return errors.Wrapf(err, "deregister service error[key=%s]", s.options.Id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package etcd
+
+import (
+	"context"
+	"time"
+
+	discovery2 "github.com/donetkit/contrib/pkg/discovery"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func (s *Client) Get(key string) ([]byte, error) {
+	ctx, cancel := s.timeout()
+	defer cancel()
+	resp, err := s.client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, discovery2.ErrNotFound
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (s *Client) Set(key string, value string) error {
+	ctx, cancel := s.timeout()
+	defer cancel()
+	if _, err := s.client.Put(ctx, key, value); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Client) Delete(key string) error {
+	ctx, cancel := s.timeout()
+	defer cancel()
+	if _, err := s.client.Delete(ctx, key); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Client) List(key string) (map[string][]byte, error) {
+	ctx, cancel := s.timeout()
+	defer cancel()
+	resp, err := s.client.Get(ctx, key, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, discovery2.ErrNotFound
+	}
+	values := make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		values[string(kv.Key)] = kv.Value
+	}
+	return values, nil
+}
+
+// WatchList 全量读取前缀下的键值后从下一版本开始监听，连接中断或历史版本已压缩时 1 秒后重新全量读取
+func (s *Client) WatchList(ctx context.Context, key string, fn func(values map[string][]byte)) error {
+	for {
+		resp, err := s.client.Get(ctx, key, clientv3.WithPrefix())
+		if err == nil {
+			values := make(map[string][]byte, len(resp.Kvs))
+			for _, kv := range resp.Kvs {
+				values[string(kv.Key)] = kv.Value
+			}
+			fn(copyValues(values))
+			ch := s.client.Watch(clientv3.WithRequireLeader(ctx), key, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+			for wr := range ch {
+				if wr.Err() != nil {
+					break
+				}
+				for _, ev := range wr.Events {
+					switch ev.Type {
+					case clientv3.EventTypePut:
+						values[string(ev.Kv.Key)] = ev.Kv.Value
+					case clientv3.EventTypeDelete:
+						delete(values, string(ev.Kv.Key))
+					}
+				}
+				if len(wr.Events) > 0 {
+					fn(copyValues(values))
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func copyValues(values map[string][]byte) map[string][]byte {
+	m := make(map[string][]byte, len(values))
+	for k, v := range values {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,192 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"github.com/stretchr/testify/assert"
+)
+
+// endpoint 测试使用的 etcd 地址
+var endpoint string
+
+// TestMain 未设置 ETCD_ENDPOINT 时在空闲端口启动 PATH 中的 etcd，无法启动时测试失败而不是跳过。
+// 内嵌的 go.etcd.io/etcd/server/v3/embed 依赖的 go.etcd.io/etcd/pkg/v3 等模块无法加入本模块的依赖，因此使用独立进程
+func TestMain(m *testing.M) {
+	endpoint = os.Getenv("ETCD_ENDPOINT")
+	var stop func()
+	if len(endpoint) == 0 {
+		var err error
+		endpoint, stop, err = startEtcd()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "start etcd:", err)
+			os.Exit(1)
+		}
+	}
+	code := m.Run()
+	if stop != nil {
+		stop()
+	}
+	os.Exit(code)
+}
+
+// startEtcd 在临时目录启动单节点 etcd
+func startEtcd() (string, func(), error) {
+	path, err := exec.LookPath("etcd")
+	if err != nil {
+		return "", nil, fmt.Errorf("ETCD_ENDPOINT not set and %w", err)
+	}
+	dir, err := os.MkdirTemp("", "etcd")
+	if err != nil {
+		return "", nil, err
+	}
+	clientUrl := "http://" + freeAddr()
+	peerUrl := "http://" + freeAddr()
+	cmd := exec.Command(path, "--data-dir", dir,
+		"--listen-client-urls", clientUrl, "--advertise-client-urls", clientUrl,
+		"--listen-peer-urls", peerUrl, "--initial-advertise-peer-urls", peerUrl,
+		"--initial-cluster", "default="+peerUrl)
+	if err = cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}
+	addr := strings.TrimPrefix(clientUrl, "http://")
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return addr, stop, nil
+		}
+	}
+	stop()
+	return "", nil, fmt.Errorf("etcd not listening on %s", addr)
+}
+
+// freeAddr 返回本机一个空闲端口的地址
+func freeAddr() string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// newClient 连接测试使用的 etcd
+func newClient(t *testing.T, opts ...discovery.Option) *Client {
+	host, port, err := net.SplitHostPort(endpoint)
+	assert.Nil(t, err)
+	p, _ := strconv.Atoi(port)
+	client, err := New(append([]discovery.Option{discovery.WithRegisterAddr(host), discovery.WithRegisterPort(p)}, opts...)...)
+	assert.Nil(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestParseKey(t *testing.T) {
+	name, id, ok := parseKey("/services/svc/1/2")
+	assert.True(t, ok)
+	assert.Equal(t, "svc", name)
+	assert.Equal(t, "1/2", id)
+
+	for _, key := range []string{"/services/svc", "/services//1", "/services/svc/", "/other/svc/1"} {
+		_, _, ok = parseKey(key)
+		assert.False(t, ok, key)
+	}
+}
+
+func TestKV(t *testing.T) {
+	client := newClient(t)
+	assert.Nil(t, client.Set("/test/kv/a", "1"))
+	assert.Nil(t, client.Set("/test/kv/b", "2"))
+	value, err := client.Get("/test/kv/a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(value))
+
+	values, err := client.List("/test/kv/")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"/test/kv/a": []byte("1"), "/test/kv/b": []byte("2")}, values)
+
+	assert.Nil(t, client.Delete("/test/kv/a"))
+	assert.Nil(t, client.Delete("/test/kv/b"))
+	_, err = client.Get("/test/kv/a")
+	assert.Equal(t, discovery.ErrNotFound, err)
+}
+
+func TestWatchList(t *testing.T) {
+	client := newClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	values := make(chan map[string][]byte, 10)
+	done := make(chan error)
+	go func() {
+		done <- client.WatchList(ctx, "/test/watch/", func(v map[string][]byte) { values <- v })
+	}()
+	assert.Equal(t, map[string][]byte{}, <-values)
+	assert.Nil(t, client.Set("/test/watch/a", "1"))
+	assert.Equal(t, map[string][]byte{"/test/watch/a": []byte("1")}, <-values)
+	assert.Nil(t, client.Delete("/test/watch/a"))
+	assert.Equal(t, map[string][]byte{}, <-values)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestRegisterWatch(t *testing.T) {
+	client := newClient(t, discovery.WithName("etcd-test"), discovery.WithId("1"),
+		discovery.WithCheckAddr("10.0.0.1"), discovery.WithCheckPort(8080), discovery.WithDeregisterTime(5),
+		discovery.WithServiceAddr("192.168.0.1"), discovery.WithServicePort(9000),
+		discovery.WithMetadata(map[string]string{"zone": "a"}), discovery.WithWeights(5, 1))
+	watcher, err := client.Watch(servicediscovery.WatchService("etcd-test"))
+	assert.Nil(t, err)
+	defer watcher.Stop()
+
+	assert.Nil(t, client.Register())
+	result := next(t, watcher)
+	assert.Equal(t, "etcd-test", result.Service.Name)
+	assert.Len(t, result.Service.Nodes, 1)
+	node := result.Service.Nodes[0]
+	assert.Equal(t, "1", node.GetId())
+	assert.Equal(t, "192.168.0.1", node.GetHost())
+	assert.Equal(t, uint64(9000), node.GetPort())
+	assert.Equal(t, float64(5), node.GetWeight())
+	assert.Equal(t, map[string]string{"zone": "a"}, node.GetMetadata())
+
+	// 超过租约TTL后仍在续约
+	time.Sleep(6 * time.Second)
+	_, err = client.Get(serviceKey("etcd-test", "1"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, client.Deregister())
+	result = next(t, watcher)
+	assert.Equal(t, "delete", result.Action)
+	assert.Empty(t, result.Service.Nodes)
+}
+
+func next(t *testing.T, watcher servicediscovery.Watcher) *servicediscovery.Result {
+	results := make(chan *servicediscovery.Result, 1)
+	go func() {
+		result, err := watcher.Next()
+		assert.Nil(t, err)
+		results <- result
+	}()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("no watch result")
+		return nil
+	}
+}
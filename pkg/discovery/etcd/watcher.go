@@ -0,0 +1,192 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type Watcher struct {
+	client *clientv3.Client
+	option servicediscovery.WatchOptions
+	prefix string
+	ctx    context.Context
+	cancel context.CancelFunc
+	exit   chan struct{}
+
+	next     chan *servicediscovery.Result
+	services map[string]map[string]servicediscovery.ServiceInstance
+}
+
+// Watch 监听服务变化，WatchService 指定服务，否则监听全部服务
+func (s *Client) Watch(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+	return newWatcher(s.client, opts...)
+}
+
+func newWatcher(client *clientv3.Client, opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+	var wo servicediscovery.WatchOptions
+	for _, o := range opts {
+		o(&wo)
+	}
+	parent := wo.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	ew := &Watcher{
+		client:   client,
+		option:   wo,
+		prefix:   Prefix,
+		ctx:      ctx,
+		cancel:   cancel,
+		exit:     make(chan struct{}),
+		next:     make(chan *servicediscovery.Result, 10),
+		services: make(map[string]map[string]servicediscovery.ServiceInstance),
+	}
+	if len(wo.Service) > 0 {
+		ew.prefix = Prefix + wo.Service + "/"
+	}
+	go ew.run()
+	return ew, nil
+}
+
+func (ew *Watcher) Next() (*servicediscovery.Result, error) {
+	select {
+	case <-ew.exit:
+		return nil, errors.New("watcher stopped")
+	case r := <-ew.next:
+		return r, nil
+	}
+}
+
+func (ew *Watcher) Stop() {
+	select {
+	case <-ew.exit:
+	default:
+		close(ew.exit)
+		ew.cancel()
+	}
+}
+
+func (ew *Watcher) run() {
+	for ew.ctx.Err() == nil {
+		if revision, err := ew.sync(); err == nil {
+			ew.watch(revision + 1)
+		}
+		// 连接中断或历史版本已压缩，稍后全量同步后继续监听
+		select {
+		case <-ew.ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// sync 全量读取服务，与已知的服务比较后发送变化
+func (ew *Watcher) sync() (int64, error) {
+	resp, err := ew.client.Get(ew.ctx, ew.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	services := make(map[string]map[string]servicediscovery.ServiceInstance)
+	for _, kv := range resp.Kvs {
+		name, id, ok := parseKey(string(kv.Key))
+		if !ok {
+			continue
+		}
+		instance, err := decodeInstance(name, id, kv.Value)
+		if err != nil {
+			continue
+		}
+		if services[name] == nil {
+			services[name] = make(map[string]servicediscovery.ServiceInstance)
+		}
+		services[name][id] = instance
+	}
+	for name := range ew.services {
+		if _, ok := services[name]; !ok {
+			delete(ew.services, name)
+			ew.send("delete", name)
+		}
+	}
+	for name, instances := range services {
+		action := "update"
+		if _, ok := ew.services[name]; !ok {
+			action = "create"
+		}
+		ew.services[name] = instances
+		ew.send(action, name)
+	}
+	return resp.Header.Revision, nil
+}
+
+// watch 从 revision 开始监听变化，直到连接中断或停止
+func (ew *Watcher) watch(revision int64) {
+	ch := ew.client.Watch(clientv3.WithRequireLeader(ew.ctx), ew.prefix, clientv3.WithPrefix(), clientv3.WithRev(revision))
+	for resp := range ch {
+		if resp.Err() != nil {
+			return
+		}
+		changed := make(map[string]bool)
+		for _, ev := range resp.Events {
+			name, id, ok := parseKey(string(ev.Kv.Key))
+			if !ok {
+				continue
+			}
+			switch ev.Type {
+			case clientv3.EventTypePut:
+				instance, err := decodeInstance(name, id, ev.Kv.Value)
+				if err != nil {
+					continue
+				}
+				if ew.services[name] == nil {
+					ew.services[name] = make(map[string]servicediscovery.ServiceInstance)
+				}
+				ew.services[name][id] = instance
+				changed[name] = true
+			case clientv3.EventTypeDelete:
+				if _, ok := ew.services[name][id]; ok {
+					delete(ew.services[name], id)
+					changed[name] = true
+				}
+			}
+		}
+		// 服务的全部实例已删除时发送空的 delete，否则发送当前全部实例
+		for name := range changed {
+			if len(ew.services[name]) == 0 {
+				delete(ew.services, name)
+				ew.send("delete", name)
+				continue
+			}
+			ew.send("update", name)
+		}
+	}
+}
+
+func (ew *Watcher) send(action string, name string) {
+	service := &servicediscovery.Service{Name: name}
+	for _, instance := range ew.services[name] {
+		service.Nodes = append(service.Nodes, instance)
+	}
+	sort.Slice(service.Nodes, func(i, j int) bool {
+		return service.Nodes[i].GetId() < service.Nodes[j].GetId()
+	})
+	select {
+	case <-ew.exit:
+	case ew.next <- &servicediscovery.Result{Action: action, Service: service}:
+	}
+}
+
+func decodeInstance(name, id string, value []byte) (servicediscovery.ServiceInstance, error) {
+	instance := &servicediscovery.DefaultServiceInstance{}
+	if err := json.Unmarshal(value, instance); err != nil {
+		return nil, err
+	}
+	instance.Id, instance.ServiceName = id, name
+	return instance, nil
+}
@@ -0,0 +1,280 @@
+// Package file 基于本地 YAML/JSON 文件的服务发现，适合本地开发和静态部署，文件格式:
+//
+//	services:
+//	  user-service:
+//	    - id: user-1
+//	      host: 127.0.0.1
+//	      port: 8080
+//	      tags: [v1]
+//	      weight: 10
+//	      metadata: {zone: a}
+//
+// 扩展名为 .json 时按 JSON 读写，否则按 YAML，文件修改后自动重新加载
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	discovery2 "github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"github.com/donetkit/contrib/utils/host"
+	"github.com/donetkit/contrib/utils/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+type Client struct {
+	path     string
+	options  *discovery2.Config
+	interval time.Duration
+	locker   sync.Mutex
+	modTime  time.Time
+	size     int64
+	snapshot servicediscovery.Snapshot
+}
+
+// Instance 文件中的服务实例
+type Instance struct {
+	Id       string            `json:"id" yaml:"id"`
+	Host     string            `json:"host" yaml:"host"`
+	Port     uint64            `json:"port" yaml:"port"`
+	Tags     []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Weight   float64           `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Disabled bool              `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// Document 服务文件内容
+type Document struct {
+	Services map[string][]*Instance `json:"services" yaml:"services"`
+}
+
+// New 创建文件服务发现，Register 把 Id、Name、CheckAddr、CheckPort、Tags 描述的实例写入文件
+func New(path string, opts ...discovery2.Option) (*Client, error) {
+	cfg := &discovery2.Config{
+		Id:             uuid.NewUUID(),
+		Name:           "Service",
+		CheckAddr:      host.GetOutBoundIp(),
+		CheckPort:      80,
+		Tags:           []string{"v0.0.1"},
+		IntervalTime:   15,
+		DeregisterTime: 15,
+		TimeOut:        3,
+		CheckResponse:  &discovery2.CheckResponse{RetryCount: 3},
+		CheckType:      "TCP",
+	}
+	cfg.CheckResponse.SetHealthy("Healthy")
+	cfg.HttpRouter = func(r *discovery2.CheckResponse) {}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if len(path) == 0 {
+		return nil, errors.New("create file discovery error: empty path")
+	}
+	return &Client{path: path, options: cfg, interval: time.Second}, nil
+}
+
+// SetTags set tags []string
+func (s *Client) SetTags(tags ...string) {
+	s.options.Tags = tags
+}
+
+// SetInterval 设置检查文件修改的间隔，默认1秒
+func (s *Client) SetInterval(interval time.Duration) {
+	if interval > 0 {
+		s.interval = interval
+	}
+}
+
+// Register 把当前服务实例写入文件，已存在相同ID时覆盖
+func (s *Client) Register() error {
+	address, port := s.options.Address()
+	err := s.update(func(doc *Document) {
+		s.remove(doc)
+		doc.Services[s.options.Name] = append(doc.Services[s.options.Name], &Instance{
+			Id:       s.options.Id,
+			Host:     address,
+			Port:     uint64(port),
+			Tags:     s.options.Tags,
+			Weight:   s.options.Weight(),
+			Metadata: s.options.Metadata,
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "register service error")
+	}
+	return nil
+}
+
+func (s *Client) Deregister() error {
+	if err := s.update(s.remove); err != nil {
+		return errors.Wrapf(err, "deregister service error[key=%s]", s.options.Id)
+	}
+	return nil
+}
+
+func (s *Client) remove(doc *Document) {
+	instances := doc.Services[s.options.Name]
+	kept := instances[:0]
+	for _, instance := range instances {
+		if instance.Id != s.options.Id {
+			kept = append(kept, instance)
+		}
+	}
+	if len(kept) == 0 {
+		delete(doc.Services, s.options.Name)
+		return
+	}
+	doc.Services[s.options.Name] = kept
+}
+
+// update 读取文件修改后写入临时文件再替换，读取方不会看到写了一半的文件
+func (s *Client) update(fn func(doc *Document)) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	doc, err := s.read()
+	if err != nil {
+		return err
+	}
+	fn(doc)
+	data, err := s.marshal(doc)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *Client) json() bool {
+	return strings.EqualFold(filepath.Ext(s.path), ".json")
+}
+
+func (s *Client) read() (*Document, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s.parse(data)
+}
+
+func (s *Client) parse(data []byte) (*Document, error) {
+	doc := &Document{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		var err error
+		if s.json() {
+			err = json.Unmarshal(data, doc)
+		} else {
+			err = yaml.Unmarshal(data, doc)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s error", s.path)
+		}
+	}
+	if doc.Services == nil {
+		doc.Services = make(map[string][]*Instance)
+	}
+	return doc, nil
+}
+
+func (s *Client) marshal(doc *Document) ([]byte, error) {
+	if s.json() {
+		return json.MarshalIndent(doc, "", "  ")
+	}
+	return yaml.Marshal(doc)
+}
+
+// Services 读取文件中的全部服务，文件不存在时为空，文件为空时返回错误
+func (s *Client) Services() (servicediscovery.Snapshot, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.load()
+}
+
+func (s *Client) load() (servicediscovery.Snapshot, error) {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.snapshot, s.modTime, s.size = servicediscovery.Snapshot{}, time.Time{}, 0
+		return s.snapshot, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 文件未修改时使用上次的结果
+	if s.snapshot != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.snapshot, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	// 空文件通常是正在写入，删除文件才会清空服务
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, errors.Errorf("%s is empty", s.path)
+	}
+	doc, err := s.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(servicediscovery.Snapshot, len(doc.Services))
+	for name, instances := range doc.Services {
+		nodes := make([]servicediscovery.ServiceInstance, 0, len(instances))
+		for _, instance := range instances {
+			if instance == nil {
+				continue
+			}
+			nodes = append(nodes, instance.serviceInstance(name))
+		}
+		snapshot[name] = nodes
+	}
+	s.snapshot, s.modTime, s.size = snapshot, info.ModTime(), info.Size()
+	return snapshot, nil
+}
+
+func (i *Instance) serviceInstance(name string) servicediscovery.ServiceInstance {
+	id := i.Id
+	if len(id) == 0 {
+		id = net.JoinHostPort(i.Host, strconv.FormatUint(i.Port, 10))
+	}
+	weight := i.Weight
+	if weight == 0 {
+		weight = 10
+	}
+	return &servicediscovery.DefaultServiceInstance{
+		Id:          id,
+		ServiceName: name,
+		Host:        i.Host,
+		Port:        i.Port,
+		Tags:        i.Tags,
+		Enable:      !i.Disabled,
+		Healthy:     true,
+		Weight:      weight,
+		Metadata:    i.Metadata,
+	}
+}
+
+// Watch 监听文件变化，WatchService 指定服务，否则监听全部服务
+func (s *Client) Watch(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+	return servicediscovery.NewPollWatcher(s.path, s.interval, func(ctx context.Context) (servicediscovery.Snapshot, error) {
+		return s.Services()
+	}, opts...), nil
+}
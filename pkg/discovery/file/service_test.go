@@ -0,0 +1,126 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func next(t *testing.T, watcher servicediscovery.Watcher) *servicediscovery.Result {
+	results := make(chan *servicediscovery.Result, 1)
+	go func() {
+		result, err := watcher.Next()
+		assert.Nil(t, err)
+		results <- result
+	}()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(3 * time.Second):
+		t.Fatal("no watch result")
+		return nil
+	}
+}
+
+func TestRegisterWatch(t *testing.T) {
+	for _, name := range []string{"services.yaml", "services.json"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			client1, err := New(path, discovery.WithName("svc"), discovery.WithId("1"), discovery.WithCheckAddr("10.0.0.1"), discovery.WithCheckPort(80))
+			assert.Nil(t, err)
+			client2, err := New(path, discovery.WithName("svc"), discovery.WithId("2"), discovery.WithCheckAddr("10.0.0.2"), discovery.WithCheckPort(80),
+				discovery.WithServiceAddr("192.168.0.2"), discovery.WithServicePort(9000),
+				discovery.WithMetadata(map[string]string{"zone": "a"}), discovery.WithWeights(5, 1))
+			assert.Nil(t, err)
+			client1.SetInterval(10 * time.Millisecond)
+
+			watcher, err := client1.Watch(servicediscovery.WatchService("svc"))
+			assert.Nil(t, err)
+			defer watcher.Stop()
+
+			assert.Nil(t, client1.Register())
+			result := next(t, watcher)
+			assert.Equal(t, "create", result.Action)
+			assert.Len(t, result.Service.Nodes, 1)
+			assert.Equal(t, "10.0.0.1", result.Service.Nodes[0].GetHost())
+
+			// 每个实例一个事件，create 带服务的全部实例
+			assert.Nil(t, client2.Register())
+			result = next(t, watcher)
+			assert.Equal(t, "create", result.Action)
+			assert.Len(t, result.Service.Nodes, 2)
+			node := result.Service.Nodes[1]
+			assert.Equal(t, "192.168.0.2", node.GetHost())
+			assert.Equal(t, uint64(9000), node.GetPort())
+			assert.Equal(t, float64(5), node.GetWeight())
+			assert.Equal(t, map[string]string{"zone": "a"}, node.GetMetadata())
+
+			// delete 带删除的实例
+			assert.Nil(t, client1.Deregister())
+			result = next(t, watcher)
+			assert.Equal(t, "delete", result.Action)
+			assert.Len(t, result.Service.Nodes, 1)
+			assert.Equal(t, "1", result.Service.Nodes[0].GetId())
+
+			assert.Nil(t, client2.Deregister())
+			result = next(t, watcher)
+			assert.Equal(t, "delete", result.Action)
+			assert.Len(t, result.Service.Nodes, 1)
+			assert.Equal(t, "2", result.Service.Nodes[0].GetId())
+		})
+	}
+}
+
+func TestHotReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+services:
+  a:
+    - host: 10.0.0.1
+      port: 80
+      tags: [v1]
+  b:
+    - id: b1
+      host: 10.0.0.2
+      port: 81
+      disabled: true
+`), 0644))
+	client, err := New(path)
+	assert.Nil(t, err)
+	client.SetInterval(10 * time.Millisecond)
+	watcher, err := client.Watch()
+	assert.Nil(t, err)
+	defer watcher.Stop()
+
+	result := next(t, watcher)
+	assert.Equal(t, "create", result.Action)
+	assert.Equal(t, "a", result.Service.Name)
+	assert.Equal(t, "10.0.0.1:80", result.Service.Nodes[0].GetId())
+	assert.Equal(t, []string{"v1"}, result.Service.Nodes[0].GetTags())
+	result = next(t, watcher)
+	assert.Equal(t, "b", result.Service.Name)
+	assert.False(t, result.Service.Nodes[0].IsEnable())
+
+	// a half written file keeps the previous services
+	assert.Nil(t, os.WriteFile(path, []byte("services: [\n"), 0644))
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Nil(t, os.WriteFile(path, []byte(`
+services:
+  a:
+    - host: 10.0.0.1
+      port: 80
+      tags: [v2]
+`), 0644))
+	result = next(t, watcher)
+	assert.Equal(t, "update", result.Action)
+	assert.Equal(t, []string{"v2"}, result.Service.Nodes[0].GetTags())
+	result = next(t, watcher)
+	assert.Equal(t, "delete", result.Action)
+	assert.Equal(t, "b", result.Service.Name)
+}
@@ -1,8 +1,23 @@
 package discovery
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound 键不存在，List 时为前缀下没有键
+var ErrNotFound = errors.New("not found value")
+
 type KV interface {
 	Get(key string) ([]byte, error)
 	Set(key string, value string) error
 	Delete(key string) error
 	List(key string) (map[string][]byte, error)
 }
+
+// KVWatcher 监听前缀下的键值变化
+type KVWatcher interface {
+	// WatchList 先以前缀 key 下的全部键值调用 fn，之后每次变化时再次调用，前缀下没有键时 values 为空；
+	// 阻塞直到 ctx 结束，返回 ctx.Err()
+	WatchList(ctx context.Context, key string, fn func(values map[string][]byte)) error
+}
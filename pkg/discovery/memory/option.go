@@ -0,0 +1,27 @@
+package memory
+
+import "time"
+
+// Option for memory registry
+type Option func(*Registry)
+
+// WithTTL set the ttl of instances, instances without heartbeat within ttl become unhealthy, default 0 never expires
+func WithTTL(ttl time.Duration) Option {
+	return func(r *Registry) {
+		r.ttl = ttl
+	}
+}
+
+// WithCheckInterval set the interval of checking ttl, default ttl/3
+func WithCheckInterval(interval time.Duration) Option {
+	return func(r *Registry) {
+		r.checkInterval = interval
+	}
+}
+
+// WithNow set the clock function, default time.Now
+func WithNow(now func() time.Time) Option {
+	return func(r *Registry) {
+		r.now = now
+	}
+}
@@ -0,0 +1,335 @@
+// Package memory 进程内的服务注册中心，实现 discovery.Discovery、discovery.KV、discovery.KVWatcher 和 servicediscovery.Watcher，
+// 用于测试和单进程部署，不依赖外部服务:
+//
+//	registry := memory.NewRegistry(memory.WithTTL(time.Second))
+//	defer registry.Close()
+//	client := memory.New(registry, discovery.WithName("user-service"), discovery.WithCheckPort(8080))
+//	client.Register()
+//	conn, err := grpc.Dial("discovery:///user-service", grpc.WithResolvers(resolver.NewBuilder(registry.Watch)), ...)
+//
+// 同一注册中心的全部变化按发生顺序依次发送给每个监听者，不会丢失或乱序
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	discovery2 "github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+)
+
+// ErrNotFound 服务实例不存在
+var ErrNotFound = errors.New("memory registry: instance not found")
+
+type entry struct {
+	instance servicediscovery.DefaultServiceInstance
+	// 手动设置的健康状态
+	healthy bool
+	// 最近一次心跳时间
+	heartbeat time.Time
+}
+
+type Registry struct {
+	ttl           time.Duration
+	checkInterval time.Duration
+	now           func() time.Time
+
+	locker   sync.Mutex
+	services map[string]map[string]*entry
+	kv       map[string][]byte
+	// kvChanged 在键值变化时关闭并替换，通知 WatchList
+	kvChanged chan struct{}
+	watchers  map[*Watcher]struct{}
+	exit      chan struct{}
+	closed    bool
+}
+
+func NewRegistry(opts ...Option) *Registry {
+	r := &Registry{
+		now:       time.Now,
+		services:  make(map[string]map[string]*entry),
+		kv:        make(map[string][]byte),
+		kvChanged: make(chan struct{}),
+		watchers:  make(map[*Watcher]struct{}),
+		exit:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.ttl > 0 {
+		if r.checkInterval <= 0 {
+			r.checkInterval = r.ttl / 3
+		}
+		go r.checkTTL()
+	}
+	return r
+}
+
+// Close 停止 TTL 检查和全部监听
+func (r *Registry) Close() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.exit)
+	for w := range r.watchers {
+		w.stop()
+	}
+	r.watchers = make(map[*Watcher]struct{})
+}
+
+func (r *Registry) checkTTL() {
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.exit:
+			return
+		case <-ticker.C:
+			r.CheckTTL()
+		}
+	}
+}
+
+// CheckTTL 检查实例心跳，超过 TTL 的实例变为不健康，通常由后台定时执行
+func (r *Registry) CheckTTL() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for _, name := range r.names() {
+		changed := false
+		for _, e := range r.services[name] {
+			if healthy := r.healthy(e); healthy != e.instance.Healthy {
+				e.instance.Healthy = healthy
+				changed = true
+			}
+		}
+		if changed {
+			r.publish(servicediscovery.Update, name)
+		}
+	}
+}
+
+func (r *Registry) healthy(e *entry) bool {
+	if !e.healthy {
+		return false
+	}
+	return r.ttl <= 0 || r.now().Sub(e.heartbeat) <= r.ttl
+}
+
+// Register 注册或更新服务实例，新注册的实例是健康的
+func (r *Registry) Register(instance servicediscovery.ServiceInstance) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	name := instance.GetServiceName()
+	instances, ok := r.services[name]
+	if !ok {
+		instances = make(map[string]*entry)
+		r.services[name] = instances
+	}
+	e := &entry{
+		instance: servicediscovery.DefaultServiceInstance{
+			Id:          instance.GetId(),
+			ServiceName: name,
+			Host:        instance.GetHost(),
+			Port:        instance.GetPort(),
+			ClusterName: instance.GetClusterName(),
+			GroupName:   instance.GetGroupName(),
+			Tags:        append([]string(nil), instance.GetTags()...),
+			Enable:      instance.IsEnable(),
+			Weight:      instance.GetWeight(),
+			Metadata:    copyMetadata(instance.GetMetadata()),
+		},
+		healthy:   true,
+		heartbeat: r.now(),
+	}
+	e.instance.Healthy = r.healthy(e)
+	instances[e.instance.Id] = e
+	if ok {
+		r.publish(servicediscovery.Update, name)
+	} else {
+		r.publish(servicediscovery.Create, name)
+	}
+}
+
+// Deregister 注销服务实例，服务的最后一个实例注销后删除服务
+func (r *Registry) Deregister(service, id string) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if _, ok := r.services[service][id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.services[service], id)
+	if len(r.services[service]) == 0 {
+		delete(r.services, service)
+		r.publish(servicediscovery.Delete, service)
+		return nil
+	}
+	r.publish(servicediscovery.Update, service)
+	return nil
+}
+
+// Heartbeat 刷新实例的 TTL
+func (r *Registry) Heartbeat(service, id string) error {
+	return r.update(service, id, func(e *entry) {
+		e.heartbeat = r.now()
+	})
+}
+
+// SetHealthy 手动设置实例健康状态，不健康的实例心跳后仍不健康
+func (r *Registry) SetHealthy(service, id string, healthy bool) error {
+	return r.update(service, id, func(e *entry) {
+		e.healthy = healthy
+	})
+}
+
+func (r *Registry) update(service, id string, fn func(e *entry)) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	e, ok := r.services[service][id]
+	if !ok {
+		return ErrNotFound
+	}
+	fn(e)
+	if healthy := r.healthy(e); healthy != e.instance.Healthy {
+		e.instance.Healthy = healthy
+		r.publish(servicediscovery.Update, service)
+	}
+	return nil
+}
+
+// GetService 返回服务的全部实例，按ID排序
+func (r *Registry) GetService(name string) []servicediscovery.ServiceInstance {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.service(name).Nodes
+}
+
+// Services 返回全部服务名，按名称排序
+func (r *Registry) Services() []string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.names()
+}
+
+func (r *Registry) names() []string {
+	names := make([]string, 0, len(r.services))
+	for name := range r.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// service 复制服务的实例，调用方修改实例不影响注册中心
+func (r *Registry) service(name string) *servicediscovery.Service {
+	service := &servicediscovery.Service{Name: name}
+	for _, e := range r.services[name] {
+		instance := e.instance
+		instance.Tags = append([]string(nil), e.instance.Tags...)
+		instance.Metadata = copyMetadata(e.instance.Metadata)
+		service.Nodes = append(service.Nodes, &instance)
+	}
+	sort.Slice(service.Nodes, func(i, j int) bool {
+		return service.Nodes[i].GetId() < service.Nodes[j].GetId()
+	})
+	return service
+}
+
+func (r *Registry) publish(t servicediscovery.EventType, name string) {
+	event := &servicediscovery.Event{Id: "memory", Type: t, Timestamp: r.now(), Service: &servicediscovery.Service{Name: name}}
+	if t != servicediscovery.Delete {
+		event.Service = r.service(name)
+	}
+	for w := range r.watchers {
+		if len(w.service) == 0 || w.service == name {
+			w.push(event)
+		}
+	}
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	m := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		m[k] = v
+	}
+	return m
+}
+
+func (r *Registry) Get(key string) ([]byte, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	value, ok := r.kv[key]
+	if !ok {
+		return nil, discovery2.ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (r *Registry) Set(key string, value string) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.kv[key] = []byte(value)
+	r.notifyKV()
+	return nil
+}
+
+func (r *Registry) Delete(key string) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if _, ok := r.kv[key]; ok {
+		delete(r.kv, key)
+		r.notifyKV()
+	}
+	return nil
+}
+
+func (r *Registry) List(key string) (map[string][]byte, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	values := r.list(key)
+	if len(values) == 0 {
+		return nil, discovery2.ErrNotFound
+	}
+	return values, nil
+}
+
+func (r *Registry) list(key string) map[string][]byte {
+	values := make(map[string][]byte)
+	for k, v := range r.kv {
+		if strings.HasPrefix(k, key) {
+			values[k] = append([]byte(nil), v...)
+		}
+	}
+	return values
+}
+
+// WatchList 监听前缀下的键值，注册中心关闭时返回 nil
+func (r *Registry) WatchList(ctx context.Context, key string, fn func(values map[string][]byte)) error {
+	for {
+		r.locker.Lock()
+		values, changed := r.list(key), r.kvChanged
+		r.locker.Unlock()
+		fn(values)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.exit:
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+func (r *Registry) notifyKV() {
+	close(r.kvChanged)
+	r.kvChanged = make(chan struct{})
+}
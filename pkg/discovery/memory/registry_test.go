@@ -0,0 +1,192 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"github.com/stretchr/testify/assert"
+)
+
+type clock struct {
+	locker sync.Mutex
+	now    time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func next(t *testing.T, watcher servicediscovery.Watcher) *servicediscovery.Result {
+	result, err := watcher.Next()
+	assert.Nil(t, err)
+	return result
+}
+
+func healthy(result *servicediscovery.Result) []string {
+	var ids []string
+	for _, node := range servicediscovery.ApplyFilters(result.Service.Nodes, servicediscovery.HealthyFilter()) {
+		ids = append(ids, node.GetId())
+	}
+	return ids
+}
+
+func TestWatchOrder(t *testing.T) {
+	registry := NewRegistry()
+	defer registry.Close()
+	a1 := New(registry, discovery.WithName("a"), discovery.WithId("1"))
+	assert.Nil(t, a1.Register())
+
+	watcher, err := registry.Watch()
+	assert.Nil(t, err)
+	defer watcher.Stop()
+	only, err := registry.Watch(servicediscovery.WatchService("b"))
+	assert.Nil(t, err)
+	defer only.Stop()
+
+	b1 := New(registry, discovery.WithName("b"), discovery.WithId("1"), discovery.WithCheckAddr("10.0.0.1"), discovery.WithCheckPort(8080))
+	b2 := New(registry, discovery.WithName("b"), discovery.WithId("2"))
+	assert.Nil(t, b1.Register())
+	assert.Nil(t, b2.Register())
+	assert.Nil(t, a1.Deregister())
+	assert.Nil(t, b1.Deregister())
+
+	var actions []string
+	for i := 0; i < 5; i++ {
+		result := next(t, watcher)
+		actions = append(actions, result.Action+" "+result.Service.Name)
+	}
+	assert.Equal(t, []string{"create a", "create b", "update b", "delete a", "update b"}, actions)
+
+	result := next(t, only)
+	assert.Equal(t, "create", result.Action)
+	assert.Equal(t, "10.0.0.1", result.Service.Nodes[0].GetHost())
+	assert.Equal(t, uint64(8080), result.Service.Nodes[0].GetPort())
+	assert.Len(t, next(t, only).Service.Nodes, 2)
+	assert.Len(t, next(t, only).Service.Nodes, 1)
+
+	assert.Equal(t, []string{"b"}, registry.Services())
+	assert.NotNil(t, a1.Deregister())
+}
+
+func TestHealth(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	registry := NewRegistry(WithTTL(10*time.Second), WithCheckInterval(time.Hour), WithNow(c.Now))
+	defer registry.Close()
+	s1 := New(registry, discovery.WithName("svc"), discovery.WithId("1"))
+	s2 := New(registry, discovery.WithName("svc"), discovery.WithId("2"))
+	assert.Nil(t, s1.Register())
+	assert.Nil(t, s2.Register())
+
+	watcher, err := registry.Watch(servicediscovery.WatchService("svc"))
+	assert.Nil(t, err)
+	defer watcher.Stop()
+	assert.Equal(t, []string{"1", "2"}, healthy(next(t, watcher)))
+
+	// the second instance misses its heartbeat
+	c.Add(6 * time.Second)
+	assert.Nil(t, s1.Heartbeat())
+	c.Add(6 * time.Second)
+	registry.CheckTTL()
+	assert.Equal(t, []string{"1"}, healthy(next(t, watcher)))
+
+	assert.Nil(t, s2.Heartbeat())
+	assert.Equal(t, []string{"1", "2"}, healthy(next(t, watcher)))
+
+	// a manually unhealthy instance stays unhealthy after heartbeats
+	assert.Nil(t, s1.SetHealthy(false))
+	assert.Equal(t, []string{"2"}, healthy(next(t, watcher)))
+	assert.Nil(t, s1.Heartbeat())
+	assert.Nil(t, s1.SetHealthy(true))
+	assert.Equal(t, []string{"1", "2"}, healthy(next(t, watcher)))
+
+	assert.Equal(t, ErrNotFound, registry.SetHealthy("svc", "3", false))
+}
+
+func TestTTLExpires(t *testing.T) {
+	registry := NewRegistry(WithTTL(30 * time.Millisecond))
+	defer registry.Close()
+	client := New(registry, discovery.WithName("svc"), discovery.WithId("1"))
+	assert.Nil(t, client.Register())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher, err := registry.Watch(servicediscovery.WatchService("svc"), servicediscovery.WatchContext(ctx))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1"}, healthy(next(t, watcher)))
+	assert.Empty(t, healthy(next(t, watcher)))
+
+	// the context stops the watcher
+	cancel()
+	_, err = watcher.Next()
+	assert.NotNil(t, err)
+}
+
+func TestKV(t *testing.T) {
+	registry := NewRegistry()
+	defer registry.Close()
+	var kv discovery.KV = New(registry)
+	assert.Nil(t, kv.Set("config/a", "1"))
+	assert.Nil(t, kv.Set("config/b", "2"))
+	assert.Nil(t, kv.Set("other", "3"))
+
+	value, err := kv.Get("config/a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(value))
+	values, err := kv.List("config/")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"config/a": []byte("1"), "config/b": []byte("2")}, values)
+
+	assert.Nil(t, kv.Delete("config/a"))
+	_, err = kv.Get("config/a")
+	assert.Equal(t, discovery.ErrNotFound, err)
+	_, err = kv.List("none/")
+	assert.Equal(t, discovery.ErrNotFound, err)
+}
+
+func TestWatchList(t *testing.T) {
+	registry := NewRegistry()
+	var watcher discovery.KVWatcher = registry
+	values := make(chan map[string][]byte, 10)
+	done := make(chan error)
+	go func() {
+		done <- watcher.WatchList(context.Background(), "config/", func(v map[string][]byte) { values <- v })
+	}()
+	assert.Equal(t, map[string][]byte{}, <-values)
+	assert.Nil(t, registry.Set("config/a", "1"))
+	assert.Equal(t, map[string][]byte{"config/a": []byte("1")}, <-values)
+	assert.Nil(t, registry.Delete("config/a"))
+	assert.Equal(t, map[string][]byte{}, <-values)
+	// deleting a missing key is not a change
+	assert.Nil(t, registry.Delete("config/a"))
+	assert.Len(t, values, 0)
+
+	registry.Close()
+	assert.Nil(t, <-done)
+}
+
+func TestClientRegister(t *testing.T) {
+	registry := NewRegistry()
+	client := New(registry, discovery.WithName("svc"), discovery.WithId("1"),
+		discovery.WithCheckAddr("10.0.0.1"), discovery.WithCheckPort(80),
+		discovery.WithServiceAddr("192.168.0.1"), discovery.WithServicePort(9000),
+		discovery.WithMetadata(map[string]string{"zone": "a"}), discovery.WithWeights(5, 1))
+	assert.Nil(t, client.Register())
+
+	nodes := registry.GetService("svc")
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, "192.168.0.1", nodes[0].GetHost())
+	assert.Equal(t, uint64(9000), nodes[0].GetPort())
+	assert.Equal(t, float64(5), nodes[0].GetWeight())
+	assert.Equal(t, map[string]string{"zone": "a"}, nodes[0].GetMetadata())
+}
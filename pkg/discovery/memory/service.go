@@ -0,0 +1,73 @@
+package memory
+
+import (
+	discovery2 "github.com/donetkit/contrib/pkg/discovery"
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"github.com/donetkit/contrib/utils/host"
+	"github.com/donetkit/contrib/utils/uuid"
+	"github.com/pkg/errors"
+)
+
+// Client 注册到进程内注册中心的服务，KV 和 Watch 共享注册中心
+type Client struct {
+	*Registry
+	options *discovery2.Config
+}
+
+func New(registry *Registry, opts ...discovery2.Option) *Client {
+	cfg := &discovery2.Config{
+		Id:             uuid.NewUUID(),
+		Name:           "Service",
+		CheckAddr:      host.GetOutBoundIp(),
+		CheckPort:      80,
+		Tags:           []string{"v0.0.1"},
+		IntervalTime:   15,
+		DeregisterTime: 15,
+		TimeOut:        3,
+		CheckResponse:  &discovery2.CheckResponse{RetryCount: 3},
+		CheckType:      "TCP",
+	}
+	cfg.CheckResponse.SetHealthy("Healthy")
+	cfg.HttpRouter = func(r *discovery2.CheckResponse) {}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return &Client{Registry: registry, options: cfg}
+}
+
+// SetTags set tags []string
+func (s *Client) SetTags(tags ...string) {
+	s.options.Tags = tags
+}
+
+func (s *Client) Register() error {
+	address, port := s.options.Address()
+	s.Registry.Register(&servicediscovery.DefaultServiceInstance{
+		Id:          s.options.Id,
+		ServiceName: s.options.Name,
+		Host:        address,
+		Port:        uint64(port),
+		Tags:        s.options.Tags,
+		Enable:      true,
+		Weight:      s.options.Weight(),
+		Metadata:    s.options.Metadata,
+	})
+	return nil
+}
+
+func (s *Client) Deregister() error {
+	if err := s.Registry.Deregister(s.options.Name, s.options.Id); err != nil {
+		return errors.Wrapf(err, "deregister service error[key=%s]", s.options.Id)
+	}
+	return nil
+}
+
+// Heartbeat 刷新当前实例的 TTL
+func (s *Client) Heartbeat() error {
+	return s.Registry.Heartbeat(s.options.Name, s.options.Id)
+}
+
+// SetHealthy 手动设置当前实例的健康状态
+func (s *Client) SetHealthy(healthy bool) error {
+	return s.Registry.SetHealthy(s.options.Name, s.options.Id, healthy)
+}
@@ -0,0 +1,108 @@
+package memory
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+)
+
+// Watcher 按发生顺序接收注册中心的变化，队列不限长度，发送方不会阻塞
+type Watcher struct {
+	registry *Registry
+	service  string
+
+	locker sync.Mutex
+	queue  []*servicediscovery.Event
+	signal chan struct{}
+	exit   chan struct{}
+}
+
+// Watch 监听服务变化，WatchService 指定服务，否则监听全部服务，
+// 先收到已注册服务的 create，然后是之后的变化
+func (r *Registry) Watch(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+	var wo servicediscovery.WatchOptions
+	for _, o := range opts {
+		o(&wo)
+	}
+	w := &Watcher{
+		registry: r,
+		service:  wo.Service,
+		signal:   make(chan struct{}, 1),
+		exit:     make(chan struct{}),
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.closed {
+		return nil, errors.New("memory registry closed")
+	}
+	for _, name := range r.names() {
+		if len(w.service) == 0 || w.service == name {
+			w.push(&servicediscovery.Event{Id: "memory", Type: servicediscovery.Create, Timestamp: r.now(), Service: r.service(name)})
+		}
+	}
+	r.watchers[w] = struct{}{}
+	if wo.Context != nil {
+		go func() {
+			select {
+			case <-wo.Context.Done():
+				w.Stop()
+			case <-w.exit:
+			}
+		}()
+	}
+	return w, nil
+}
+
+func (w *Watcher) push(event *servicediscovery.Event) {
+	w.locker.Lock()
+	w.queue = append(w.queue, event)
+	w.locker.Unlock()
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// NextEvent blocks until the next event
+func (w *Watcher) NextEvent() (*servicediscovery.Event, error) {
+	for {
+		w.locker.Lock()
+		if len(w.queue) > 0 {
+			event := w.queue[0]
+			w.queue[0] = nil
+			w.queue = w.queue[1:]
+			w.locker.Unlock()
+			return event, nil
+		}
+		w.locker.Unlock()
+		select {
+		case <-w.exit:
+			return nil, errors.New("watcher stopped")
+		case <-w.signal:
+		}
+	}
+}
+
+func (w *Watcher) Next() (*servicediscovery.Result, error) {
+	event, err := w.NextEvent()
+	if err != nil {
+		return nil, err
+	}
+	return &servicediscovery.Result{Action: event.Type.String(), Service: event.Service}, nil
+}
+
+func (w *Watcher) Stop() {
+	w.registry.locker.Lock()
+	defer w.registry.locker.Unlock()
+	delete(w.registry.watchers, w)
+	w.stop()
+}
+
+func (w *Watcher) stop() {
+	select {
+	case <-w.exit:
+	default:
+		close(w.exit)
+	}
+}
@@ -3,6 +3,8 @@ package discovery
 import (
 	"fmt"
 	"time"
+
+	"github.com/donetkit/contrib/pkg/health"
 )
 
 type HttpRouter func(r *CheckResponse)
@@ -58,6 +60,57 @@ func WithRegisterPort(port int) Option {
 	}
 }
 
+// WithServiceAddr set the registered service address, default CheckAddr
+func WithServiceAddr(addr string) Option {
+	return func(cfg *Config) {
+		cfg.ServiceAddr = addr
+	}
+}
+
+// WithServicePort set the registered service port, default CheckPort
+func WithServicePort(port int) Option {
+	return func(cfg *Config) {
+		cfg.ServicePort = port
+	}
+}
+
+// WithMetadata set metadata function, merged with the metadata set before
+func WithMetadata(metadata map[string]string) Option {
+	return func(cfg *Config) {
+		if cfg.Metadata == nil {
+			cfg.Metadata = make(map[string]string, len(metadata))
+		}
+		for k, v := range metadata {
+			cfg.Metadata[k] = v
+		}
+	}
+}
+
+// WithWeights set weights function
+func WithWeights(passing, warning int) Option {
+	return func(cfg *Config) {
+		cfg.Weights = &Weights{Passing: passing, Warning: warning}
+	}
+}
+
+// WithChecks add checks besides the CheckType check
+func WithChecks(checks ...*Check) Option {
+	return func(cfg *Config) {
+		cfg.Checks = append(cfg.Checks, checks...)
+	}
+}
+
+// WithCheckTTL 使用 TTL 检查，服务每 ttl/2 秒上报一次状态，超过 ttl 秒未上报变为 critical
+func WithCheckTTL(ttl int) Option {
+	return func(cfg *Config) {
+		if ttl <= 0 {
+			ttl = 15
+		}
+		cfg.CheckType = "TTL"
+		cfg.TTL = ttl
+	}
+}
+
 // WithCheckAddr set addr function
 func WithCheckAddr(addr string) Option {
 	return func(cfg *Config) {
@@ -116,6 +169,20 @@ func WithEnableHealthyStatus() Option {
 	}
 }
 
+// WithHealthManager 使用 manager 执行服务自检，可在 manager 中添加数据库、Redis 等检查
+func WithHealthManager(manager *health.Manager) Option {
+	return func(cfg *Config) {
+		cfg.Health = manager
+	}
+}
+
+// WithHealthActions 设置自检状态变化时的动作，如 health.ShutdownAction(server) 优雅退出
+func WithHealthActions(actions ...health.ChangeFunc) Option {
+	return func(cfg *Config) {
+		cfg.HealthActions = actions
+	}
+}
+
 // WithCheckType  检查类型 HTTP TCP GRPC
 func WithCheckType(checkType string) Option {
 	return func(cfg *Config) {
@@ -147,18 +214,31 @@ func WithCheckHTTP(router HttpRouter, checkHttp ...string) Option {
 	}
 }
 
-// WithCheckGrpc set checkHttp function r.GET(url, func(c *gin.Context) { c.String(200, "Healthy") })
+// WithCheckGrpc Deprecated: 使用 WithCheckGrpcService。checkPath 与之前相同是交给 router 的 HTTP 路径，
+// 不作为 gRPC 服务名，检查整个服务器
 func WithCheckGrpc(router HttpRouter, checkPath ...string) Option {
 	return func(cfg *Config) {
-		cfg.HttpRouter = router
 		var url = fmt.Sprintf("/health/%s.health", cfg.Id)
 		if len(checkPath) > 0 {
 			url = checkPath[0]
 		}
-		cfg.CheckType = "GRPC"
-		cfg.CheckPath = url
-		cfg.CheckPath = fmt.Sprintf("%v:%v/%v", cfg.CheckAddr, cfg.CheckPort, url)
 		cfg.CheckResponse.Url = url
-		cfg.HttpRouter(cfg.CheckResponse)
+		if router != nil {
+			cfg.HttpRouter = router
+			cfg.HttpRouter(cfg.CheckResponse)
+		}
+		WithCheckGrpcService()(cfg)
+	}
+}
+
+// WithCheckGrpcService 使用 grpc.health.v1.Health 检查 CheckAddr:CheckPort，service 指定检查的 gRPC 服务，
+// 默认检查整个服务器，需在 WithCheckAddr、WithCheckPort 之后使用
+func WithCheckGrpcService(service ...string) Option {
+	return func(cfg *Config) {
+		cfg.CheckType = "GRPC"
+		cfg.CheckPath = fmt.Sprintf("%v:%v", cfg.CheckAddr, cfg.CheckPort)
+		if len(service) > 0 && len(service[0]) > 0 {
+			cfg.CheckPath = fmt.Sprintf("%s/%s", cfg.CheckPath, service[0])
+		}
 	}
 }
@@ -0,0 +1,48 @@
+package resolver
+
+import (
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+)
+
+// Option for resolver builder
+type Option func(*Builder)
+
+// WithScheme set scheme function, default discovery
+func WithScheme(scheme string) Option {
+	return func(b *Builder) {
+		b.scheme = scheme
+	}
+}
+
+// WithFilters set the filters instances must pass, replacing the default
+// HealthyFilter
+func WithFilters(filters ...servicediscovery.Filter) Option {
+	return func(b *Builder) {
+		b.filters = filters
+	}
+}
+
+// WithSelectors set the selectors applied to the instances passing the filters
+// for every RPC, e.g. servicediscovery.ZoneSelector, the route of an RPC is read
+// from its outgoing metadata. Only the default balancer applies them.
+func WithSelectors(selectors ...servicediscovery.Selector) Option {
+	return func(b *Builder) {
+		b.selectors = selectors
+	}
+}
+
+// WithBalancer set the load balancing policy put in the service config,
+// default the weighted round-robin balancer. Empty leaves it to grpc.Dial
+func WithBalancer(name string) Option {
+	return func(b *Builder) {
+		b.balancer = name
+	}
+}
+
+// WithLogger set logger function
+func WithLogger(logger glog.ILogger) Option {
+	return func(b *Builder) {
+		b.logger = logger.WithField("Discovery-Resolver", "Discovery-Resolver")
+	}
+}
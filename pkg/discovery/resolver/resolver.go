@@ -0,0 +1,165 @@
+// Package resolver provides a gRPC resolver following the instances of a
+// service through a servicediscovery.Watcher, e.g.
+//
+//	builder := resolver.NewBuilder(consulClient.Watch)
+//	conn, err := grpc.Dial("discovery:///service-name?tag=v1", grpc.WithResolvers(builder), ...)
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/pkg/discovery/balancer"
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	grpcResolver "google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Scheme is the default scheme of the resolver
+const Scheme = "discovery"
+
+// ErrNoInstances is reported when no instance of the service passes the filters
+var ErrNoInstances = errors.New("discovery resolver: no available instances")
+
+// Builder builds resolvers for targets like discovery:///service-name.
+// The tag query parameter, which may be repeated, only keeps the instances
+// carrying all the tags.
+type Builder struct {
+	watch     servicediscovery.WatchFunc
+	scheme    string
+	filters   []servicediscovery.Filter
+	selectors []servicediscovery.Selector
+	balancer  string
+	logger    glog.ILoggerEntry
+}
+
+func NewBuilder(watch servicediscovery.WatchFunc, opts ...Option) *Builder {
+	b := &Builder{
+		watch:    watch,
+		scheme:   Scheme,
+		filters:  []servicediscovery.Filter{servicediscovery.HealthyFilter()},
+		balancer: balancer.Name,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Register registers a Builder globally, grpc.Dial then resolves the scheme
+// without grpc.WithResolvers
+func Register(watch servicediscovery.WatchFunc, opts ...Option) *Builder {
+	b := NewBuilder(watch, opts...)
+	grpcResolver.Register(b)
+	return b
+}
+
+func (b *Builder) Scheme() string {
+	return b.scheme
+}
+
+func (b *Builder) Build(target grpcResolver.Target, cc grpcResolver.ClientConn, opts grpcResolver.BuildOptions) (grpcResolver.Resolver, error) {
+	service := strings.TrimPrefix(target.URL.Path, "/")
+	if len(service) == 0 {
+		service = target.URL.Host
+	}
+	if len(service) == 0 {
+		return nil, fmt.Errorf("discovery resolver: missing service name in target %q", target.URL.String())
+	}
+	filters := b.filters
+	if tags := target.URL.Query()["tag"]; len(tags) > 0 {
+		filters = append(filters[:len(filters):len(filters)], servicediscovery.TagFilter(tags...))
+	}
+	watcher, err := b.watch(servicediscovery.WatchService(service))
+	if err != nil {
+		return nil, err
+	}
+	r := &discoveryResolver{
+		cc:        cc,
+		watcher:   watcher,
+		instances: servicediscovery.NewInstances(service),
+		filters:   filters,
+		selectors: b.selectors,
+		logger:    b.logger,
+		done:      make(chan struct{}),
+	}
+	if len(b.balancer) > 0 {
+		r.serviceConfig = cc.ParseServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, b.balancer))
+	}
+	go r.watch()
+	return r, nil
+}
+
+type discoveryResolver struct {
+	cc            grpcResolver.ClientConn
+	watcher       servicediscovery.Watcher
+	instances     *servicediscovery.Instances
+	filters       []servicediscovery.Filter
+	selectors     []servicediscovery.Selector
+	serviceConfig *serviceconfig.ParseResult
+	logger        glog.ILoggerEntry
+	done          chan struct{}
+}
+
+func (r *discoveryResolver) watch() {
+	defer close(r.done)
+	for {
+		result, err := r.watcher.Next()
+		if err != nil {
+			// the watcher is stopped
+			return
+		}
+		if r.instances.Apply(result) {
+			r.update()
+		}
+	}
+}
+
+func (r *discoveryResolver) update() {
+	instances := r.instances.List(r.filters...)
+	if len(instances) == 0 {
+		r.cc.ReportError(ErrNoInstances)
+		return
+	}
+	addrs := make([]grpcResolver.Address, 0, len(instances))
+	for _, instance := range instances {
+		addrs = append(addrs, Address(instance))
+	}
+	state := grpcResolver.State{Addresses: addrs}
+	if len(r.selectors) > 0 {
+		state = balancer.SetSelectors(state, r.selectors...)
+	}
+	if r.serviceConfig != nil {
+		state.ServiceConfig = r.serviceConfig
+	}
+	if err := r.cc.UpdateState(state); err != nil && r.logger != nil {
+		r.logger.Warningf("update resolver state failed: %s", err.Error())
+	}
+}
+
+// ResolveNow the watcher pushes changes, nothing to do
+func (r *discoveryResolver) ResolveNow(grpcResolver.ResolveNowOptions) {}
+
+func (r *discoveryResolver) Close() {
+	r.watcher.Stop()
+	<-r.done
+}
+
+// Address converts an instance to a resolver address carrying the instance and its weight
+func Address(instance servicediscovery.ServiceInstance) grpcResolver.Address {
+	addr := grpcResolver.Address{
+		Addr: net.JoinHostPort(instance.GetHost(), strconv.FormatUint(instance.GetPort(), 10)),
+	}
+	weight := instance.GetWeight()
+	if weight < 1 {
+		weight = 1
+	} else if weight > math.MaxUint32 {
+		weight = math.MaxUint32
+	}
+	return balancer.SetWeight(balancer.SetInstance(addr, instance), uint32(math.Round(weight)))
+}
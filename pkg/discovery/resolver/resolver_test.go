@@ -0,0 +1,196 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type fakeWatcher struct {
+	next chan *servicediscovery.Result
+	exit chan struct{}
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{next: make(chan *servicediscovery.Result, 10), exit: make(chan struct{})}
+}
+
+func (w *fakeWatcher) Next() (*servicediscovery.Result, error) {
+	select {
+	case <-w.exit:
+		return nil, errors.New("watcher stopped")
+	case r := <-w.next:
+		return r, nil
+	}
+}
+
+func (w *fakeWatcher) Stop() {
+	close(w.exit)
+}
+
+func startServer(t *testing.T) (string, uint64) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	host, port, _ := net.SplitHostPort(lis.Addr().String())
+	p, _ := strconv.ParseUint(port, 10, 64)
+	return host, p
+}
+
+func instance(id, host string, port uint64, weight float64, tags ...string) servicediscovery.ServiceInstance {
+	return &servicediscovery.DefaultServiceInstance{
+		Id:          id,
+		ServiceName: "svc",
+		Host:        host,
+		Port:        port,
+		Tags:        tags,
+		Enable:      true,
+		Healthy:     true,
+		Weight:      weight,
+	}
+}
+
+func count(t *testing.T, conn *grpc.ClientConn, n int) map[string]int {
+	return countContext(t, context.Background(), conn, n)
+}
+
+func countContext(t *testing.T, parent context.Context, conn *grpc.ClientConn, n int) map[string]int {
+	client := grpc_health_v1.NewHealthClient(conn)
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		var p peer.Peer
+		ctx, cancel := context.WithTimeout(parent, 5*time.Second)
+		_, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.Peer(&p), grpc.WaitForReady(true))
+		cancel()
+		assert.Nil(t, err)
+		counts[p.Addr.String()]++
+	}
+	return counts
+}
+
+func TestResolverWeightedRoundRobin(t *testing.T) {
+	host1, port1 := startServer(t)
+	host2, port2 := startServer(t)
+	host3, port3 := startServer(t)
+	addr1 := net.JoinHostPort(host1, strconv.FormatUint(port1, 10))
+	addr2 := net.JoinHostPort(host2, strconv.FormatUint(port2, 10))
+
+	watcher := newFakeWatcher()
+	var watched string
+	builder := NewBuilder(func(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+		var o servicediscovery.WatchOptions
+		for _, opt := range opts {
+			opt(&o)
+		}
+		watched = o.Service
+		return watcher, nil
+	})
+
+	unhealthy := instance("3", host3, port3, 10, "v1").(*servicediscovery.DefaultServiceInstance)
+	unhealthy.Healthy = false
+	watcher.next <- &servicediscovery.Result{Action: "update", Service: &servicediscovery.Service{
+		Name: "svc",
+		Nodes: []servicediscovery.ServiceInstance{
+			instance("1", host1, port1, 30, "v1"),
+			instance("2", host2, port2, 10, "v1"),
+			unhealthy,
+			instance("4", "127.0.0.1", 1, 10, "v2"),
+		},
+	}}
+
+	conn, err := grpc.Dial("discovery:///svc?tag=v1", grpc.WithResolvers(builder), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// wait for both servers to be ready
+	assert.Eventually(t, func() bool {
+		return len(count(t, conn, 8)) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "svc", watched)
+
+	counts := count(t, conn, 40)
+	assert.Equal(t, 30, counts[addr1])
+	assert.Equal(t, 10, counts[addr2])
+
+	// the first instance goes away
+	watcher.next <- &servicediscovery.Result{Action: "delete", Service: &servicediscovery.Service{
+		Name:  "svc",
+		Nodes: []servicediscovery.ServiceInstance{instance("1", host1, port1, 30, "v1")},
+	}}
+	assert.Eventually(t, func() bool {
+		counts := count(t, conn, 4)
+		return counts[addr2] == 4
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestResolverSelectors(t *testing.T) {
+	hostA, portA := startServer(t)
+	hostB, portB := startServer(t)
+	hostC, portC := startServer(t)
+	addrA := net.JoinHostPort(hostA, strconv.FormatUint(portA, 10))
+	addrB := net.JoinHostPort(hostB, strconv.FormatUint(portB, 10))
+	addrC := net.JoinHostPort(hostC, strconv.FormatUint(portC, 10))
+	node := func(id, host string, port uint64, zone, version string) servicediscovery.ServiceInstance {
+		instance := instance(id, host, port, 10).(*servicediscovery.DefaultServiceInstance)
+		instance.Metadata = map[string]string{servicediscovery.MetadataZone: zone, servicediscovery.MetadataVersion: version}
+		return instance
+	}
+
+	watcher := newFakeWatcher()
+	builder := NewBuilder(func(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+		return watcher, nil
+	}, WithSelectors(servicediscovery.ZoneSelector("a", 1), servicediscovery.VersionSelector("")))
+	watcher.next <- &servicediscovery.Result{Action: "update", Service: &servicediscovery.Service{
+		Name: "svc",
+		Nodes: []servicediscovery.ServiceInstance{
+			node("a", hostA, portA, "a", "v1"),
+			node("b", hostB, portB, "b", "v1"),
+			node("c", hostC, portC, "a", "v2"),
+		},
+	}}
+
+	conn, err := grpc.Dial("discovery:///svc", grpc.WithResolvers(builder), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// the local zone until the route of the RPC overrides it
+	assert.Eventually(t, func() bool {
+		return len(count(t, conn, 8)) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]int{addrA: 5, addrC: 5}, count(t, conn, 10))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-route-version", "v2")
+	assert.Equal(t, map[string]int{addrC: 4}, countContext(t, ctx, conn, 4))
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-route-zone", "b")
+	assert.Eventually(t, func() bool {
+		return countContext(t, ctx, conn, 4)[addrB] == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-route-version", "v3")
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestBuildMissingService(t *testing.T) {
+	builder := NewBuilder(func(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+		return newFakeWatcher(), nil
+	})
+	_, err := grpc.Dial("discovery:///", grpc.WithResolvers(builder), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock(), grpc.WithTimeout(100*time.Millisecond))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,135 @@
+package servicediscovery
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Cache is a live view of the services of a Watcher for synchronous lookups,
+// safe for concurrent use:
+//
+//	cache, err := servicediscovery.NewCache(consulClient.Watch)
+//	defer cache.Stop()
+//	instances := cache.GetInstances("user-service", servicediscovery.HealthyFilter())
+type Cache struct {
+	watcher *InstanceWatcher
+
+	locker    sync.RWMutex
+	services  map[string]map[string]ServiceInstance
+	listeners []func(event *Event)
+	// changed is closed and replaced on every change
+	changed chan struct{}
+	done    chan struct{}
+}
+
+// NewCache creates a Watcher with watch and keeps the cache up to date until Stop
+func NewCache(watch WatchFunc, opts ...WatchOption) (*Cache, error) {
+	watcher, err := WatchInstances(watch, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		watcher:  watcher,
+		services: make(map[string]map[string]ServiceInstance),
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+func (c *Cache) run() {
+	defer close(c.done)
+	for {
+		event, err := c.watcher.Next()
+		if err != nil {
+			// the watcher is stopped
+			return
+		}
+		c.apply(event)
+	}
+}
+
+func (c *Cache) apply(event *Event) {
+	c.locker.Lock()
+	name := event.Service.Name
+	switch event.Type {
+	case Create, Update:
+		if c.services[name] == nil {
+			c.services[name] = make(map[string]ServiceInstance)
+		}
+		c.services[name][event.Current.GetId()] = event.Current
+	case Delete:
+		delete(c.services[name], event.Previous.GetId())
+		if len(c.services[name]) == 0 {
+			delete(c.services, name)
+		}
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+	listeners := c.listeners
+	c.locker.Unlock()
+	for _, fn := range listeners {
+		fn(event)
+	}
+}
+
+// OnEvent adds a listener called after each instance event is applied, in
+// order and never concurrently
+func (c *Cache) OnEvent(fn func(event *Event)) *Cache {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.listeners = append(c.listeners, fn)
+	return c
+}
+
+// GetInstances returns the instances of the service kept by the filters, sorted by id
+func (c *Cache) GetInstances(name string, filters ...Filter) []ServiceInstance {
+	c.locker.RLock()
+	instances := make([]ServiceInstance, 0, len(c.services[name]))
+	for _, instance := range c.services[name] {
+		instances = append(instances, instance)
+	}
+	c.locker.RUnlock()
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].GetId() < instances[j].GetId()
+	})
+	return ApplyFilters(instances, filters...)
+}
+
+// WaitInstances blocks until the service has instances kept by the filters,
+// or returns the error of ctx when ctx is done first
+func (c *Cache) WaitInstances(ctx context.Context, name string, filters ...Filter) ([]ServiceInstance, error) {
+	for {
+		c.locker.RLock()
+		changed := c.changed
+		c.locker.RUnlock()
+		if instances := c.GetInstances(name, filters...); len(instances) > 0 {
+			return instances, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// GetServices returns the names of the services having instances, sorted
+func (c *Cache) GetServices() []string {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	names := make([]string, 0, len(c.services))
+	for name := range c.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stop stops the watcher and waits for the last event to be applied
+func (c *Cache) Stop() {
+	c.watcher.Stop()
+	<-c.done
+}
@@ -0,0 +1,122 @@
+package servicediscovery
+
+import (
+	"sort"
+	"time"
+)
+
+// DiffInstances returns the instance events turning old into new, sorted by
+// instance id. Service is set to a service holding the new instances.
+func DiffInstances(name string, old, new []ServiceInstance) []*Event {
+	now := time.Now()
+	service := snapshotService(name, new)
+	previous, current := instanceMap(old), instanceMap(new)
+	var events []*Event
+	for id, instance := range current {
+		before, ok := previous[id]
+		switch {
+		case !ok:
+			events = append(events, &Event{Type: Create, Timestamp: now, Service: service, Current: instance})
+		case !sameInstance(before, instance):
+			events = append(events, &Event{Type: Update, Timestamp: now, Service: service, Previous: before, Current: instance})
+		}
+	}
+	for id, instance := range previous {
+		if _, ok := current[id]; !ok {
+			events = append(events, &Event{Type: Delete, Timestamp: now, Service: service, Previous: instance})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return eventInstanceId(events[i]) < eventInstanceId(events[j])
+	})
+	return events
+}
+
+func eventInstanceId(event *Event) string {
+	if event.Current != nil {
+		return event.Current.GetId()
+	}
+	return event.Previous.GetId()
+}
+
+// InstanceWatcher turns the results of a Watcher into per-instance events
+// carrying the instance before and after the change:
+//
+//	watcher, err := servicediscovery.WatchInstances(consulClient.Watch, servicediscovery.WatchService("user-service"))
+//	for {
+//		event, err := watcher.Next()
+//		if err != nil {
+//			return // stopped
+//		}
+//		log.Printf("%s %s: %v -> %v", event.Type, event.Service.Name, event.Previous, event.Current)
+//	}
+type InstanceWatcher struct {
+	watcher  Watcher
+	services map[string][]ServiceInstance
+	pending  []*Event
+}
+
+// NewInstanceWatcher wraps watcher, Stop stops watcher
+func NewInstanceWatcher(watcher Watcher) *InstanceWatcher {
+	return &InstanceWatcher{watcher: watcher, services: make(map[string][]ServiceInstance)}
+}
+
+// WatchInstances creates a Watcher with watch and wraps it
+func WatchInstances(watch WatchFunc, opts ...WatchOption) (*InstanceWatcher, error) {
+	watcher, err := watch(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewInstanceWatcher(watcher), nil
+}
+
+// Next blocks until the next instance event, results changing nothing are
+// skipped. Next is not safe for concurrent use.
+func (w *InstanceWatcher) Next() (*Event, error) {
+	for len(w.pending) == 0 {
+		result, err := w.watcher.Next()
+		if err != nil {
+			return nil, err
+		}
+		w.pending = w.apply(result)
+	}
+	event := w.pending[0]
+	w.pending[0] = nil
+	w.pending = w.pending[1:]
+	return event, nil
+}
+
+// apply applies result like Instances.Apply and returns the instance events
+func (w *InstanceWatcher) apply(result *Result) []*Event {
+	if result == nil || result.Service == nil {
+		return nil
+	}
+	name := result.Service.Name
+	old := w.services[name]
+	var instances []ServiceInstance
+	switch result.Action {
+	case Create.String(), Update.String():
+		instances = result.Service.Nodes
+	case Delete.String():
+		if len(result.Service.Nodes) > 0 {
+			removed := instanceMap(result.Service.Nodes)
+			for _, instance := range old {
+				if _, ok := removed[instance.GetId()]; !ok {
+					instances = append(instances, instance)
+				}
+			}
+		}
+	default:
+		return nil
+	}
+	if len(instances) == 0 {
+		delete(w.services, name)
+	} else {
+		w.services[name] = instances
+	}
+	return DiffInstances(name, old, instances)
+}
+
+func (w *InstanceWatcher) Stop() {
+	w.watcher.Stop()
+}
@@ -0,0 +1,125 @@
+package servicediscovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// results is a Watcher replaying results
+type results struct {
+	next chan *Result
+	exit chan struct{}
+}
+
+func newResults() *results {
+	return &results{next: make(chan *Result, 10), exit: make(chan struct{})}
+}
+
+func (r *results) watch(opts ...WatchOption) (Watcher, error) {
+	return r, nil
+}
+
+func (r *results) send(action string, name string, nodes ...ServiceInstance) {
+	r.next <- &Result{Action: action, Service: &Service{Name: name, Nodes: nodes}}
+}
+
+func (r *results) Next() (*Result, error) {
+	select {
+	case <-r.exit:
+		return nil, errors.New("watcher stopped")
+	case result := <-r.next:
+		return result, nil
+	}
+}
+
+func (r *results) Stop() {
+	close(r.exit)
+}
+
+func node(id string, port uint64) ServiceInstance {
+	return &DefaultServiceInstance{Id: id, ServiceName: "svc", Host: "10.0.0.1", Port: port, Enable: true, Healthy: true}
+}
+
+type change struct {
+	Type     EventType
+	Previous ServiceInstance
+	Current  ServiceInstance
+}
+
+func TestInstanceWatcher(t *testing.T) {
+	r := newResults()
+	watcher, err := WatchInstances(r.watch)
+	assert.Nil(t, err)
+	next := func() change {
+		event, err := watcher.Next()
+		assert.Nil(t, err)
+		return change{event.Type, event.Previous, event.Current}
+	}
+
+	a, b, b2, c := node("a", 1), node("b", 1), node("b", 2), node("c", 1)
+	r.send("create", "svc", b, a)
+	assert.Equal(t, change{Create, nil, a}, next())
+	assert.Equal(t, change{Create, nil, b}, next())
+
+	// unchanged instances are skipped
+	r.send("update", "svc", a, b)
+	r.send("update", "svc", a, b2, c)
+	assert.Equal(t, change{Update, b, b2}, next())
+	event, err := watcher.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, change{Create, nil, c}, change{event.Type, event.Previous, event.Current})
+	assert.Equal(t, []ServiceInstance{a, b2, c}, event.Service.Nodes)
+
+	r.send("delete", "svc", a)
+	assert.Equal(t, change{Delete, a, nil}, next())
+	r.send("delete", "svc")
+	assert.Equal(t, change{Delete, b2, nil}, next())
+	assert.Equal(t, change{Delete, c, nil}, next())
+
+	watcher.Stop()
+	_, err = watcher.Next()
+	assert.NotNil(t, err)
+}
+
+func TestCache(t *testing.T) {
+	r := newResults()
+	cache, err := NewCache(r.watch)
+	assert.Nil(t, err)
+	events := make(chan *Event, 10)
+	cache.OnEvent(func(event *Event) { events <- event })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	waited := make(chan []ServiceInstance)
+	go func() {
+		instances, err := cache.WaitInstances(ctx, "svc", HealthyFilter())
+		assert.Nil(t, err)
+		waited <- instances
+	}()
+
+	a, b := node("a", 1), &DefaultServiceInstance{Id: "b", ServiceName: "svc", Enable: true}
+	r.send("create", "svc", b, a)
+	r.send("create", "other", node("x", 1))
+	assert.Equal(t, []ServiceInstance{a}, <-waited)
+	for i := 0; i < 3; i++ {
+		<-events
+	}
+	assert.Equal(t, []ServiceInstance{a, b}, cache.GetInstances("svc"))
+	assert.Equal(t, []string{"other", "svc"}, cache.GetServices())
+
+	r.send("delete", "other")
+	assert.Equal(t, Delete, (<-events).Type)
+	assert.Empty(t, cache.GetInstances("other"))
+	assert.Equal(t, []string{"svc"}, cache.GetServices())
+
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTimeout()
+	_, err = cache.WaitInstances(timeout, "none")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	cache.Stop()
+}
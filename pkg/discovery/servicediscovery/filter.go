@@ -0,0 +1,54 @@
+package servicediscovery
+
+// Filter reports whether an instance should be kept
+type Filter func(instance ServiceInstance) bool
+
+// HealthyFilter keeps the instances that are enabled and healthy
+func HealthyFilter() Filter {
+	return func(instance ServiceInstance) bool {
+		return instance.IsEnable() && instance.IsHealthy()
+	}
+}
+
+// TagFilter keeps the instances carrying all the tags
+func TagFilter(tags ...string) Filter {
+	return func(instance ServiceInstance) bool {
+		for _, tag := range tags {
+			if !HasTag(instance, tag) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// HasTag reports whether the instance carries the tag
+func HasTag(instance ServiceInstance, tag string) bool {
+	for _, t := range instance.GetTags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyFilters returns the instances kept by all the filters
+func ApplyFilters(instances []ServiceInstance, filters ...Filter) []ServiceInstance {
+	if len(filters) == 0 {
+		return instances
+	}
+	result := make([]ServiceInstance, 0, len(instances))
+	for _, instance := range instances {
+		ok := true
+		for _, filter := range filters {
+			if !filter(instance) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			result = append(result, instance)
+		}
+	}
+	return result
+}
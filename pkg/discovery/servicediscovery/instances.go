@@ -0,0 +1,99 @@
+package servicediscovery
+
+import (
+	"sort"
+	"sync"
+)
+
+// Instances is the set of instances of one service, maintained from the results of a Watcher
+type Instances struct {
+	name      string
+	locker    sync.RWMutex
+	instances map[string]ServiceInstance
+}
+
+func NewInstances(name string) *Instances {
+	return &Instances{name: name, instances: make(map[string]ServiceInstance)}
+}
+
+// Apply applies a result of a Watcher and reports whether the set changed.
+// create and update carry all the instances of the service, delete carries the
+// removed instances, or none when the whole service is gone.
+func (s *Instances) Apply(result *Result) bool {
+	if result == nil || result.Service == nil || result.Service.Name != s.name {
+		return false
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	switch result.Action {
+	case "create", "update":
+		instances := make(map[string]ServiceInstance, len(result.Service.Nodes))
+		for _, node := range result.Service.Nodes {
+			instances[node.GetId()] = node
+		}
+		changed := !sameInstances(s.instances, instances)
+		s.instances = instances
+		return changed
+	case "delete":
+		if len(result.Service.Nodes) == 0 {
+			changed := len(s.instances) > 0
+			s.instances = make(map[string]ServiceInstance)
+			return changed
+		}
+		var changed bool
+		for _, node := range result.Service.Nodes {
+			if _, ok := s.instances[node.GetId()]; ok {
+				delete(s.instances, node.GetId())
+				changed = true
+			}
+		}
+		return changed
+	}
+	return false
+}
+
+// List returns the instances kept by the filters, sorted by id
+func (s *Instances) List(filters ...Filter) []ServiceInstance {
+	s.locker.RLock()
+	instances := make([]ServiceInstance, 0, len(s.instances))
+	for _, instance := range s.instances {
+		instances = append(instances, instance)
+	}
+	s.locker.RUnlock()
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].GetId() < instances[j].GetId()
+	})
+	return ApplyFilters(instances, filters...)
+}
+
+func sameInstances(a, b map[string]ServiceInstance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, x := range a {
+		y, ok := b[id]
+		if !ok || !sameInstance(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameInstance(a, b ServiceInstance) bool {
+	if a.GetHost() != b.GetHost() || a.GetPort() != b.GetPort() || a.GetWeight() != b.GetWeight() ||
+		a.IsEnable() != b.IsEnable() || a.IsHealthy() != b.IsHealthy() ||
+		len(a.GetTags()) != len(b.GetTags()) || len(a.GetMetadata()) != len(b.GetMetadata()) {
+		return false
+	}
+	for i, tag := range a.GetTags() {
+		if b.GetTags()[i] != tag {
+			return false
+		}
+	}
+	for k, v := range a.GetMetadata() {
+		if b.GetMetadata()[k] != v {
+			return false
+		}
+	}
+	return true
+}
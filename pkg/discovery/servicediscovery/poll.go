@@ -0,0 +1,148 @@
+package servicediscovery
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Snapshot is the instances of every service, keyed by service name
+type Snapshot map[string][]ServiceInstance
+
+// diffSnapshots returns the instance events turning old into new, sorted by
+// service name and instance id
+func diffSnapshots(old, new Snapshot) []*Event {
+	names := make([]string, 0, len(new))
+	for name := range new {
+		names = append(names, name)
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var events []*Event
+	for _, name := range names {
+		events = append(events, DiffInstances(name, old[name], new[name])...)
+	}
+	return events
+}
+
+func instanceMap(instances []ServiceInstance) map[string]ServiceInstance {
+	m := make(map[string]ServiceInstance, len(instances))
+	for _, instance := range instances {
+		m[instance.GetId()] = instance
+	}
+	return m
+}
+
+func snapshotService(name string, instances []ServiceInstance) *Service {
+	nodes := make([]ServiceInstance, len(instances))
+	copy(nodes, instances)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].GetId() < nodes[j].GetId()
+	})
+	return &Service{Name: name, Nodes: nodes}
+}
+
+// LoadFunc loads the current snapshot of a registry
+type LoadFunc func(ctx context.Context) (Snapshot, error)
+
+// PollWatcher is a Watcher loading snapshots on an interval and emitting the
+// differences as instance events, for registries without change notifications.
+// A failed load keeps the previous snapshot.
+type PollWatcher struct {
+	id       string
+	service  string
+	interval time.Duration
+	load     LoadFunc
+	ctx      context.Context
+	cancel   context.CancelFunc
+	next     chan *Event
+}
+
+// NewPollWatcher starts polling load every interval, id is set on the emitted events
+func NewPollWatcher(id string, interval time.Duration, load LoadFunc, opts ...WatchOption) *PollWatcher {
+	var wo WatchOptions
+	for _, o := range opts {
+		o(&wo)
+	}
+	parent := wo.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	w := &PollWatcher{
+		id:       id,
+		service:  wo.Service,
+		interval: interval,
+		load:     load,
+		ctx:      ctx,
+		cancel:   cancel,
+		next:     make(chan *Event, 10),
+	}
+	go w.run()
+	return w
+}
+
+func (w *PollWatcher) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	current := Snapshot{}
+	for {
+		if snapshot, err := w.load(w.ctx); err == nil {
+			if len(w.service) > 0 {
+				filtered := Snapshot{}
+				if instances, ok := snapshot[w.service]; ok {
+					filtered[w.service] = instances
+				}
+				snapshot = filtered
+			}
+			for _, event := range diffSnapshots(current, snapshot) {
+				event.Id = w.id
+				select {
+				case <-w.ctx.Done():
+					return
+				case w.next <- event:
+				}
+			}
+			current = snapshot
+		}
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// NextEvent blocks until the next instance event
+func (w *PollWatcher) NextEvent() (*Event, error) {
+	select {
+	case <-w.ctx.Done():
+		return nil, errors.New("watcher stopped")
+	case event := <-w.next:
+		return event, nil
+	}
+}
+
+// Next returns the next instance event as a result for Instances.Apply, create
+// and update carry all the instances of the service, delete carries the
+// removed instance
+func (w *PollWatcher) Next() (*Result, error) {
+	event, err := w.NextEvent()
+	if err != nil {
+		return nil, err
+	}
+	service := event.Service
+	if event.Type == Delete {
+		service = &Service{Name: service.Name, Nodes: []ServiceInstance{event.Previous}}
+	}
+	return &Result{Action: event.Type.String(), Service: service}, nil
+}
+
+func (w *PollWatcher) Stop() {
+	w.cancel()
+}
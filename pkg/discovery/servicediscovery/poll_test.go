@@ -0,0 +1,64 @@
+package servicediscovery
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollWatcher(t *testing.T) {
+	var locker sync.Mutex
+	snapshot := Snapshot{"a": {&DefaultServiceInstance{Id: "1", Host: "10.0.0.1"}}}
+	set := func(s Snapshot) {
+		locker.Lock()
+		defer locker.Unlock()
+		snapshot = s
+	}
+	watcher := NewPollWatcher("poll", 10*time.Millisecond, func(ctx context.Context) (Snapshot, error) {
+		locker.Lock()
+		defer locker.Unlock()
+		return snapshot, nil
+	})
+	defer watcher.Stop()
+
+	event, err := watcher.NextEvent()
+	assert.Nil(t, err)
+	assert.Equal(t, "poll", event.Id)
+	assert.Equal(t, Create, event.Type)
+	assert.Equal(t, "1", event.Current.GetId())
+
+	// an event for each changed instance, sorted by service and instance id
+	set(Snapshot{
+		"a": {&DefaultServiceInstance{Id: "1", Host: "10.0.0.2"}, &DefaultServiceInstance{Id: "2", Host: "10.0.0.3"}},
+		"b": {&DefaultServiceInstance{Id: "3", Host: "10.0.0.4"}},
+	})
+	var events []*Event
+	for i := 0; i < 3; i++ {
+		event, err = watcher.NextEvent()
+		assert.Nil(t, err)
+		assert.Equal(t, "poll", event.Id)
+		events = append(events, event)
+	}
+	assert.Equal(t, Update, events[0].Type)
+	assert.Equal(t, "10.0.0.1", events[0].Previous.GetHost())
+	assert.Equal(t, "10.0.0.2", events[0].Current.GetHost())
+	assert.Equal(t, Create, events[1].Type)
+	assert.Equal(t, "2", events[1].Current.GetId())
+	assert.Equal(t, Create, events[2].Type)
+	assert.Equal(t, "b", events[2].Service.Name)
+
+	// the results remove only the deleted instance
+	instances := NewInstances("a")
+	instances.Apply(&Result{Action: events[1].Type.String(), Service: events[1].Service})
+	set(Snapshot{"a": {&DefaultServiceInstance{Id: "2", Host: "10.0.0.3"}}, "b": snapshot["b"]})
+	result, err := watcher.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "delete", result.Action)
+	assert.Equal(t, "1", result.Service.Nodes[0].GetId())
+	assert.True(t, instances.Apply(result))
+	assert.Len(t, instances.List(), 1)
+	assert.Equal(t, "2", instances.List()[0].GetId())
+}
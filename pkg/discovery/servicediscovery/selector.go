@@ -0,0 +1,198 @@
+package servicediscovery
+
+import (
+	"context"
+	"hash/crc32"
+	"math/rand"
+	"strings"
+)
+
+const (
+	// MetadataZone is the metadata key of the zone of an instance, the
+	// cluster name is used when not set
+	MetadataZone = "zone"
+	// MetadataVersion is the metadata key of the version of an instance
+	MetadataVersion = "version"
+)
+
+// The headers overriding the routing of a request, read by RouteFromHeader.
+// gRPC metadata carries them in lower case.
+const (
+	RouteZoneHeader    = "X-Route-Zone"
+	RouteVersionHeader = "X-Route-Version"
+	RouteTagHeader     = "X-Route-Tag"
+	RouteKeyHeader     = "X-Route-Key"
+)
+
+// Route carries the routing overrides of a request
+type Route struct {
+	// Zone replaces the local zone of ZoneSelector
+	Zone string
+	// Version only keeps the instances of the version, bypassing CanarySelector
+	Version string
+	// Tags only keeps the instances carrying all the tags
+	Tags []string
+	// Key makes the canary split sticky, e.g. a user id, random when not set
+	Key string
+}
+
+type routeKey struct{}
+
+// WithRoute returns a context carrying route, the fields set in route
+// override the route already in ctx
+func WithRoute(ctx context.Context, route Route) context.Context {
+	current := RouteFromContext(ctx)
+	if len(route.Zone) > 0 {
+		current.Zone = route.Zone
+	}
+	if len(route.Version) > 0 {
+		current.Version = route.Version
+	}
+	if len(route.Tags) > 0 {
+		current.Tags = append(current.Tags[:len(current.Tags):len(current.Tags)], route.Tags...)
+	}
+	if len(route.Key) > 0 {
+		current.Key = route.Key
+	}
+	return context.WithValue(ctx, routeKey{}, current)
+}
+
+// RouteFromContext returns the route carried by ctx
+func RouteFromContext(ctx context.Context) Route {
+	route, _ := ctx.Value(routeKey{}).(Route)
+	return route
+}
+
+// RouteFromHeader reads the route headers from http.Header or gRPC metadata.MD
+func RouteFromHeader(header map[string][]string) Route {
+	get := func(key string) []string {
+		if values, ok := header[key]; ok {
+			return values
+		}
+		return header[strings.ToLower(key)]
+	}
+	first := func(key string) string {
+		if values := get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	route := Route{Zone: first(RouteZoneHeader), Version: first(RouteVersionHeader), Key: first(RouteKeyHeader)}
+	for _, value := range get(RouteTagHeader) {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); len(tag) > 0 {
+				route.Tags = append(route.Tags, tag)
+			}
+		}
+	}
+	return route
+}
+
+// Selector narrows the instances a request may be sent to, unlike a Filter it
+// sees all the instances and the request context
+type Selector func(ctx context.Context, instances []ServiceInstance) []ServiceInstance
+
+// Select applies the selectors in order
+func Select(ctx context.Context, instances []ServiceInstance, selectors ...Selector) []ServiceInstance {
+	for _, selector := range selectors {
+		instances = selector(ctx, instances)
+	}
+	return instances
+}
+
+// InstanceZone returns the zone metadata of the instance, or its cluster name
+func InstanceZone(instance ServiceInstance) string {
+	if zone, ok := instance.GetMetadata()[MetadataZone]; ok {
+		return zone
+	}
+	return instance.GetClusterName()
+}
+
+// ZoneSelector prefers the instances in zone, or in the zone of the request
+// route. All the instances are kept when fewer than min are in the zone.
+func ZoneSelector(zone string, min int) Selector {
+	if min < 1 {
+		min = 1
+	}
+	return func(ctx context.Context, instances []ServiceInstance) []ServiceInstance {
+		zone := zone
+		if route := RouteFromContext(ctx); len(route.Zone) > 0 {
+			zone = route.Zone
+		}
+		if len(zone) == 0 {
+			return instances
+		}
+		local := ApplyFilters(instances, func(instance ServiceInstance) bool {
+			return InstanceZone(instance) == zone
+		})
+		if len(local) < min {
+			return instances
+		}
+		return local
+	}
+}
+
+// VersionFilter keeps the instances of the version metadata
+func VersionFilter(version string) Filter {
+	return func(instance ServiceInstance) bool {
+		return instance.GetMetadata()[MetadataVersion] == version
+	}
+}
+
+// VersionSelector keeps the instances of version, or of the version of the
+// request route. All the instances are kept when neither is set.
+func VersionSelector(version string) Selector {
+	return func(ctx context.Context, instances []ServiceInstance) []ServiceInstance {
+		version := version
+		if route := RouteFromContext(ctx); len(route.Version) > 0 {
+			version = route.Version
+		}
+		if len(version) == 0 {
+			return instances
+		}
+		return ApplyFilters(instances, VersionFilter(version))
+	}
+}
+
+// CanarySelector sends percent of the requests to the instances of the canary
+// version and the rest to the other instances. Requests with the same route
+// key go to the same side. When one side has no instance the other is used.
+// The route version only keeps the instances of that version.
+func CanarySelector(version string, percent int) Selector {
+	return func(ctx context.Context, instances []ServiceInstance) []ServiceInstance {
+		route := RouteFromContext(ctx)
+		if len(route.Version) > 0 {
+			return ApplyFilters(instances, VersionFilter(route.Version))
+		}
+		var canary, stable []ServiceInstance
+		for _, instance := range instances {
+			if instance.GetMetadata()[MetadataVersion] == version {
+				canary = append(canary, instance)
+			} else {
+				stable = append(stable, instance)
+			}
+		}
+		var bucket int
+		if len(route.Key) > 0 {
+			bucket = int(crc32.ChecksumIEEE([]byte(route.Key)) % 100)
+		} else {
+			bucket = rand.Intn(100)
+		}
+		if (bucket < percent && len(canary) > 0) || len(stable) == 0 {
+			return canary
+		}
+		return stable
+	}
+}
+
+// TagSelector keeps the instances carrying all the tags and the tags of the
+// request route
+func TagSelector(tags ...string) Selector {
+	return func(ctx context.Context, instances []ServiceInstance) []ServiceInstance {
+		required := append(tags[:len(tags):len(tags)], RouteFromContext(ctx).Tags...)
+		if len(required) == 0 {
+			return instances
+		}
+		return ApplyFilters(instances, TagFilter(required...))
+	}
+}
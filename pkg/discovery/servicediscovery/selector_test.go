@@ -0,0 +1,83 @@
+package servicediscovery
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func zoned(id, zone, version string, tags ...string) ServiceInstance {
+	return &DefaultServiceInstance{Id: id, Tags: tags, Metadata: map[string]string{MetadataZone: zone, MetadataVersion: version}}
+}
+
+func ids(instances []ServiceInstance) []string {
+	result := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		result = append(result, instance.GetId())
+	}
+	return result
+}
+
+func TestRoute(t *testing.T) {
+	header := http.Header{}
+	header.Set(RouteZoneHeader, "b")
+	header.Add(RouteTagHeader, "x, y")
+	header.Add(RouteTagHeader, "z")
+	assert.Equal(t, Route{Zone: "b", Tags: []string{"x", "y", "z"}}, RouteFromHeader(header))
+	// grpc metadata keys are lower case
+	assert.Equal(t, Route{Version: "v2", Key: "user"}, RouteFromHeader(map[string][]string{"x-route-version": {"v2"}, "x-route-key": {"user"}}))
+
+	ctx := WithRoute(context.Background(), Route{Zone: "a", Key: "user"})
+	ctx = WithRoute(ctx, Route{Zone: "b", Tags: []string{"x"}})
+	assert.Equal(t, Route{Zone: "b", Tags: []string{"x"}, Key: "user"}, RouteFromContext(ctx))
+}
+
+func TestZoneSelector(t *testing.T) {
+	instances := []ServiceInstance{zoned("1", "a", ""), zoned("2", "b", ""), zoned("3", "b", ""),
+		&DefaultServiceInstance{Id: "4", ClusterName: "a"}}
+	ctx := context.Background()
+	assert.Equal(t, []string{"1", "4"}, ids(ZoneSelector("a", 1)(ctx, instances)))
+	// fewer than min in the zone falls back to all
+	assert.Equal(t, []string{"1", "2", "3", "4"}, ids(ZoneSelector("a", 3)(ctx, instances)))
+	assert.Equal(t, []string{"1", "2", "3", "4"}, ids(ZoneSelector("c", 1)(ctx, instances)))
+	assert.Equal(t, []string{"2", "3"}, ids(ZoneSelector("a", 1)(WithRoute(ctx, Route{Zone: "b"}), instances)))
+}
+
+func TestCanarySelector(t *testing.T) {
+	instances := []ServiceInstance{zoned("1", "", "v1"), zoned("2", "", "v2"), zoned("3", "", "")}
+	selector := CanarySelector("v2", 20)
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		if ids(selector(context.Background(), instances))[0] == "2" {
+			canary++
+		}
+	}
+	assert.InDelta(t, 200, canary, 60)
+
+	// the same key always goes to the same side
+	ctx := WithRoute(context.Background(), Route{Key: "user-1"})
+	first := ids(selector(ctx, instances))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, ids(selector(ctx, instances)))
+	}
+
+	assert.Equal(t, []string{"1"}, ids(selector(WithRoute(context.Background(), Route{Version: "v1"}), instances)))
+	assert.Equal(t, []string{"2"}, ids(CanarySelector("v2", 0)(context.Background(), instances[1:2])))
+	// one side without instances falls back to the other
+	assert.Equal(t, []string{"1", "2", "3"}, ids(CanarySelector("v3", 100)(context.Background(), instances)))
+}
+
+func TestVersionAndTagSelector(t *testing.T) {
+	instances := []ServiceInstance{zoned("1", "", "v1", "x"), zoned("2", "", "v2", "x", "y")}
+	ctx := context.Background()
+	assert.Equal(t, []string{"1", "2"}, ids(VersionSelector("")(ctx, instances)))
+	assert.Equal(t, []string{"2"}, ids(VersionSelector("v2")(ctx, instances)))
+	assert.Equal(t, []string{"1"}, ids(VersionSelector("v2")(WithRoute(ctx, Route{Version: "v1"}), instances)))
+	assert.Empty(t, ids(VersionSelector("v3")(ctx, instances)))
+
+	assert.Equal(t, []string{"1", "2"}, ids(TagSelector("x")(ctx, instances)))
+	assert.Equal(t, []string{"2"}, ids(TagSelector("x")(WithRoute(ctx, Route{Tags: []string{"y"}}), instances)))
+	assert.Equal(t, []string{"2"}, ids(Select(ctx, instances, TagSelector(), VersionSelector("v2"))))
+}
@@ -13,6 +13,9 @@ type Watcher interface {
 
 type WatchOption func(*WatchOptions)
 
+// WatchFunc creates a Watcher, e.g. consul.Client.Watch
+type WatchFunc func(opts ...WatchOption) (Watcher, error)
+
 type Result struct {
 	Action  string
 	Service *Service
@@ -63,4 +66,22 @@ type Event struct {
 	Timestamp time.Time
 	// Service is registry service
 	Service *Service
+	// Previous is the instance before the change, nil for Create and service events
+	Previous ServiceInstance
+	// Current is the instance after the change, nil for Delete and service events
+	Current ServiceInstance
+}
+
+// WatchService only watches the named service
+func WatchService(name string) WatchOption {
+	return func(o *WatchOptions) {
+		o.Service = name
+	}
+}
+
+// WatchContext sets the context carrying implementation specific options
+func WatchContext(ctx context.Context) WatchOption {
+	return func(o *WatchOptions) {
+		o.Context = ctx
+	}
 }
@@ -0,0 +1,165 @@
+package election
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// Consul elects the leader by acquiring a key with a consul session, the key
+// holds the id of the leader. The session is renewed every ttl/2 and is
+// destroyed when the campaign or the leadership ends, consul then releases the
+// key.
+type Consul struct {
+	*elector
+	client *consulApi.Client
+	key    string
+
+	locker  sync.Mutex
+	session string
+	// closed when the session expires
+	expired chan struct{}
+	// closing destroys the session
+	destroy chan struct{}
+}
+
+var _ Elector = (*Consul)(nil)
+
+func NewConsul(client *consulApi.Client, key string, opts ...Option) *Consul {
+	c := &Consul{client: client, key: key}
+	c.elector = newElector(&consulBackend{c}, newOptions(opts...))
+	return c
+}
+
+func (c *Consul) Leader() (string, error) {
+	pair, _, err := c.client.KV().Get(c.key, nil)
+	if err != nil {
+		return "", err
+	}
+	if pair == nil || len(pair.Session) == 0 {
+		return "", nil
+	}
+	return string(pair.Value), nil
+}
+
+type consulBackend struct {
+	*Consul
+}
+
+func (c *consulBackend) acquire(ctx context.Context) (bool, error) {
+	session, err := c.createSession(ctx)
+	if err != nil {
+		return false, err
+	}
+	pair := &consulApi.KVPair{Key: c.key, Value: []byte(c.id), Session: session}
+	ok, _, err := c.client.KV().Acquire(pair, (&consulApi.WriteOptions{}).WithContext(ctx))
+	return ok, err
+}
+
+// createSession returns the session of the campaign, a new one when it has expired
+func (c *consulBackend) createSession(ctx context.Context) (string, error) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if len(c.session) > 0 {
+		select {
+		case <-c.expired:
+		default:
+			return c.session, nil
+		}
+	}
+	ttl := c.ttl.String()
+	session, _, err := c.client.Session().Create(&consulApi.SessionEntry{
+		Name:     "election:" + c.key,
+		TTL:      ttl,
+		Behavior: consulApi.SessionBehaviorRelease,
+	}, (&consulApi.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	expired, destroy := make(chan struct{}), make(chan struct{})
+	c.session, c.expired, c.destroy = session, expired, destroy
+	go func() {
+		defer close(expired)
+		_ = c.client.Session().RenewPeriodic(ttl, session, nil, destroy)
+	}()
+	return session, nil
+}
+
+// wait blocks while another session holds the key, or for the retry
+// interval when the key is free but cannot be acquired, e.g. during the
+// consul lock delay
+func (c *consulBackend) wait(ctx context.Context) {
+	var index uint64
+	for ctx.Err() == nil {
+		opts := (&consulApi.QueryOptions{WaitIndex: index, WaitTime: c.ttl}).WithContext(ctx)
+		pair, meta, err := c.client.KV().Get(c.key, opts)
+		if err != nil {
+			sleep(ctx, c.retryInterval)
+			return
+		}
+		if pair == nil || len(pair.Session) == 0 {
+			if index == 0 {
+				sleep(ctx, c.retryInterval)
+			}
+			return
+		}
+		if pair.Session == c.currentSession() {
+			return
+		}
+		index = meta.LastIndex
+	}
+}
+
+// keep watches the key until another session holds it or the session expires
+func (c *consulBackend) keep(ctx context.Context) {
+	c.locker.Lock()
+	session, expired := c.session, c.expired
+	c.locker.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-expired:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	var index uint64
+	for ctx.Err() == nil {
+		opts := (&consulApi.QueryOptions{WaitIndex: index, WaitTime: c.ttl}).WithContext(ctx)
+		pair, meta, err := c.client.KV().Get(c.key, opts)
+		if err != nil {
+			sleep(ctx, c.retryInterval)
+			continue
+		}
+		if pair == nil || pair.Session != session {
+			return
+		}
+		index = meta.LastIndex
+	}
+}
+
+func (c *consulBackend) currentSession() string {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.session
+}
+
+// release releases the key and destroys the session
+func (c *consulBackend) release() {
+	c.locker.Lock()
+	session, expired, destroy := c.session, c.expired, c.destroy
+	c.session, c.expired, c.destroy = "", nil, nil
+	c.locker.Unlock()
+	if len(session) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.retryInterval+5*time.Second)
+	defer cancel()
+	pair := &consulApi.KVPair{Key: c.key, Value: []byte(c.id), Session: session}
+	_, _, _ = c.client.KV().Release(pair, (&consulApi.WriteOptions{}).WithContext(ctx))
+	close(destroy)
+	<-expired
+}
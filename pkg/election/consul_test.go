@@ -0,0 +1,126 @@
+package election
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// agent is a fake consul agent serving sessions and one lock key
+type agent struct {
+	locker   sync.Mutex
+	changed  chan struct{}
+	index    uint64
+	sessions map[string]bool
+	next     int
+	pair     *consulApi.KVPair
+}
+
+func (a *agent) bump() {
+	a.index++
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// steal gives the key to another session
+func (a *agent) steal() {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	a.sessions["other"] = true
+	a.pair = &consulApi.KVPair{Key: "leader", Value: []byte("other"), Session: "other"}
+	a.bump()
+}
+
+func (a *agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	query := r.URL.Query()
+	switch path := r.URL.Path; {
+	case path == "/v1/session/create":
+		a.next++
+		id := fmt.Sprintf("session-%d", a.next)
+		a.sessions[id] = true
+		_ = json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(path, "/v1/session/renew/"):
+		id := strings.TrimPrefix(path, "/v1/session/renew/")
+		if !a.sessions[id] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]*consulApi.SessionEntry{{ID: id, TTL: "150ms"}})
+	case strings.HasPrefix(path, "/v1/session/destroy/"):
+		id := strings.TrimPrefix(path, "/v1/session/destroy/")
+		delete(a.sessions, id)
+		if a.pair != nil && a.pair.Session == id {
+			a.pair.Session = ""
+			a.bump()
+		}
+		_, _ = w.Write([]byte("true"))
+	case r.Method == http.MethodPut && query.Has("acquire"):
+		id := query.Get("acquire")
+		if !a.sessions[id] {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("invalid session"))
+			return
+		}
+		if a.pair != nil && len(a.pair.Session) > 0 && a.pair.Session != id {
+			_, _ = w.Write([]byte("false"))
+			return
+		}
+		value := make([]byte, r.ContentLength)
+		_, _ = r.Body.Read(value)
+		a.pair = &consulApi.KVPair{Key: "leader", Value: value, Session: id}
+		a.bump()
+		_, _ = w.Write([]byte("true"))
+	case r.Method == http.MethodPut && query.Has("release"):
+		if a.pair != nil && a.pair.Session == query.Get("release") {
+			a.pair.Session = ""
+			a.bump()
+		}
+		_, _ = w.Write([]byte("true"))
+	case r.Method == http.MethodGet:
+		if query.Get("index") == strconv.FormatUint(a.index, 10) {
+			changed := a.changed
+			a.locker.Unlock()
+			select {
+			case <-changed:
+			case <-time.After(200 * time.Millisecond):
+			case <-r.Context().Done():
+			}
+			a.locker.Lock()
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(a.index, 10))
+		if a.pair == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(consulApi.KVPairs{a.pair})
+	}
+}
+
+func TestConsul(t *testing.T) {
+	a := &agent{changed: make(chan struct{}), index: 1, sessions: make(map[string]bool)}
+	server := httptest.NewServer(a)
+	defer server.Close()
+	client, err := consulApi.NewClient(&consulApi.Config{Address: server.URL})
+	assert.Nil(t, err)
+
+	opts := []Option{WithTTL(150 * time.Millisecond), WithRetryInterval(10 * time.Millisecond)}
+	ea := NewConsul(client, "leader", append(opts, WithId("a"))...)
+	eb := NewConsul(client, "leader", append(opts, WithId("b"))...)
+	testElectors(t, ea, eb, a.steal)
+
+	// the sessions of the campaigns are destroyed
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	assert.Equal(t, map[string]bool{"other": true}, a.sessions)
+}
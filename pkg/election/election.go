@@ -0,0 +1,215 @@
+// Package election elects one leader among the processes campaigning for the
+// same key, e.g. to keep exactly one scheduler active:
+//
+//	elector := election.NewConsul(consulClient.ApiClient(), "election/scheduler")
+//	for {
+//		if err := elector.Campaign(ctx); err != nil {
+//			return err // ctx done
+//		}
+//		runScheduler(ctx, elector.Changes())
+//	}
+//
+// A leader keeps its leadership until Resign is called or it cannot renew the
+// lease, Changes then receives false and Campaign may be called again.
+package election
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrResigned is returned by Campaign when Resign is called while campaigning
+	ErrResigned = errors.New("election: resigned")
+	// ErrCampaigning is returned by Campaign when another campaign is running
+	ErrCampaigning = errors.New("election: campaign in progress")
+)
+
+type Elector interface {
+	// Campaign blocks until this elector is the leader, returns nil when
+	// elected or the error of ctx when ctx is done first. ctx only bounds the
+	// campaign, the leadership is renewed in the background.
+	Campaign(ctx context.Context) error
+	// Resign gives up the leadership or stops campaigning
+	Resign() error
+	// IsLeader tells whether this elector is the leader
+	IsLeader() bool
+	// Leader returns the id of the current leader, "" when there is none
+	Leader() (string, error)
+	// Changes receives true when elected and false when the leadership is
+	// lost or resigned. Only the latest change is kept for a slow receiver.
+	Changes() <-chan bool
+	// Id returns the id of this elector
+	Id() string
+}
+
+// state is the leadership state shared by the electors
+type state struct {
+	locker  sync.Mutex
+	leader  bool
+	changes chan bool
+}
+
+func newState() state {
+	return state{changes: make(chan bool, 1)}
+}
+
+func (s *state) IsLeader() bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.leader
+}
+
+func (s *state) Changes() <-chan bool {
+	return s.changes
+}
+
+// set changes the leadership, false when it is unchanged
+func (s *state) set(leader bool) bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.leader == leader {
+		return false
+	}
+	s.leader = leader
+	// replace the change not received yet
+	select {
+	case <-s.changes:
+	default:
+	}
+	s.changes <- leader
+	return true
+}
+
+// backend takes and keeps the leadership in a store
+type backend interface {
+	// acquire tries once to take the leadership
+	acquire(ctx context.Context) (bool, error)
+	// wait returns when the leadership may be free or ctx is done
+	wait(ctx context.Context)
+	// keep renews the leadership, returns when it is lost or ctx is done
+	keep(ctx context.Context)
+	// release gives up the leadership and the resources of the campaign
+	release()
+}
+
+// elector runs the campaigns of a backend
+type elector struct {
+	state
+	options
+	backend backend
+
+	running sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newElector(b backend, o options) *elector {
+	return &elector{state: newState(), options: o, backend: b}
+}
+
+func (e *elector) Id() string {
+	return e.id
+}
+
+func (e *elector) Campaign(ctx context.Context) error {
+	e.running.Lock()
+	if e.cancel != nil {
+		e.running.Unlock()
+		if e.IsLeader() {
+			return nil
+		}
+		return ErrCampaigning
+	}
+	// lease lives until Resign or the leadership is lost
+	lease, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	e.cancel, e.done = cancel, done
+	e.running.Unlock()
+
+	campaign, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		select {
+		case <-lease.Done():
+			stop()
+		case <-campaign.Done():
+		}
+	}()
+
+	for {
+		ok, err := e.backend.acquire(campaign)
+		if ok {
+			e.set(true)
+			if e.logger != nil {
+				e.logger.Infof("%s elected", e.id)
+			}
+			go e.keep(lease, cancel, done)
+			return nil
+		}
+		if err != nil && campaign.Err() == nil && e.logger != nil {
+			e.logger.Warningf("%s campaign: %s", e.id, err.Error())
+		}
+		if campaign.Err() == nil {
+			e.backend.wait(campaign)
+		}
+		if campaign.Err() != nil {
+			resigned := lease.Err() != nil
+			e.backend.release()
+			e.running.Lock()
+			e.clear(done)
+			e.running.Unlock()
+			cancel()
+			close(done)
+			if resigned {
+				return ErrResigned
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func (e *elector) keep(lease context.Context, cancel context.CancelFunc, done chan struct{}) {
+	defer close(done)
+	e.backend.keep(lease)
+	e.backend.release()
+	if lease.Err() == nil && e.logger != nil {
+		e.logger.Warningf("%s lost the leadership", e.id)
+	}
+	// a new campaign starts after the leadership is lost
+	e.running.Lock()
+	e.clear(done)
+	e.set(false)
+	e.running.Unlock()
+	cancel()
+}
+
+// clear forgets the campaign of done, the caller holds running
+func (e *elector) clear(done chan struct{}) {
+	if e.done == done {
+		e.cancel, e.done = nil, nil
+	}
+}
+
+func (e *elector) Resign() error {
+	e.running.Lock()
+	cancel, done := e.cancel, e.done
+	e.running.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	return nil
+}
+
+// sleep waits for d, false when ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,66 @@
+package election
+
+import (
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/utils/uuid"
+)
+
+type options struct {
+	id            string
+	ttl           time.Duration
+	retryInterval time.Duration
+	logger        glog.ILoggerEntry
+}
+
+func newOptions(opts ...Option) options {
+	o := options{
+		id:            uuid.NewUUID(),
+		ttl:           15 * time.Second,
+		retryInterval: time.Second,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Option for electors
+type Option func(*options)
+
+// WithId set the id of the elector, default a random uuid
+func WithId(id string) Option {
+	return func(o *options) {
+		if len(id) > 0 {
+			o.id = id
+		}
+	}
+}
+
+// WithTTL set the lease of the leadership, a leader failing to renew it for
+// ttl loses the leadership, default 15 seconds. The consul ttl is at least 10 seconds.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		if ttl > 0 {
+			o.ttl = ttl
+		}
+	}
+}
+
+// WithRetryInterval set the interval of trying to acquire the leadership and
+// retrying failed requests, default 1 second
+func WithRetryInterval(interval time.Duration) Option {
+	return func(o *options) {
+		if interval > 0 {
+			o.retryInterval = interval
+		}
+	}
+}
+
+// WithLogger set logger function
+func WithLogger(logger glog.ILogger) Option {
+	return func(o *options) {
+		o.logger = logger.WithField("Election", "Election")
+	}
+}
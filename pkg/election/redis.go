@@ -0,0 +1,118 @@
+package election
+
+import (
+	"context"
+	"time"
+
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis elects the leader by a lock key holding the id of the leader, the
+// leader renews the lock every ttl/3
+type Redis struct {
+	*elector
+	client cache.ICache
+	key    string
+}
+
+var _ Elector = (*Redis)(nil)
+
+func NewRedis(client cache.ICache, key string, opts ...Option) *Redis {
+	r := &Redis{client: client, key: key}
+	r.elector = newElector(&redisBackend{Redis: r}, newOptions(opts...))
+	return r
+}
+
+func (r *Redis) Leader() (string, error) {
+	leader, err := r.client.GetString(r.key)
+	if err == redis.Nil {
+		return "", nil
+	}
+	return leader, err
+}
+
+type redisBackend struct {
+	*Redis
+	// acquired is when the last successful acquire was sent
+	acquired time.Time
+}
+
+func (r *redisBackend) acquire(ctx context.Context) (bool, error) {
+	// through a pipeline to tell a failure from a lock held by another elector
+	start := time.Now()
+	pipe := r.client.Pipeline()
+	set := pipe.SetNX(ctx, r.key, r.id, r.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	if set.Val() {
+		r.acquired = start
+	}
+	return set.Val(), nil
+}
+
+func (r *redisBackend) wait(ctx context.Context) {
+	sleep(ctx, r.retryInterval)
+}
+
+// keep renews the lock every ttl/3. The lock expires at the latest ttl after
+// the last successful renew was sent, the leadership is given up a margin of
+// ttl/10 before that as redis may count from a later time.
+func (r *redisBackend) keep(ctx context.Context) {
+	renewed := r.acquired
+	for {
+		deadline := renewed.Add(r.ttl - r.ttl/10)
+		interval := r.ttl / 3
+		if until := time.Until(deadline); until < interval {
+			interval = until
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+		if !time.Now().Before(deadline) {
+			if r.logger != nil {
+				r.logger.Warningf("%s steps down, the lock is not renewed since %s", r.id, renewed.Format(time.RFC3339Nano))
+			}
+			return
+		}
+		start := time.Now()
+		ok, err := r.renew(ctx, deadline)
+		if err != nil {
+			if r.logger != nil {
+				r.logger.Warningf("%s renew: %s", r.id, err.Error())
+			}
+			continue
+		}
+		if !ok {
+			return
+		}
+		renewed = start
+	}
+}
+
+// renewScript extends the lock only while it holds the id of the elector
+const renewScript = `if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('pexpire', KEYS[1], ARGV[2]) else return 0 end`
+
+// renew extends the lock, false when it has expired or another elector holds
+// it. The check and the extension run atomically, an expired lock is not taken
+// again as another elector may have been elected meanwhile. It waits ttl/3 for
+// redis, and not after deadline.
+func (r *redisBackend) renew(ctx context.Context, deadline time.Time) (bool, error) {
+	if timeout := time.Now().Add(r.ttl / 3); timeout.Before(deadline) {
+		deadline = timeout
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	pipe := r.client.Pipeline()
+	extended := pipe.Eval(ctx, renewScript, []string{r.key}, r.id, r.ttl.Milliseconds())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	n, err := extended.Int64()
+	return n == 1, err
+}
+
+func (r *redisBackend) release() {
+	r.client.ReleaseLock(r.key, r.id)
+}
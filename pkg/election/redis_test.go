@@ -0,0 +1,177 @@
+package election
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/redis"
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRedis starts a miniredis whose clock follows the wall clock, miniredis
+// only expires keys when its clock is moved forward
+func newRedis(t *testing.T) (cache.ICache, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				server.FastForward(10 * time.Millisecond)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	port, _ := strconv.Atoi(server.Port())
+	return redis.New(redis.WithAddr(server.Host()), redis.WithPort(port), redis.WithLogger(glog.New())), server
+}
+
+func TestRedis(t *testing.T) {
+	client, server := newRedis(t)
+	opts := []Option{WithTTL(150 * time.Millisecond), WithRetryInterval(10 * time.Millisecond)}
+	a := NewRedis(client, "leader", append(opts, WithId("a"))...)
+	b := NewRedis(client, "leader", append(opts, WithId("b"))...)
+	testElectors(t, a, b, func() {
+		server.Set("leader", "other")
+		server.SetTTL("leader", time.Minute)
+	})
+}
+
+func TestRedisRenewAfterExpiry(t *testing.T) {
+	client, server := newRedis(t)
+	a := NewRedis(client, "leader", WithId("a"), WithTTL(time.Minute), WithRetryInterval(10*time.Millisecond))
+	ctx := context.Background()
+	assert.Nil(t, a.Campaign(ctx))
+	assert.True(t, <-a.Changes())
+	defer a.Resign()
+
+	// the lock expires and another elector takes it before a renews
+	server.FastForward(time.Minute)
+	assert.False(t, server.Exists("leader"))
+	assert.True(t, client.SetNX("leader", "b", time.Minute))
+	backend := a.backend.(*redisBackend)
+	deadline := time.Now().Add(time.Minute)
+	ok, err := backend.renew(ctx, deadline)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	value, _ := server.Get("leader")
+	assert.Equal(t, "b", value)
+
+	// an expired lock is lost, not taken again
+	server.Del("leader")
+	ok, err = backend.renew(ctx, deadline)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.False(t, server.Exists("leader"))
+
+	// renewing a held lock extends it
+	assert.Nil(t, server.Set("leader", "a"))
+	server.SetTTL("leader", time.Second)
+	ok, err = backend.renew(ctx, deadline)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Greater(t, server.TTL("leader"), 50*time.Second)
+
+	// the errors of redis are returned
+	server.Close()
+	ok, err = backend.acquire(ctx)
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+	_, err = backend.renew(ctx, deadline)
+	assert.NotNil(t, err)
+}
+
+func TestRedisStepDown(t *testing.T) {
+	client, server := newRedis(t)
+	ttl := 300 * time.Millisecond
+	a := NewRedis(client, "leader", WithId("a"), WithTTL(ttl), WithRetryInterval(10*time.Millisecond))
+	backend := a.backend.(*redisBackend)
+	ok, err := backend.acquire(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// renews fail, the leadership is given up before the lock expires
+	server.Close()
+	done := make(chan struct{})
+	go func() {
+		backend.keep(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+		assert.Less(t, time.Since(backend.acquired), ttl)
+	case <-time.After(2 * ttl):
+		t.Fatal("expected keep to return before the lock expires")
+	}
+}
+
+// testElectors campaigns a and b for the same key, steal gives the key to another elector
+func testElectors(t *testing.T, a, b Elector, steal func()) {
+	ctx := context.Background()
+	leader, err := a.Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, "", leader)
+
+	assert.Nil(t, a.Campaign(ctx))
+	assert.True(t, <-a.Changes())
+	assert.True(t, a.IsLeader())
+	assert.Nil(t, a.Campaign(ctx))
+
+	// b waits while a renews the leadership for several ttls
+	elected := make(chan error)
+	go func() { elected <- b.Campaign(ctx) }()
+	time.Sleep(400 * time.Millisecond)
+	assert.Len(t, elected, 0)
+	assert.Equal(t, ErrCampaigning, b.Campaign(ctx))
+	assert.False(t, b.IsLeader())
+	leader, err = b.Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, "a", leader)
+
+	assert.Nil(t, a.Resign())
+	assert.False(t, <-a.Changes())
+	assert.False(t, a.IsLeader())
+	select {
+	case err = <-elected:
+		assert.Nil(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("b not elected")
+	}
+	assert.True(t, <-b.Changes())
+	leader, _ = a.Leader()
+	assert.Equal(t, "b", leader)
+
+	// a campaign ends with its context or Resign
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, a.Campaign(timeout))
+	go func() { elected <- a.Campaign(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, a.Resign())
+	assert.Equal(t, ErrResigned, <-elected)
+
+	// b loses the leadership when another elector takes the key
+	steal()
+	select {
+	case leader := <-b.Changes():
+		assert.False(t, leader)
+	case <-time.After(2 * time.Second):
+		t.Fatal("b still leader")
+	}
+	assert.False(t, b.IsLeader())
+	assert.Nil(t, b.Resign())
+}
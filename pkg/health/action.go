@@ -0,0 +1,84 @@
+package health
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/donetkit/contrib/server"
+)
+
+// Registrar is implemented by discovery.Discovery
+type Registrar interface {
+	Register() error
+	Deregister() error
+}
+
+// DeregisterAction deregisters the service when a probe goes down and
+// registers it again once both probes are up
+func DeregisterAction(registrar Registrar) ChangeFunc {
+	var registered = true
+	return func(m *Manager, event Event) {
+		switch {
+		case event.Current == StatusDown && registered:
+			if err := registrar.Deregister(); err == nil {
+				registered = false
+			} else if m.logger != nil {
+				m.logger.Error("deregister on health down error: " + err.Error())
+			}
+		case event.Current == StatusUp && !registered && m.Healthy():
+			if err := registrar.Register(); err == nil {
+				registered = true
+			} else if m.logger != nil {
+				m.logger.Error("register on health up error: " + err.Error())
+			}
+		}
+	}
+}
+
+// Maintainer marks a registered service critical, e.g. consul maintenance mode
+type Maintainer interface {
+	EnableMaintenance(reason string) error
+	DisableMaintenance() error
+}
+
+// CriticalAction marks the service critical while a probe is down, it stays
+// registered but out of rotation
+func CriticalAction(maintainer Maintainer) ChangeFunc {
+	var critical bool
+	return func(m *Manager, event Event) {
+		switch {
+		case event.Current == StatusDown && !critical:
+			reason := event.Probe.String() + " down"
+			if event.Err != nil {
+				reason += ": " + event.Err.Error()
+			}
+			if err := maintainer.EnableMaintenance(reason); err == nil {
+				critical = true
+			} else if m.logger != nil {
+				m.logger.Error("mark critical on health down error: " + err.Error())
+			}
+		case event.Current == StatusUp && critical && m.Healthy():
+			if err := maintainer.DisableMaintenance(); err == nil {
+				critical = false
+			} else if m.logger != nil {
+				m.logger.Error("unmark critical on health up error: " + err.Error())
+			}
+		}
+	}
+}
+
+// ShutdownAction gracefully shuts the service down, like on SIGTERM, when liveness goes down
+func ShutdownAction(service server.IService) ChangeFunc {
+	var once sync.Once
+	return func(m *Manager, event Event) {
+		if event.Probe != Liveness || event.Current != StatusDown {
+			return
+		}
+		once.Do(func() {
+			go func() {
+				service.StopNotify(syscall.SIGTERM)
+				service.Shutdown()
+			}()
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Checker checks one dependency or part of the process
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a func to a Checker
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Pinger is implemented by *sql.DB, use gormDB.DB() for gorm
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// PingChecker pings a database
+func PingChecker(db Pinger) Checker {
+	return CheckerFunc(db.PingContext)
+}
+
+// RedisChecker pings a redis server
+func RedisChecker(client redis.UniversalClient) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+}
+
+// TCPChecker connects to addr
+func TCPChecker(addr string) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// HTTPChecker gets url and expects a 2xx status
+func HTTPChecker(url string) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s: unexpected status %d", url, resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+// GRPCChecker calls grpc.health.v1.Health/Check of service on target and
+// expects SERVING. target may carry the service as host:port/service.
+func GRPCChecker(target string, service ...string) Checker {
+	var name string
+	if len(service) > 0 {
+		name = service[0]
+	} else if i := strings.Index(target, "/"); i >= 0 {
+		target, name = target[:i], target[i+1:]
+	}
+	return CheckerFunc(func(ctx context.Context) error {
+		conn, err := grpc.DialContext(ctx, target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: name})
+		if err != nil {
+			return err
+		}
+		if resp.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+			return fmt.Errorf("%s: %s", target, resp.GetStatus())
+		}
+		return nil
+	})
+}
+
+// ErrStale is returned by HeartbeatChecker when no heartbeat came within maxAge
+var ErrStale = errors.New("health: heartbeat is stale")
+
+// HeartbeatChecker fails when last, the time of the latest heartbeat, is
+// older than maxAge. A zero time counts from the creation of the checker.
+func HeartbeatChecker(last func() time.Time, maxAge time.Duration) Checker {
+	created := time.Now()
+	return CheckerFunc(func(ctx context.Context) error {
+		beat := last()
+		if beat.IsZero() {
+			beat = created
+		}
+		if time.Since(beat) > maxAge {
+			return ErrStale
+		}
+		return nil
+	})
+}
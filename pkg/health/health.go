@@ -0,0 +1,339 @@
+// Package health tracks the liveness and readiness of a process from
+// pluggable checkers and acts on state changes, e.g.
+//
+//	manager := health.New(health.WithInterval(5*time.Second), health.WithFailureThreshold(3))
+//	manager.AddReadiness("mysql", health.PingChecker(sqlDB))
+//	manager.AddReadiness("redis", health.RedisChecker(redisClient))
+//	manager.OnChange(health.DeregisterAction(consulClient))
+//	manager.AddLiveness("deadlock", health.CheckerFunc(checkWorkers))
+//	manager.OnChange(health.ShutdownAction(server))
+//	manager.Start()
+package health
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+)
+
+// Status is the state of a probe or a checker
+type Status int
+
+const (
+	// StatusUnknown is the state before the first checks
+	StatusUnknown Status = iota
+	// StatusUp passes the checks
+	StatusUp
+	// StatusDown failed the checks FailureThreshold times in a row
+	StatusDown
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusUp:
+		return "up"
+	case StatusDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// Probe is the kind of a check
+type Probe int
+
+const (
+	// Liveness tells whether the process works at all, a process down should be restarted
+	Liveness Probe = iota
+	// Readiness tells whether the process can take traffic, a process down should be taken out of rotation
+	Readiness
+)
+
+func (p Probe) String() string {
+	if p == Liveness {
+		return "liveness"
+	}
+	return "readiness"
+}
+
+// Event is a state change of a probe
+type Event struct {
+	Probe    Probe
+	Previous Status
+	Current  Status
+	// Err is the error of the failing checker when Current is StatusDown
+	Err error
+}
+
+// ChangeFunc is called on state changes, in order and never concurrently
+type ChangeFunc func(m *Manager, event Event)
+
+// Result is the latest result of a checker
+type Result struct {
+	Name      string
+	Probe     Probe
+	Status    Status
+	Err       error
+	Failures  int
+	CheckedAt time.Time
+}
+
+type check struct {
+	name    string
+	probe   Probe
+	checker Checker
+	result  Result
+}
+
+type Manager struct {
+	interval         time.Duration
+	initialDelay     time.Duration
+	timeout          time.Duration
+	failureThreshold int
+	logger           glog.ILoggerEntry
+
+	locker    sync.RWMutex
+	checks    []*check
+	status    map[Probe]Status
+	callbacks []ChangeFunc
+
+	// serializes the checks and the callbacks
+	running sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func New(opts ...Option) *Manager {
+	m := &Manager{
+		interval:         10 * time.Second,
+		timeout:          3 * time.Second,
+		failureThreshold: 3,
+		status:           map[Probe]Status{Liveness: StatusUnknown, Readiness: StatusUnknown},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// AddLiveness adds a liveness checker
+func (m *Manager) AddLiveness(name string, checker Checker) *Manager {
+	return m.add(name, Liveness, checker)
+}
+
+// AddReadiness adds a readiness checker
+func (m *Manager) AddReadiness(name string, checker Checker) *Manager {
+	return m.add(name, Readiness, checker)
+}
+
+func (m *Manager) add(name string, probe Probe, checker Checker) *Manager {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.checks = append(m.checks, &check{name: name, probe: probe, checker: checker, result: Result{Name: name, Probe: probe}})
+	return m
+}
+
+// OnChange adds a callback called when a probe changes state
+func (m *Manager) OnChange(fn ChangeFunc) *Manager {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.callbacks = append(m.callbacks, fn)
+	return m
+}
+
+// Start runs the checks every interval in the background after the initial delay
+func (m *Manager) Start() {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if m.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel, m.done = cancel, make(chan struct{})
+	go m.run(ctx, m.done)
+}
+
+// Stop stops the background checks
+func (m *Manager) Stop() {
+	m.locker.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.locker.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (m *Manager) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	if m.initialDelay > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.initialDelay):
+		}
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs every checker once, updates the probes and calls the callbacks of the changes
+func (m *Manager) Check(ctx context.Context) {
+	m.running.Lock()
+	defer m.running.Unlock()
+
+	m.locker.RLock()
+	checks := make([]*check, len(m.checks))
+	copy(checks, m.checks)
+	m.locker.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, m.timeout)
+			defer cancel()
+			results[i] = Result{Err: c.checker.Check(cctx), CheckedAt: time.Now()}
+		}(i, c)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.locker.Lock()
+	for i, c := range checks {
+		r := &c.result
+		r.Err, r.CheckedAt = results[i].Err, results[i].CheckedAt
+		if r.Err == nil {
+			r.Failures = 0
+			r.Status = StatusUp
+		} else {
+			r.Failures++
+			if r.Failures >= m.failureThreshold {
+				r.Status = StatusDown
+			}
+		}
+	}
+	var events []Event
+	for _, probe := range []Probe{Liveness, Readiness} {
+		current, err := m.probeStatus(probe)
+		if previous := m.status[probe]; current != previous {
+			m.status[probe] = current
+			events = append(events, Event{Probe: probe, Previous: previous, Current: current, Err: err})
+		}
+	}
+	callbacks := make([]ChangeFunc, len(m.callbacks))
+	copy(callbacks, m.callbacks)
+	m.locker.Unlock()
+
+	for _, event := range events {
+		if m.logger != nil {
+			if event.Err != nil {
+				m.logger.Warningf("%s %s -> %s: %s", event.Probe, event.Previous, event.Current, event.Err.Error())
+			} else {
+				m.logger.Infof("%s %s -> %s", event.Probe, event.Previous, event.Current)
+			}
+		}
+		for _, fn := range callbacks {
+			fn(m, event)
+		}
+	}
+}
+
+// probeStatus is down when a checker is down, up when all checkers are up,
+// unchanged otherwise. A probe without checkers is up.
+func (m *Manager) probeStatus(probe Probe) (Status, error) {
+	status := StatusUp
+	for _, c := range m.checks {
+		if c.probe != probe {
+			continue
+		}
+		switch c.result.Status {
+		case StatusDown:
+			return StatusDown, c.result.Err
+		case StatusUnknown:
+			status = StatusUnknown
+		}
+	}
+	if status == StatusUnknown {
+		return m.status[probe], nil
+	}
+	return status, nil
+}
+
+// Liveness returns the liveness status
+func (m *Manager) Liveness() Status {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return m.status[Liveness]
+}
+
+// Readiness returns the readiness status
+func (m *Manager) Readiness() Status {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return m.status[Readiness]
+}
+
+// Healthy reports whether both probes are up
+func (m *Manager) Healthy() bool {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return m.status[Liveness] == StatusUp && m.status[Readiness] == StatusUp
+}
+
+// Results returns the latest results of the checkers sorted by probe and name
+func (m *Manager) Results() []Result {
+	m.locker.RLock()
+	results := make([]Result, 0, len(m.checks))
+	for _, c := range m.checks {
+		results = append(results, c.result)
+	}
+	m.locker.RUnlock()
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Probe != results[j].Probe {
+			return results[i].Probe < results[j].Probe
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// LivenessHandler answers 200 while liveness is not down, 503 otherwise
+func (m *Manager) LivenessHandler() http.Handler {
+	return m.handler(Liveness)
+}
+
+// ReadinessHandler answers 200 while readiness is up, 503 otherwise
+func (m *Manager) ReadinessHandler() http.Handler {
+	return m.handler(Readiness)
+}
+
+func (m *Manager) handler(probe Probe) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.locker.RLock()
+		status := m.status[probe]
+		m.locker.RUnlock()
+		ok := status == StatusUp || (probe == Liveness && status == StatusUnknown)
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte(status.String()))
+	})
+}
@@ -0,0 +1,174 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// toggle is a checker failing while down is set
+type toggle struct {
+	down atomic.Bool
+}
+
+func (t *toggle) Check(ctx context.Context) error {
+	if t.down.Load() {
+		return errors.New("down")
+	}
+	return nil
+}
+
+type registrar struct {
+	calls []string
+}
+
+func (r *registrar) Register() error {
+	r.calls = append(r.calls, "register")
+	return nil
+}
+
+func (r *registrar) Deregister() error {
+	r.calls = append(r.calls, "deregister")
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	db, worker := &toggle{}, &toggle{}
+	reg := &registrar{}
+	var events []Event
+	m := New(WithFailureThreshold(2)).
+		AddReadiness("db", db).
+		AddLiveness("worker", worker).
+		OnChange(func(m *Manager, event Event) { events = append(events, event) }).
+		OnChange(DeregisterAction(reg))
+	ctx := context.Background()
+
+	m.Check(ctx)
+	assert.True(t, m.Healthy())
+	assert.Equal(t, []Event{
+		{Probe: Liveness, Previous: StatusUnknown, Current: StatusUp},
+		{Probe: Readiness, Previous: StatusUnknown, Current: StatusUp},
+	}, events)
+	events = nil
+
+	// one failure is below the threshold
+	db.down.Store(true)
+	m.Check(ctx)
+	assert.Equal(t, StatusUp, m.Readiness())
+	m.Check(ctx)
+	assert.Equal(t, StatusDown, m.Readiness())
+	assert.Equal(t, StatusUp, m.Liveness())
+	assert.Len(t, events, 1)
+	assert.EqualError(t, events[0].Err, "down")
+	assert.Equal(t, []string{"deregister"}, reg.calls)
+
+	results := m.Results()
+	assert.Equal(t, "worker", results[0].Name)
+	assert.Equal(t, "db", results[1].Name)
+	assert.Equal(t, 2, results[1].Failures)
+
+	// registered again only once both probes are up
+	worker.down.Store(true)
+	m.Check(ctx)
+	m.Check(ctx)
+	assert.Equal(t, StatusDown, m.Liveness())
+	db.down.Store(false)
+	m.Check(ctx)
+	assert.Equal(t, []string{"deregister"}, reg.calls)
+	worker.down.Store(false)
+	m.Check(ctx)
+	assert.True(t, m.Healthy())
+	assert.Equal(t, []string{"deregister", "register"}, reg.calls)
+}
+
+func TestHandlers(t *testing.T) {
+	db := &toggle{}
+	m := New(WithFailureThreshold(1)).AddReadiness("db", db)
+	get := func(h http.Handler) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+	// live but not ready before the first checks
+	assert.Equal(t, http.StatusOK, get(m.LivenessHandler()))
+	assert.Equal(t, http.StatusServiceUnavailable, get(m.ReadinessHandler()))
+	m.Check(context.Background())
+	assert.Equal(t, http.StatusOK, get(m.ReadinessHandler()))
+	db.down.Store(true)
+	m.Check(context.Background())
+	assert.Equal(t, http.StatusServiceUnavailable, get(m.ReadinessHandler()))
+}
+
+type service struct {
+	stopped  chan os.Signal
+	shutdown chan struct{}
+}
+
+func (s *service) Run()                     {}
+func (s *service) SetRunMode(mode string)   {}
+func (s *service) StopNotify(sig os.Signal) { s.stopped <- sig }
+func (s *service) Shutdown()                { close(s.shutdown) }
+
+func TestShutdownAction(t *testing.T) {
+	worker := &toggle{}
+	svc := &service{stopped: make(chan os.Signal, 1), shutdown: make(chan struct{})}
+	m := New(WithInterval(10*time.Millisecond), WithFailureThreshold(1)).
+		AddLiveness("worker", worker).
+		AddReadiness("db", CheckerFunc(func(ctx context.Context) error { return errors.New("readiness does not shut down") })).
+		OnChange(ShutdownAction(svc))
+	m.Start()
+	defer m.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, svc.stopped, 0)
+	worker.down.Store(true)
+	select {
+	case <-svc.shutdown:
+	case <-time.After(time.Second):
+		t.Fatal("not shut down")
+	}
+	assert.NotNil(t, <-svc.stopped)
+}
+
+func TestCheckers(t *testing.T) {
+	ctx := context.Background()
+	var beat time.Time
+	var locker sync.Mutex
+	heartbeat := HeartbeatChecker(func() time.Time {
+		locker.Lock()
+		defer locker.Unlock()
+		return beat
+	}, 50*time.Millisecond)
+	assert.Nil(t, heartbeat.Check(ctx))
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, ErrStale, heartbeat.Check(ctx))
+	locker.Lock()
+	beat = time.Now()
+	locker.Unlock()
+	assert.Nil(t, heartbeat.Check(ctx))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := lis.Addr().String()
+	assert.Nil(t, TCPChecker(addr).Check(ctx))
+	lis.Close()
+	assert.NotNil(t, TCPChecker(addr).Check(ctx))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	assert.Nil(t, HTTPChecker(server.URL+"/ok").Check(ctx))
+	assert.NotNil(t, HTTPChecker(server.URL+"/fail").Check(ctx))
+}
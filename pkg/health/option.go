@@ -0,0 +1,51 @@
+package health
+
+import (
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+)
+
+// Option for health manager
+type Option func(*Manager)
+
+// WithInterval set the interval of checks, default 10 seconds
+func WithInterval(interval time.Duration) Option {
+	return func(m *Manager) {
+		if interval > 0 {
+			m.interval = interval
+		}
+	}
+}
+
+// WithInitialDelay set the delay before the first checks, default 0
+func WithInitialDelay(delay time.Duration) Option {
+	return func(m *Manager) {
+		m.initialDelay = delay
+	}
+}
+
+// WithTimeout set the timeout of one check, default 3 seconds
+func WithTimeout(timeout time.Duration) Option {
+	return func(m *Manager) {
+		if timeout > 0 {
+			m.timeout = timeout
+		}
+	}
+}
+
+// WithFailureThreshold set how many failures in a row turn a checker down, default 3
+func WithFailureThreshold(threshold int) Option {
+	return func(m *Manager) {
+		if threshold > 0 {
+			m.failureThreshold = threshold
+		}
+	}
+}
+
+// WithLogger set logger function
+func WithLogger(logger glog.ILogger) Option {
+	return func(m *Manager) {
+		m.logger = logger.WithField("Health", "Health")
+	}
+}
@@ -0,0 +1,217 @@
+package pubsub // import "github.com/docker/docker/pkg/pubsub"
+
+import (
+	"context"
+	"strings"
+)
+
+// SubscribeContext adds a new subscriber that filters messages sent by a
+// topic. The subscriber is evicted when ctx is done.
+func (p *Publisher) SubscribeContext(ctx context.Context, topic topicFunc) chan interface{} {
+	ch := p.SubscribeTopic(topic)
+	p.watch(ctx, ch)
+	return ch
+}
+
+// SubscribePatternContext adds a new subscriber that receives the messages
+// sent to topics matching the pattern. The subscriber is evicted when ctx is
+// done.
+func (p *Publisher) SubscribePatternContext(ctx context.Context, pattern string) (chan interface{}, error) {
+	ch, err := p.SubscribePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p.watch(ctx, ch)
+	return ch, nil
+}
+
+// watch evicts sub when ctx is done. The watcher stops when sub is evicted
+// first.
+func (p *Publisher) watch(ctx context.Context, sub subscriber) {
+	stop := make(chan struct{})
+	p.m.Lock()
+	p.stops[sub] = stop
+	p.m.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Evict(sub)
+		case <-stop:
+		}
+	}()
+}
+
+// unwatch stops the watcher of sub, the caller holds the write lock.
+func (p *Publisher) unwatch(sub subscriber) {
+	if stop, ok := p.stops[sub]; ok {
+		delete(p.stops, sub)
+		close(stop)
+	}
+}
+
+// PublishAsync sends the data in v to all subscribers without waiting for
+// them. Each subscriber has its own queue drained by one goroutine, so a slow
+// subscriber only delays itself and receives the messages in the order they
+// were published. Subscribers evicted before their turn are skipped.
+func (p *Publisher) PublishAsync(v interface{}) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if p.shutdown {
+		return
+	}
+	for sub, topic := range p.subscribers {
+		p.enqueue(sub, topic, v)
+	}
+}
+
+// PublishTopicAsync is the asynchronous version of PublishTopic, the order
+// is preserved per subscriber as with PublishAsync.
+func (p *Publisher) PublishTopicAsync(topic string, v interface{}) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if p.shutdown {
+		return
+	}
+	matched := make(map[subscriber]struct{})
+	p.topics.match(strings.Split(topic, topicSeparator), matched)
+	for sub := range matched {
+		p.enqueue(sub, nil, v)
+	}
+	for sub, filter := range p.subscribers {
+		p.enqueue(sub, filter, v)
+	}
+}
+
+type asyncMessage struct {
+	topic topicFunc
+	value interface{}
+}
+
+// asyncQueue holds the asynchronous publishes not yet sent to a subscriber.
+type asyncQueue struct {
+	messages []asyncMessage
+}
+
+// enqueue adds v to the queue of sub and starts its sender when idle. A
+// running sender counts as one publish in flight until the queue is empty.
+func (p *Publisher) enqueue(sub subscriber, topic topicFunc, v interface{}) {
+	p.queuesM.Lock()
+	defer p.queuesM.Unlock()
+	q, ok := p.queues[sub]
+	if !ok {
+		q = &asyncQueue{}
+		p.queues[sub] = q
+		p.inflight.Add(1)
+		go p.drain(sub, q)
+	}
+	q.messages = append(q.messages, asyncMessage{topic: topic, value: v})
+}
+
+// drain sends the queued messages to sub in order and removes the queue once
+// it is empty.
+func (p *Publisher) drain(sub subscriber, q *asyncQueue) {
+	defer p.inflight.Done()
+	for {
+		p.queuesM.Lock()
+		if len(q.messages) == 0 {
+			delete(p.queues, sub)
+			p.queuesM.Unlock()
+			return
+		}
+		msg := q.messages[0]
+		q.messages[0] = asyncMessage{}
+		q.messages = q.messages[1:]
+		p.queuesM.Unlock()
+		p.sendAsync(sub, msg.topic, msg.value)
+	}
+}
+
+func (p *Publisher) sendAsync(sub subscriber, topic topicFunc, v interface{}) {
+	// hold the read lock while sending so the channel is not closed meanwhile
+	p.m.RLock()
+	defer p.m.RUnlock()
+	_, ok := p.subscribers[sub]
+	if !ok {
+		_, ok = p.patterns[sub]
+	}
+	if ok {
+		p.send(sub, topic, v)
+	}
+}
+
+// Shutdown stops accepting asynchronous publishes, waits for the ones in
+// flight to be delivered until ctx is done, then closes the channels to all
+// subscribers. It returns ctx.Err() when the deadline expired before all
+// messages were delivered; the undelivered messages are dropped.
+func (p *Publisher) Shutdown(ctx context.Context) error {
+	p.m.Lock()
+	p.shutdown = true
+	p.m.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	p.Close()
+	return err
+}
+
+// Iterator consumes a subscription in a loop:
+//
+//	it := p.Iterate(ctx, nil)
+//	for it.Next() {
+//		v := it.Value()
+//	}
+//	err := it.Err()
+type Iterator struct {
+	ctx   context.Context
+	ch    chan interface{}
+	value interface{}
+	done  bool
+	err   error
+}
+
+// Iterate adds a new subscriber that filters messages sent by a topic and
+// returns an iterator over its messages. The subscriber is evicted when ctx
+// is done.
+func (p *Publisher) Iterate(ctx context.Context, topic topicFunc) *Iterator {
+	return &Iterator{ctx: ctx, ch: p.SubscribeContext(ctx, topic)}
+}
+
+// Next waits for the next message and reports whether there is one. It
+// returns false when ctx is done or the subscriber is evicted.
+func (it *Iterator) Next() bool {
+	if it.done {
+		return false
+	}
+	select {
+	case v, ok := <-it.ch:
+		if ok {
+			it.value = v
+			return true
+		}
+	case <-it.ctx.Done():
+	}
+	it.done = true
+	it.value = nil
+	it.err = it.ctx.Err()
+	return false
+}
+
+// Value returns the message read by the last call to Next.
+func (it *Iterator) Value() interface{} {
+	return it.value
+}
+
+// Err returns why the iteration stopped: ctx.Err() when the context is done,
+// nil when the subscriber was evicted or the publisher closed.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,197 @@
+package pubsub // import "github.com/docker/docker/pkg/pubsub"
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscribeContext(t *testing.T) {
+	p := NewPublisher(100*time.Millisecond, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := p.SubscribeContext(ctx, nil)
+	pattern, _ := p.SubscribePatternContext(ctx, "a/#")
+
+	p.PublishTopic("a/b", "hi")
+	if msg := <-c; msg.(string) != "hi" {
+		t.Fatalf("expected message hi but received %v", msg)
+	}
+	if msg := <-pattern; msg.(string) != "hi" {
+		t.Fatalf("expected message hi but received %v", msg)
+	}
+
+	cancel()
+	for _, ch := range []chan interface{}{c, pattern} {
+		select {
+		case _, ok := <-ch:
+			if ok {
+				t.Fatal("expected the subscriber channel to be closed")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the subscriber to be evicted")
+		}
+	}
+	if p.Len() != 0 {
+		t.Fatalf("expected no subscribers but found %d", p.Len())
+	}
+}
+
+func TestSubscribeContextEvictedFirst(t *testing.T) {
+	p := NewPublisher(100*time.Millisecond, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := p.SubscribeContext(ctx, nil)
+	p.Evict(c)
+	if len(p.stops) != 0 {
+		t.Fatal("expected the watcher to be stopped")
+	}
+}
+
+func TestPublishAsync(t *testing.T) {
+	p := NewPublisher(time.Second, 0)
+	slow := p.Subscribe()
+	fast := p.Subscribe()
+
+	start := time.Now()
+	p.PublishAsync("hi")
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("expected PublishAsync to not wait for subscribers")
+	}
+	if msg := <-fast; msg.(string) != "hi" {
+		t.Fatalf("expected message hi but received %v", msg)
+	}
+	if msg := <-slow; msg.(string) != "hi" {
+		t.Fatalf("expected message hi but received %v", msg)
+	}
+}
+
+func TestPublishAsyncOrder(t *testing.T) {
+	p := NewPublisher(time.Second, 0)
+	c := p.Subscribe()
+	pattern, _ := p.SubscribePattern("a/#")
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			p.PublishAsync(i)
+		} else {
+			p.PublishTopicAsync("a/b", i)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if msg := <-c; msg.(int) != i {
+			t.Fatalf("expected message %d but received %v", i, msg)
+		}
+	}
+	for i := 1; i < 100; i += 2 {
+		if msg := <-pattern; msg.(int) != i {
+			t.Fatalf("expected message %d but received %v", i, msg)
+		}
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	p := NewPublisher(time.Second, 0)
+	c := p.Subscribe()
+	p.PublishAsync("hi")
+
+	received := make(chan interface{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		received <- <-c
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-received; msg.(string) != "hi" {
+		t.Fatalf("expected message hi but received %v", msg)
+	}
+	if _, ok := <-c; ok {
+		t.Fatal("expected the subscriber channel to be closed")
+	}
+	// publishes after shutdown are dropped
+	p.PublishAsync("late")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	p := NewPublisher(time.Second, 0)
+	c := p.Subscribe()
+	p.PublishAsync("hi")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded but received %v", err)
+	}
+	if _, ok := <-c; ok {
+		t.Fatal("expected the subscriber channel to be closed")
+	}
+}
+
+func TestIterate(t *testing.T) {
+	p := NewPublisher(100*time.Millisecond, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	it := p.Iterate(ctx, nil)
+	defer cancel()
+	p.Publish("a")
+	p.Publish("b")
+
+	var got []string
+	for it.Next() {
+		got = append(got, it.Value().(string))
+		if len(got) == 2 {
+			cancel()
+		}
+	}
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("expected [a b] but received %v", got)
+	}
+	if it.Err() != context.Canceled {
+		t.Fatalf("expected context canceled but received %v", it.Err())
+	}
+
+	it = p.Iterate(context.Background(), nil)
+	p.Close()
+	if it.Next() || it.Err() != nil {
+		t.Fatal("expected the iteration to end without error")
+	}
+}
+
+// for testing with -race
+func TestPublishDuringEvictRace(t *testing.T) {
+	p := NewPublisher(time.Millisecond, 1)
+	var wg sync.WaitGroup
+	for j := 0; j < 4; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				p.Publish(sampleText)
+				p.PublishAsync(sampleText)
+				p.PublishTopic("a/b", sampleText)
+				p.PublishTopicAsync("a/b", sampleText)
+			}
+		}()
+	}
+	for j := 0; j < 4; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				c := p.SubscribeContext(ctx, nil)
+				pattern, _ := p.SubscribePattern("a/+")
+				p.Evict(pattern)
+				cancel()
+				p.Evict(c)
+			}
+		}()
+	}
+	wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,7 @@
 package pubsub // import "github.com/docker/docker/pkg/pubsub"
 
 import (
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,6 +17,10 @@ func NewPublisher(publishTimeout time.Duration, buffer int) *Publisher {
 		buffer:      buffer,
 		timeout:     publishTimeout,
 		subscribers: make(map[subscriber]topicFunc),
+		patterns:    make(map[subscriber]string),
+		topics:      newTopicNode(),
+		stops:       make(map[subscriber]chan struct{}),
+		queues:      make(map[subscriber]*asyncQueue),
 	}
 }
 
@@ -29,12 +34,22 @@ type Publisher struct {
 	buffer      int
 	timeout     time.Duration
 	subscribers map[subscriber]topicFunc
+	patterns    map[subscriber]string
+	topics      *topicNode
+	// closed when a context subscriber is evicted, stops its watcher
+	stops map[subscriber]chan struct{}
+	// asynchronous publishes in flight, waited for by Shutdown
+	inflight sync.WaitGroup
+	shutdown bool
+	// pending asynchronous publishes per subscriber, guarded by queuesM
+	queuesM sync.Mutex
+	queues  map[subscriber]*asyncQueue
 }
 
 // Len returns the number of subscribers for the publisher
 func (p *Publisher) Len() int {
 	p.m.RLock()
-	i := len(p.subscribers)
+	i := len(p.subscribers) + len(p.patterns)
 	p.m.RUnlock()
 	return i
 }
@@ -63,18 +78,44 @@ func (p *Publisher) SubscribeTopicWithBuffer(topic topicFunc, buffer int) chan i
 	return ch
 }
 
+// SubscribePattern adds a new subscriber that receives the messages sent with
+// PublishTopic to topics matching the MQTT-style pattern, e.g. "a/+/c" or "a/#".
+func (p *Publisher) SubscribePattern(pattern string) (chan interface{}, error) {
+	return p.SubscribePatternWithBuffer(pattern, p.buffer)
+}
+
+// SubscribePatternWithBuffer adds a new subscriber that receives the messages
+// sent to topics matching the pattern.
+// The returned channel has a buffer of the specified size.
+func (p *Publisher) SubscribePatternWithBuffer(pattern string, buffer int) (chan interface{}, error) {
+	if err := ValidatePattern(pattern); err != nil {
+		return nil, err
+	}
+	ch := make(chan interface{}, buffer)
+	p.m.Lock()
+	p.patterns[ch] = pattern
+	p.topics.add(pattern, ch)
+	p.m.Unlock()
+	return ch, nil
+}
+
 // Evict removes the specified subscriber from receiving any more messages.
 func (p *Publisher) Evict(sub chan interface{}) {
 	p.m.Lock()
-	_, exists := p.subscribers[sub]
-	if exists {
+	if _, exists := p.subscribers[sub]; exists {
 		delete(p.subscribers, sub)
 		close(sub)
+	} else if pattern, exists := p.patterns[sub]; exists {
+		delete(p.patterns, sub)
+		p.topics.remove(strings.Split(pattern, topicSeparator), sub)
+		close(sub)
 	}
+	p.unwatch(sub)
 	p.m.Unlock()
 }
 
 // Publish sends the data in v to all subscribers currently registered with the publisher.
+// Pattern subscribers only receive messages sent with PublishTopic.
 func (p *Publisher) Publish(v interface{}) {
 	p.m.RLock()
 	if len(p.subscribers) == 0 {
@@ -92,6 +133,33 @@ func (p *Publisher) Publish(v interface{}) {
 	p.m.RUnlock()
 }
 
+// PublishTopic sends the data in v to the subscribers whose pattern matches
+// topic, and to the subscribers registered with Subscribe or SubscribeTopic
+// whose filter accepts v. Pattern subscribers not matching topic are not
+// visited.
+func (p *Publisher) PublishTopic(topic string, v interface{}) {
+	p.m.RLock()
+	matched := make(map[subscriber]struct{})
+	p.topics.match(strings.Split(topic, topicSeparator), matched)
+	if len(matched) == 0 && len(p.subscribers) == 0 {
+		p.m.RUnlock()
+		return
+	}
+
+	wg := wgPool.Get().(*sync.WaitGroup)
+	for sub := range matched {
+		wg.Add(1)
+		go p.sendTopic(sub, nil, v, wg)
+	}
+	for sub, topic := range p.subscribers {
+		wg.Add(1)
+		go p.sendTopic(sub, topic, v, wg)
+	}
+	wg.Wait()
+	wgPool.Put(wg)
+	p.m.RUnlock()
+}
+
 // Close closes the channels to all subscribers registered with the publisher.
 func (p *Publisher) Close() {
 	p.m.Lock()
@@ -99,11 +167,23 @@ func (p *Publisher) Close() {
 		delete(p.subscribers, sub)
 		close(sub)
 	}
+	for sub := range p.patterns {
+		delete(p.patterns, sub)
+		close(sub)
+	}
+	p.topics = newTopicNode()
+	for sub := range p.stops {
+		p.unwatch(sub)
+	}
 	p.m.Unlock()
 }
 
 func (p *Publisher) sendTopic(sub subscriber, topic topicFunc, v interface{}, wg *sync.WaitGroup) {
 	defer wg.Done()
+	p.send(sub, topic, v)
+}
+
+func (p *Publisher) send(sub subscriber, topic topicFunc, v interface{}) {
 	if topic != nil && !topic(v) {
 		return
 	}
@@ -0,0 +1,117 @@
+// Package redisbridge connects the in-process pubsub.Publisher of several
+// replicas through Redis, so local subscribers receive the events published
+// on every replica.
+package redisbridge
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/pkg/pubsub"
+	"github.com/donetkit/contrib/utils/uuid"
+)
+
+// Bridge mirrors the messages published through it to the other nodes and
+// republishes the messages of the other nodes on the local publisher.
+type Bridge struct {
+	local     *pubsub.Publisher
+	transport Transport
+	nodeId    string
+	codec     Codec
+	logger    glog.ILoggerEntry
+}
+
+// New creates a bridge for the local publisher. Only the messages published
+// through Bridge.Publish and Bridge.PublishTopic are sent to the other nodes,
+// messages published directly on local stay on this node.
+func New(local *pubsub.Publisher, transport Transport, opts ...Option) *Bridge {
+	b := &Bridge{
+		local:     local,
+		transport: transport,
+		nodeId:    uuid.NewUUID(),
+		codec:     StringCodec{},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// NodeID returns the id of this node.
+func (b *Bridge) NodeID() string {
+	return b.nodeId
+}
+
+// Publish publishes v on the local publisher and sends it to the other nodes.
+func (b *Bridge) Publish(ctx context.Context, v interface{}) error {
+	b.local.Publish(v)
+	return b.send(ctx, "", v)
+}
+
+// PublishTopic publishes v to topic on the local publisher and sends it to
+// the other nodes, which republish it to the same topic.
+func (b *Bridge) PublishTopic(ctx context.Context, topic string, v interface{}) error {
+	b.local.PublishTopic(topic, v)
+	return b.send(ctx, topic, v)
+}
+
+func (b *Bridge) send(ctx context.Context, topic string, v interface{}) error {
+	payload, err := b.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&envelope{Origin: b.nodeId, Topic: topic, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return b.transport.Send(ctx, data)
+}
+
+// Run receives the messages of the other nodes and republishes them locally,
+// blocking until ctx is done. The transport is reconnected when it fails.
+func (b *Bridge) Run(ctx context.Context) error {
+	for {
+		err := b.transport.Receive(ctx, b.receive)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err != nil && b.logger != nil {
+			b.logger.Errorf("receive failed: %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (b *Bridge) receive(data []byte) {
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		if b.logger != nil {
+			b.logger.Warningf("invalid message: %s", err.Error())
+		}
+		return
+	}
+	if e.Origin == b.nodeId {
+		// already published locally
+		return
+	}
+	v, err := b.codec.Decode(e.Payload)
+	if err != nil {
+		if b.logger != nil {
+			b.logger.Warningf("decode message from %s failed: %s", e.Origin, err.Error())
+		}
+		return
+	}
+	if len(e.Topic) > 0 {
+		b.local.PublishTopic(e.Topic, v)
+		return
+	}
+	b.local.Publish(v)
+}
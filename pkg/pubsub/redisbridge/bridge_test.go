@@ -0,0 +1,133 @@
+package redisbridge
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/pubsub"
+)
+
+// hub is an in-memory transport delivering every message to every receiver.
+type hub struct {
+	mu        sync.Mutex
+	receivers []chan []byte
+}
+
+func (h *hub) Send(ctx context.Context, data []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ch := range h.receivers {
+		ch <- data
+	}
+	return nil
+}
+
+func (h *hub) Receive(ctx context.Context, fn func(data []byte)) error {
+	ch := make(chan []byte, 16)
+	h.mu.Lock()
+	h.receivers = append(h.receivers, ch)
+	h.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case data := <-ch:
+			fn(data)
+		}
+	}
+}
+
+func (h *hub) ready(n int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.receivers) == n
+}
+
+type event struct {
+	Name string `json:"name"`
+}
+
+func startNode(t *testing.T, h *hub, opts ...Option) (*Bridge, *pubsub.Publisher) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	local := pubsub.NewPublisher(100*time.Millisecond, 10)
+	b := New(local, h, opts...)
+	go b.Run(ctx)
+	return b, local
+}
+
+func receive(t *testing.T, ch chan interface{}) interface{} {
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("expected a message")
+	}
+	return nil
+}
+
+func expectNone(t *testing.T, ch chan interface{}) {
+	select {
+	case v := <-ch:
+		t.Fatalf("expected no message but received %v", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBridgeFanOut(t *testing.T) {
+	h := &hub{}
+	a, localA := startNode(t, h, WithNodeID("a"))
+	_, localB := startNode(t, h, WithNodeID("b"))
+	for !h.ready(2) {
+		time.Sleep(time.Millisecond)
+	}
+	subA := localA.Subscribe()
+	subB := localB.Subscribe()
+
+	if err := a.Publish(context.Background(), "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(t, subA); v != "hi" {
+		t.Fatalf("expected hi but received %v", v)
+	}
+	if v := receive(t, subB); v != "hi" {
+		t.Fatalf("expected hi but received %v", v)
+	}
+	// the origin does not republish its own message
+	expectNone(t, subA)
+}
+
+func TestBridgeTopicAndCodec(t *testing.T) {
+	h := &hub{}
+	a, _ := startNode(t, h, WithCodec(JSONCodec[event]{}))
+	_, localB := startNode(t, h, WithCodec(JSONCodec[event]{}))
+	for !h.ready(2) {
+		time.Sleep(time.Millisecond)
+	}
+	if a.NodeID() == "" {
+		t.Fatal("expected a generated node id")
+	}
+	devices, _ := localB.SubscribePattern("devices/#")
+	other, _ := localB.SubscribePattern("users/#")
+
+	if err := a.PublishTopic(context.Background(), "devices/1/state", event{Name: "on"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(t, devices); v.(event).Name != "on" {
+		t.Fatalf("expected event on but received %v", v)
+	}
+	expectNone(t, other)
+}
+
+func TestStringCodec(t *testing.T) {
+	codec := StringCodec{}
+	if _, err := codec.Encode(1); err == nil {
+		t.Fatal("expected an error encoding an int")
+	}
+	data, _ := codec.Encode([]byte("hi"))
+	if v, _ := codec.Decode(data); v != "hi" {
+		t.Fatalf("expected hi but received %v", v)
+	}
+}
@@ -0,0 +1,53 @@
+package redisbridge
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Codec converts the values published on the local publisher to bytes sent
+// between nodes, and back.
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// StringCodec publishes string and []byte values as text, decoded as string.
+type StringCodec struct{}
+
+func (StringCodec) Encode(v interface{}) ([]byte, error) {
+	switch s := v.(type) {
+	case string:
+		return []byte(s), nil
+	case []byte:
+		return s, nil
+	}
+	return nil, fmt.Errorf("redisbridge: cannot encode %T as string", v)
+}
+
+func (StringCodec) Decode(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+// JSONCodec encodes values as JSON and decodes them as T, so local
+// subscribers receive the same type on every node.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (interface{}, error) {
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// envelope is what is sent between nodes.
+type envelope struct {
+	Origin  string `json:"origin"`
+	Topic   string `json:"topic,omitempty"`
+	Payload []byte `json:"payload"`
+}
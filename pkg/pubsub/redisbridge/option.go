@@ -0,0 +1,32 @@
+package redisbridge
+
+import (
+	"github.com/donetkit/contrib-log/glog"
+)
+
+// Option for bridge
+type Option func(*Bridge)
+
+// WithNodeID set the id of this node, messages sent by it are not
+// republished when they come back. Defaults to a random id.
+func WithNodeID(id string) Option {
+	return func(b *Bridge) {
+		if len(id) > 0 {
+			b.nodeId = id
+		}
+	}
+}
+
+// WithCodec set the codec of published values. Defaults to StringCodec.
+func WithCodec(codec Codec) Option {
+	return func(b *Bridge) {
+		b.codec = codec
+	}
+}
+
+// WithLogger set logger function
+func WithLogger(logger glog.ILogger) Option {
+	return func(b *Bridge) {
+		b.logger = logger.WithField("PubSub-Bridge", "PubSub-Bridge")
+	}
+}
@@ -0,0 +1,147 @@
+package redisbridge
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/go-redis/redis/v8"
+)
+
+// Transport carries encoded messages between the nodes of a bridge.
+type Transport interface {
+	// Send delivers data to every node, including the sender.
+	Send(ctx context.Context, data []byte) error
+	// Receive calls fn for every message until ctx is done.
+	Receive(ctx context.Context, fn func(data []byte)) error
+}
+
+// ChannelTransport uses Redis Pub/Sub. Messages published while a node is
+// disconnected are lost.
+type ChannelTransport struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+func NewChannelTransport(client redis.UniversalClient, channel string) *ChannelTransport {
+	return &ChannelTransport{client: client, channel: channel}
+}
+
+func (t *ChannelTransport) Send(ctx context.Context, data []byte) error {
+	return t.client.Publish(ctx, t.channel, data).Err()
+}
+
+func (t *ChannelTransport) Receive(ctx context.Context, fn func(data []byte)) error {
+	sub := t.client.Subscribe(ctx, t.channel)
+	defer sub.Close()
+	// wait for the subscription to be confirmed so no message is missed after
+	// Receive has been started
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			fn([]byte(msg.Payload))
+		}
+	}
+}
+
+const streamField = "data"
+
+// ErrSendFailed is returned when the stream transport fails to add a message.
+var ErrSendFailed = errors.New("redisbridge: send failed")
+
+// StreamTransport uses a Redis stream. Every node reads the whole stream, and
+// a node that loses its connection resumes after the last message it read as
+// long as the stream still holds it.
+type StreamTransport struct {
+	client    cache.ICache
+	stream    string
+	maxLength int64
+	startId   string
+	block     time.Duration
+	// the id of the last message read, kept across reconnections
+	lastId string
+}
+
+// NewStreamTransport creates a stream transport. The stream is trimmed to
+// about maxLength messages, zero keeps every message.
+func NewStreamTransport(client cache.ICache, stream string, maxLength int64) *StreamTransport {
+	return &StreamTransport{
+		client:    client,
+		stream:    stream,
+		maxLength: maxLength,
+		startId:   "$",
+		block:     time.Second,
+	}
+}
+
+// StartFrom sets the id reading starts after, "$" (the default) only reads
+// new messages and "0" replays the whole stream.
+func (t *StreamTransport) StartFrom(id string) *StreamTransport {
+	t.startId = id
+	return t
+}
+
+func (t *StreamTransport) Send(ctx context.Context, data []byte) error {
+	id := t.client.WithContext(ctx).XAddKey(t.stream, "", t.maxLength > 0, t.maxLength, streamField, string(data))
+	if len(id) == 0 {
+		return ErrSendFailed
+	}
+	return nil
+}
+
+// Receive reads the stream after the last message read and returns the first
+// Redis error, so Bridge.Run reconnects after a pause. It must not be called
+// concurrently.
+func (t *StreamTransport) Receive(ctx context.Context, fn func(data []byte)) error {
+	if len(t.lastId) == 0 {
+		t.lastId = t.startId
+	}
+	if t.lastId == "$" {
+		// resolve "$" once, otherwise messages added between two reads are skipped
+		pipe := t.client.Pipeline()
+		last := pipe.XRevRangeN(ctx, t.stream, "+", "-", 1)
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return err
+		}
+		t.lastId = "0"
+		if msgs := last.Val(); len(msgs) > 0 {
+			t.lastId = msgs[0].ID
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		// read through a pipeline to tell a failure from a block timeout, the
+		// block time stays below the default read timeout of the client
+		pipe := t.client.Pipeline()
+		read := pipe.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{t.stream, t.lastId},
+			Count:   100,
+			Block:   t.block,
+		})
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return err
+		}
+		for _, stream := range read.Val() {
+			for _, msg := range stream.Messages {
+				t.lastId = msg.ID
+				if value, ok := msg.Values[streamField].(string); ok {
+					fn([]byte(value))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package redisbridge
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/donetkit/contrib/db/redis"
+	"github.com/donetkit/contrib/pkg/pubsub"
+)
+
+func TestStreamTransportReconnect(t *testing.T) {
+	server := miniredis.RunT(t)
+	addr := server.Addr()
+	port, _ := strconv.Atoi(server.Port())
+	client := redis.New(redis.WithAddr(server.Host()), redis.WithPort(port), redis.WithLogger(glog.New()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := New(pubsub.NewPublisher(100*time.Millisecond, 10), NewStreamTransport(client, "events", 0), WithNodeID("a"))
+	localB := pubsub.NewPublisher(100*time.Millisecond, 10)
+	b := New(localB, NewStreamTransport(client, "events", 0).StartFrom("0"), WithNodeID("b"))
+	go b.Run(ctx)
+	subB := localB.Subscribe()
+
+	if err := a.Publish(ctx, "one"); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(t, subB); v != "one" {
+		t.Fatalf("expected one but received %v", v)
+	}
+
+	// a failing read returns instead of spinning
+	transport := NewStreamTransport(client, "events", 0)
+	done := make(chan error, 1)
+	go func() {
+		done <- transport.Receive(ctx, func(data []byte) {})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error after the connection is lost")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected Receive to return after the connection is lost")
+	}
+
+	// Run reconnects and resumes after the last message read. A new server is
+	// started on the same address, a restarted miniredis never ends blocking reads
+	restarted := miniredis.NewMiniRedis()
+	if err := restarted.StartAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Publish(ctx, "two") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the send to succeed after the restart")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case v := <-subB:
+		if v != "two" {
+			t.Fatalf("expected two but received %v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a message after the restart")
+	}
+}
@@ -0,0 +1,130 @@
+package pubsub // import "github.com/docker/docker/pkg/pubsub"
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	topicSeparator = "/"
+	singleLevel    = "+"
+	multiLevel     = "#"
+)
+
+// ErrInvalidPattern is returned when a topic pattern is not a valid
+// MQTT-style filter.
+var ErrInvalidPattern = errors.New("pubsub: invalid topic pattern")
+
+// ValidatePattern checks that pattern is a valid MQTT-style topic filter.
+// Levels are separated by "/", "+" matches exactly one level and "#" matches
+// any number of trailing levels, including none. Wildcards must occupy a
+// whole level and "#" may only appear as the last level.
+func ValidatePattern(pattern string) error {
+	if pattern == "" {
+		return ErrInvalidPattern
+	}
+	levels := strings.Split(pattern, topicSeparator)
+	for i, level := range levels {
+		switch {
+		case level == multiLevel:
+			if i != len(levels)-1 {
+				return ErrInvalidPattern
+			}
+		case level == singleLevel:
+		case strings.ContainsAny(level, singleLevel+multiLevel):
+			return ErrInvalidPattern
+		}
+	}
+	return nil
+}
+
+// MatchTopic reports whether topic matches the MQTT-style pattern.
+// An invalid pattern never matches.
+func MatchTopic(pattern, topic string) bool {
+	if ValidatePattern(pattern) != nil {
+		return false
+	}
+	patterns := strings.Split(pattern, topicSeparator)
+	topics := strings.Split(topic, topicSeparator)
+	for i, level := range patterns {
+		if level == multiLevel {
+			return true
+		}
+		if i >= len(topics) {
+			return false
+		}
+		if level != singleLevel && level != topics[i] {
+			return false
+		}
+	}
+	return len(patterns) == len(topics)
+}
+
+// topicNode is a level of the subscription index. Publishing to a topic walks
+// one path per wildcard branch instead of evaluating every subscriber.
+type topicNode struct {
+	children map[string]*topicNode
+	// subscribers whose pattern ends at this level
+	exact map[subscriber]struct{}
+	// subscribers whose pattern ends with "#" after this level
+	rest map[subscriber]struct{}
+}
+
+func newTopicNode() *topicNode {
+	return &topicNode{children: make(map[string]*topicNode)}
+}
+
+func (n *topicNode) add(pattern string, sub subscriber) {
+	node := n
+	for _, level := range strings.Split(pattern, topicSeparator) {
+		if level == multiLevel {
+			if node.rest == nil {
+				node.rest = make(map[subscriber]struct{})
+			}
+			node.rest[sub] = struct{}{}
+			return
+		}
+		child, ok := node.children[level]
+		if !ok {
+			child = newTopicNode()
+			node.children[level] = child
+		}
+		node = child
+	}
+	if node.exact == nil {
+		node.exact = make(map[subscriber]struct{})
+	}
+	node.exact[sub] = struct{}{}
+}
+
+// remove deletes sub from the index and prunes empty levels. It returns
+// whether n is empty afterwards.
+func (n *topicNode) remove(levels []string, sub subscriber) bool {
+	if len(levels) == 0 {
+		delete(n.exact, sub)
+	} else if levels[0] == multiLevel {
+		delete(n.rest, sub)
+	} else if child, ok := n.children[levels[0]]; ok && child.remove(levels[1:], sub) {
+		delete(n.children, levels[0])
+	}
+	return len(n.children) == 0 && len(n.exact) == 0 && len(n.rest) == 0
+}
+
+// match adds the subscribers whose pattern matches the topic levels to subs.
+func (n *topicNode) match(levels []string, subs map[subscriber]struct{}) {
+	for sub := range n.rest {
+		subs[sub] = struct{}{}
+	}
+	if len(levels) == 0 {
+		for sub := range n.exact {
+			subs[sub] = struct{}{}
+		}
+		return
+	}
+	if child, ok := n.children[levels[0]]; ok {
+		child.match(levels[1:], subs)
+	}
+	if child, ok := n.children[singleLevel]; ok {
+		child.match(levels[1:], subs)
+	}
+}
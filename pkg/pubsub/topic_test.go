@@ -0,0 +1,111 @@
+package pubsub // import "github.com/docker/docker/pkg/pubsub"
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/b/c", "a/b", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"+/+", "a/b", true},
+		{"a/#/c", "a/b/c", false},
+		{"a/b+", "a/b+", false},
+	}
+	for _, c := range cases {
+		if got := MatchTopic(c.pattern, c.topic); got != c.match {
+			t.Fatalf("MatchTopic(%q, %q) = %v, expected %v", c.pattern, c.topic, got, c.match)
+		}
+	}
+}
+
+func TestSubscribePatternInvalid(t *testing.T) {
+	p := NewPublisher(100*time.Millisecond, 10)
+	for _, pattern := range []string{"", "a/#/b", "a/b#", "a+/b"} {
+		if _, err := p.SubscribePattern(pattern); err != ErrInvalidPattern {
+			t.Fatalf("expected ErrInvalidPattern for %q but received %v", pattern, err)
+		}
+	}
+}
+
+func TestPublishTopic(t *testing.T) {
+	p := NewPublisher(100*time.Millisecond, 10)
+	exact, _ := p.SubscribePattern("a/b/c")
+	single, _ := p.SubscribePattern("a/+/c")
+	multi, _ := p.SubscribePattern("a/#")
+	other, _ := p.SubscribePattern("x/#")
+	all := p.Subscribe()
+
+	p.PublishTopic("a/b/c", "hi")
+	for _, c := range []chan interface{}{exact, single, multi, all} {
+		if msg := <-c; msg.(string) != "hi" {
+			t.Fatalf("expected message hi but received %v", msg)
+		}
+	}
+	select {
+	case msg := <-other:
+		t.Fatalf("expected x/# to not receive the message but received %v", msg)
+	default:
+	}
+
+	// plain Publish does not reach pattern subscribers
+	p.Publish("plain")
+	if msg := <-all; msg.(string) != "plain" {
+		t.Fatalf("expected message plain but received %v", msg)
+	}
+	select {
+	case msg := <-multi:
+		t.Fatalf("expected a/# to not receive the message but received %v", msg)
+	default:
+	}
+}
+
+func TestEvictPattern(t *testing.T) {
+	p := NewPublisher(100*time.Millisecond, 10)
+	s1, _ := p.SubscribePattern("a/+")
+	s2, _ := p.SubscribePattern("a/+")
+	if p.Len() != 2 {
+		t.Fatalf("expected 2 subscribers but found %d", p.Len())
+	}
+
+	p.Evict(s1)
+	p.PublishTopic("a/b", "hi")
+	if _, ok := <-s1; ok {
+		t.Fatal("expected s1 to not receive the published message")
+	}
+	if msg := <-s2; msg.(string) != "hi" {
+		t.Fatalf("expected message hi but received %v", msg)
+	}
+
+	p.Evict(s2)
+	if p.Len() != 0 || len(p.topics.children) != 0 {
+		t.Fatal("expected the topic index to be empty")
+	}
+
+	s3, _ := p.SubscribePattern("#")
+	p.Close()
+	if _, ok := <-s3; ok {
+		t.Fatal("expected the subscriber channel to be closed")
+	}
+}
+
+func BenchmarkPublishTopic(b *testing.B) {
+	p := NewPublisher(0, 1024)
+	for i := 0; i < 1000; i++ {
+		p.SubscribePattern("devices/" + string(rune('a'+i%26)) + "/+")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.PublishTopic("devices/a/state", sampleText)
+	}
+}
@@ -0,0 +1,346 @@
+package pubsub // import "github.com/docker/docker/pkg/pubsub"
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DropPolicy decides what happens when a subscriber's buffer is full.
+type DropPolicy int
+
+const (
+	// Block waits for the subscriber to make room, up to the publisher's
+	// block timeout. The message is dropped when the timeout expires.
+	Block DropPolicy = iota
+	// DropNewest discards the message being published.
+	DropNewest
+	// DropOldest discards the oldest buffered message to make room, so the
+	// buffer behaves as a ring keeping the most recent messages.
+	DropOldest
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	}
+	return fmt.Sprintf("DropPolicy(%d)", int(p))
+}
+
+// SubscriberStats is a snapshot of the delivery counters of a subscriber.
+type SubscriberStats struct {
+	ID        uint64
+	Policy    DropPolicy
+	Delivered uint64 // messages put into the subscriber's buffer
+	Dropped   uint64 // messages discarded because the buffer was full
+	Pending   int    // messages buffered but not received yet
+	Capacity  int
+}
+
+// SlowSubscriberFunc is called after a subscriber drops a message.
+// Returning true evicts the subscriber.
+type SlowSubscriberFunc func(stats SubscriberStats) bool
+
+type typedConfig struct {
+	buffer       int
+	blockTimeout time.Duration
+	slow         SlowSubscriberFunc
+}
+
+// TypedOption configures a TypedPublisher.
+type TypedOption func(*typedConfig)
+
+// WithDefaultBuffer sets the buffer of subscribers that do not set their own.
+func WithDefaultBuffer(buffer int) TypedOption {
+	return func(c *typedConfig) {
+		c.buffer = buffer
+	}
+}
+
+// WithBlockTimeout bounds how long Publish waits for a subscriber using the
+// Block policy. Zero waits until the subscriber makes room or is evicted.
+func WithBlockTimeout(timeout time.Duration) TypedOption {
+	return func(c *typedConfig) {
+		c.blockTimeout = timeout
+	}
+}
+
+// WithSlowSubscriber sets the hook called when a subscriber drops a message.
+func WithSlowSubscriber(fn SlowSubscriberFunc) TypedOption {
+	return func(c *typedConfig) {
+		c.slow = fn
+	}
+}
+
+type subscriptionConfig[T any] struct {
+	buffer int
+	policy DropPolicy
+	filter func(v T) bool
+}
+
+// SubscribeOption configures a Subscription.
+type SubscribeOption[T any] func(*subscriptionConfig[T])
+
+// WithBuffer sets the buffer size of the subscription channel.
+func WithBuffer[T any](buffer int) SubscribeOption[T] {
+	return func(c *subscriptionConfig[T]) {
+		c.buffer = buffer
+	}
+}
+
+// WithPolicy sets the policy used when the subscription buffer is full.
+// The default is Block.
+func WithPolicy[T any](policy DropPolicy) SubscribeOption[T] {
+	return func(c *subscriptionConfig[T]) {
+		c.policy = policy
+	}
+}
+
+// WithFilter only delivers the messages accepted by filter.
+func WithFilter[T any](filter func(v T) bool) SubscribeOption[T] {
+	return func(c *subscriptionConfig[T]) {
+		c.filter = filter
+	}
+}
+
+// Subscription is a subscriber of a TypedPublisher.
+type Subscription[T any] struct {
+	id     uint64
+	ch     chan T
+	policy DropPolicy
+	filter func(v T) bool
+	// serializes the pop and push of DropOldest between concurrent publishes
+	mu        sync.Mutex
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	// closed on eviction, releases publishes blocked on the subscriber
+	gone     chan struct{}
+	goneOnce sync.Once
+}
+
+func (s *Subscription[T]) stop() {
+	s.goneOnce.Do(func() { close(s.gone) })
+}
+
+// C returns the channel messages are delivered on. It is closed when the
+// subscription is evicted or the publisher is closed.
+func (s *Subscription[T]) C() <-chan T {
+	return s.ch
+}
+
+// ID returns the identifier of the subscription, unique within its publisher.
+func (s *Subscription[T]) ID() uint64 {
+	return s.id
+}
+
+// Stats returns the delivery counters of the subscription.
+func (s *Subscription[T]) Stats() SubscriberStats {
+	return SubscriberStats{
+		ID:        s.id,
+		Policy:    s.policy,
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+		Pending:   len(s.ch),
+		Capacity:  cap(s.ch),
+	}
+}
+
+// send delivers v according to the policy and reports whether a message was
+// dropped.
+func (s *Subscription[T]) send(v T, timeout time.Duration, done <-chan struct{}) bool {
+	select {
+	case <-s.gone:
+		return false
+	default:
+	}
+	if s.filter != nil && !s.filter(v) {
+		return false
+	}
+	switch s.policy {
+	case DropNewest:
+		select {
+		case s.ch <- v:
+			s.delivered.Add(1)
+			return false
+		default:
+			s.dropped.Add(1)
+			return true
+		}
+	case DropOldest:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var dropped bool
+		for {
+			select {
+			case s.ch <- v:
+				s.delivered.Add(1)
+				return dropped
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+				dropped = true
+			default:
+			}
+		}
+	}
+
+	select {
+	case s.ch <- v:
+		s.delivered.Add(1)
+		return false
+	default:
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case s.ch <- v:
+		s.delivered.Add(1)
+		return false
+	case <-expired:
+	case <-done:
+	case <-s.gone:
+		return false
+	}
+	s.dropped.Add(1)
+	return true
+}
+
+// TypedPublisher is a pub/sub publisher for messages of type T. Each
+// subscriber chooses what happens when its buffer is full, and the publisher
+// keeps per-subscriber delivered and dropped counters.
+// Can be safely used from multiple goroutines.
+type TypedPublisher[T any] struct {
+	m           sync.RWMutex
+	cfg         typedConfig
+	seq         uint64
+	subscribers map[*Subscription[T]]struct{}
+	// closed when the publisher is closed, releases blocked publishes
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTypedPublisher creates a new pub/sub publisher for messages of type T.
+func NewTypedPublisher[T any](opts ...TypedOption) *TypedPublisher[T] {
+	p := &TypedPublisher[T]{
+		subscribers: make(map[*Subscription[T]]struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&p.cfg)
+	}
+	return p
+}
+
+// Len returns the number of subscribers for the publisher
+func (p *TypedPublisher[T]) Len() int {
+	p.m.RLock()
+	i := len(p.subscribers)
+	p.m.RUnlock()
+	return i
+}
+
+// Subscribe adds a new subscriber to the publisher.
+func (p *TypedPublisher[T]) Subscribe(opts ...SubscribeOption[T]) *Subscription[T] {
+	cfg := subscriptionConfig[T]{buffer: p.cfg.buffer}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.policy == DropOldest && cfg.buffer <= 0 {
+		// a ring needs room for at least one message
+		cfg.buffer = 1
+	}
+	sub := &Subscription[T]{
+		ch:     make(chan T, cfg.buffer),
+		policy: cfg.policy,
+		filter: cfg.filter,
+		gone:   make(chan struct{}),
+	}
+	p.m.Lock()
+	p.seq++
+	sub.id = p.seq
+	p.subscribers[sub] = struct{}{}
+	p.m.Unlock()
+	return sub
+}
+
+// Evict removes the specified subscriber from receiving any more messages.
+func (p *TypedPublisher[T]) Evict(sub *Subscription[T]) {
+	sub.stop()
+	p.m.Lock()
+	if _, exists := p.subscribers[sub]; exists {
+		delete(p.subscribers, sub)
+		close(sub.ch)
+	}
+	p.m.Unlock()
+}
+
+// Stats returns the delivery counters of all subscribers.
+func (p *TypedPublisher[T]) Stats() []SubscriberStats {
+	p.m.RLock()
+	stats := make([]SubscriberStats, 0, len(p.subscribers))
+	for sub := range p.subscribers {
+		stats = append(stats, sub.Stats())
+	}
+	p.m.RUnlock()
+	return stats
+}
+
+// Publish sends v to all subscribers currently registered with the publisher.
+// Subscribers the slow subscriber hook selects are evicted afterwards.
+func (p *TypedPublisher[T]) Publish(v T) {
+	p.m.RLock()
+	if len(p.subscribers) == 0 {
+		p.m.RUnlock()
+		return
+	}
+
+	var (
+		mu   sync.Mutex
+		slow []*Subscription[T]
+	)
+	wg := wgPool.Get().(*sync.WaitGroup)
+	for sub := range p.subscribers {
+		wg.Add(1)
+		go func(sub *Subscription[T]) {
+			defer wg.Done()
+			if !sub.send(v, p.cfg.blockTimeout, p.done) || p.cfg.slow == nil {
+				return
+			}
+			if p.cfg.slow(sub.Stats()) {
+				mu.Lock()
+				slow = append(slow, sub)
+				mu.Unlock()
+			}
+		}(sub)
+	}
+	wg.Wait()
+	wgPool.Put(wg)
+	p.m.RUnlock()
+
+	for _, sub := range slow {
+		p.Evict(sub)
+	}
+}
+
+// Close closes the channels to all subscribers registered with the publisher.
+func (p *TypedPublisher[T]) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
+	p.m.Lock()
+	for sub := range p.subscribers {
+		delete(p.subscribers, sub)
+		close(sub.ch)
+	}
+	p.m.Unlock()
+}
@@ -0,0 +1,153 @@
+package pubsub // import "github.com/docker/docker/pkg/pubsub"
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTypedDropNewest(t *testing.T) {
+	p := NewTypedPublisher[int]()
+	sub := p.Subscribe(WithBuffer[int](2), WithPolicy[int](DropNewest))
+	for i := 1; i <= 5; i++ {
+		p.Publish(i)
+	}
+	if v := <-sub.C(); v != 1 {
+		t.Fatalf("expected 1 but received %d", v)
+	}
+	if v := <-sub.C(); v != 2 {
+		t.Fatalf("expected 2 but received %d", v)
+	}
+	stats := sub.Stats()
+	if stats.Delivered != 2 || stats.Dropped != 3 {
+		t.Fatalf("expected 2 delivered and 3 dropped but found %+v", stats)
+	}
+}
+
+func TestTypedDropOldest(t *testing.T) {
+	p := NewTypedPublisher[int]()
+	sub := p.Subscribe(WithBuffer[int](2), WithPolicy[int](DropOldest))
+	for i := 1; i <= 5; i++ {
+		p.Publish(i)
+	}
+	if v := <-sub.C(); v != 4 {
+		t.Fatalf("expected 4 but received %d", v)
+	}
+	if v := <-sub.C(); v != 5 {
+		t.Fatalf("expected 5 but received %d", v)
+	}
+	stats := sub.Stats()
+	if stats.Delivered != 5 || stats.Dropped != 3 {
+		t.Fatalf("expected 5 delivered and 3 dropped but found %+v", stats)
+	}
+}
+
+func TestTypedBlockTimeout(t *testing.T) {
+	p := NewTypedPublisher[string](WithBlockTimeout(10 * time.Millisecond))
+	sub := p.Subscribe(WithBuffer[string](1))
+	p.Publish("a")
+	start := time.Now()
+	p.Publish("b")
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("expected publish to wait for the block timeout")
+	}
+	if stats := sub.Stats(); stats.Delivered != 1 || stats.Dropped != 1 {
+		t.Fatalf("expected 1 delivered and 1 dropped but found %+v", stats)
+	}
+}
+
+func TestTypedBlockReleasedByEvict(t *testing.T) {
+	p := NewTypedPublisher[string]()
+	sub := p.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		p.Publish("hi")
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	p.Evict(sub)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected evict to release the blocked publish")
+	}
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("expected the subscriber channel to be closed")
+	}
+}
+
+func TestTypedFilter(t *testing.T) {
+	p := NewTypedPublisher[int](WithDefaultBuffer(10))
+	even := p.Subscribe(WithFilter(func(v int) bool { return v%2 == 0 }))
+	for i := 1; i <= 4; i++ {
+		p.Publish(i)
+	}
+	p.Close()
+	var got []int
+	for v := range even.C() {
+		got = append(got, v)
+	}
+	if len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Fatalf("expected [2 4] but received %v", got)
+	}
+}
+
+func TestTypedSlowSubscriberEviction(t *testing.T) {
+	var mu sync.Mutex
+	var evicted []uint64
+	p := NewTypedPublisher[int](WithSlowSubscriber(func(stats SubscriberStats) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if stats.Dropped >= 2 {
+			evicted = append(evicted, stats.ID)
+			return true
+		}
+		return false
+	}))
+	slow := p.Subscribe(WithBuffer[int](1), WithPolicy[int](DropNewest))
+	fast := p.Subscribe(WithBuffer[int](10))
+	for i := 0; i < 3; i++ {
+		p.Publish(i)
+	}
+	if p.Len() != 1 {
+		t.Fatalf("expected 1 subscriber but found %d", p.Len())
+	}
+	if len(evicted) != 1 || evicted[0] != slow.ID() {
+		t.Fatalf("expected subscriber %d to be evicted but found %v", slow.ID(), evicted)
+	}
+	stats := p.Stats()
+	if len(stats) != 1 || stats[0].ID != fast.ID() || stats[0].Delivered != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// for testing with -race
+func TestTypedPubSubRace(t *testing.T) {
+	p := NewTypedPublisher[string](WithDefaultBuffer(16))
+	var wg sync.WaitGroup
+	for _, policy := range []DropPolicy{Block, DropNewest, DropOldest} {
+		for j := 0; j < 10; j++ {
+			sub := p.Subscribe(WithPolicy[string](policy))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for v := range sub.C() {
+					if v != sampleText {
+						t.Errorf("unexpected text %s", v)
+					}
+				}
+			}()
+		}
+	}
+	for j := 0; j < 4; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				p.Publish(sampleText)
+			}
+		}()
+	}
+	time.AfterFunc(100*time.Millisecond, p.Close)
+	wg.Wait()
+}
@@ -107,3 +107,10 @@ func WithTransportCredentials(credentials credentials.TransportCredentials) Opti
 		}
 	}
 }
+
+// WithoutHealth 不注册 grpc.health.v1.Health 服务，用于自行注册健康检查的场景
+func WithoutHealth() Option {
+	return func(s *Server) {
+		s.disableHealth = true
+	}
+}
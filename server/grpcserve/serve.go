@@ -15,6 +15,8 @@ import (
 	"github.com/shirou/gopsutil/host"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"math"
 	"net"
 	"os"
@@ -45,6 +47,8 @@ type Server struct {
 	environment     string
 	runMode         string
 	GServer         *grpc.Server
+	// Health grpc.health.v1.Health 服务，创建 GServer 时注册，优雅退出时全部变为 NOT_SERVING
+	Health *health.Server
 
 	credentials credentials.TransportCredentials
 
@@ -55,6 +59,8 @@ type Server struct {
 	readBufferSize        int
 
 	grpcOpts []grpc.ServerOption
+
+	disableHealth bool
 }
 
 func New(opts ...Option) *Server {
@@ -101,6 +107,10 @@ func New(opts ...Option) *Server {
 	}
 	server.grpcOpts = gOpts
 
+	if !server.disableHealth {
+		server.Health = health.NewServer()
+	}
+
 	//server.GServer = grpc.NewServer(gOpts...)
 
 	return server
@@ -155,9 +165,31 @@ func (s *Server) AddTrace(tracer *tracer.Server) *Server {
 
 func (s *Server) NewServer() *Server {
 	s.GServer = grpc.NewServer(s.grpcOpts...)
+	if s.Health != nil {
+		grpc_health_v1.RegisterHealthServer(s.GServer, s.Health)
+	}
 	return s
 }
 
+// SetServingStatus 设置服务的健康状态，service 为空时是整个服务器的状态
+func (s *Server) SetServingStatus(service string, status grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	if s.Health != nil {
+		s.Health.SetServingStatus(service, status)
+	}
+}
+
+// serving 服务器、ServiceName 和 GServer 上已注册的服务设为 SERVING
+func (s *Server) serving() {
+	if s.Health == nil {
+		return
+	}
+	s.Health.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	s.Health.SetServingStatus(s.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	for service := range s.GServer.GetServiceInfo() {
+		s.Health.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_SERVING)
+	}
+}
+
 func (s *Server) Run() {
 	if s.GServer == nil {
 		s.NewServer()
@@ -168,6 +200,7 @@ func (s *Server) Run() {
 		s.Logger.Error(err)
 		os.Exit(0)
 	}
+	s.serving()
 	s.registerDiscovery()
 	go func() {
 		if err := s.GServer.Serve(lis); err != nil && err != grpc.ErrServerStopped {
@@ -201,6 +234,10 @@ func (s *Server) Shutdown() {
 
 func (s *Server) stop() {
 	s.Logger.Info("Server is stopping")
+	if s.Health != nil {
+		// 先变为 NOT_SERVING，健康检查和客户端不再发送新的请求
+		s.Health.Shutdown()
+	}
 	if err := s.deregister(); err != nil {
 		s.Logger.Error("deregister http webserve error", err.Error())
 	}
@@ -0,0 +1,56 @@
+package grpcserve
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealth(t *testing.T) {
+	s := New(WithServiceName("demo")).NewServer()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go s.GServer.Serve(lis)
+	defer s.GServer.Stop()
+	s.serving()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+	check := func(service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+		resp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		return resp.GetStatus(), err
+	}
+
+	for _, service := range []string{"", "demo", grpc_health_v1.Health_ServiceDesc.ServiceName} {
+		st, err := check(service)
+		assert.Nil(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, st, service)
+	}
+	_, err = check("unknown")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	s.SetServingStatus("demo", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	st, _ := check("demo")
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, st)
+
+	// shutting down flips everything to NOT_SERVING before the server stops
+	s.Health.Shutdown()
+	st, _ = check("")
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, st)
+}
+
+func TestWithoutHealth(t *testing.T) {
+	s := New(WithoutHealth()).NewServer()
+	assert.Nil(t, s.Health)
+	_, ok := s.GServer.GetServiceInfo()[grpc_health_v1.Health_ServiceDesc.ServiceName]
+	assert.False(t, ok)
+}
@@ -17,11 +17,14 @@ import (
 	"github.com/soheilhy/cmux"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"math"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -48,6 +51,8 @@ type Server struct {
 	environment     string
 	runMode         string
 	GServer         *grpc.Server
+	// Health grpc.health.v1.Health 服务，创建 GServer 时注册，优雅退出时全部变为 NOT_SERVING
+	Health *health.Server
 
 	credentials credentials.TransportCredentials
 
@@ -59,6 +64,8 @@ type Server struct {
 
 	grpcOpts []grpc.ServerOption
 
+	disableHealth bool
+
 	HTTPListener net.Listener
 	GRPCListener net.Listener
 	httpServer   *http.Server
@@ -122,6 +129,10 @@ func New(opts ...Option) *Server {
 	}
 	server.grpcOpts = gOpts
 
+	if !server.disableHealth {
+		server.Health = health.NewServer()
+	}
+
 	//cfg.GServer = grpc.NewServer(gOpts...)
 
 	server.Router = http.NewServeMux()
@@ -199,9 +210,56 @@ func (s *Server) AddTrace(tracer *tracer.Server) *Server {
 
 func (s *Server) NewServer() *Server {
 	s.GServer = grpc.NewServer(s.grpcOpts...)
+	if s.Health != nil {
+		grpc_health_v1.RegisterHealthServer(s.GServer, s.Health)
+	}
 	return s
 }
 
+// SetServingStatus 设置服务的健康状态，service 为空时是整个服务器的状态
+func (s *Server) SetServingStatus(service string, status grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	if s.Health != nil {
+		s.Health.SetServingStatus(service, status)
+	}
+}
+
+// serving 服务器、ServiceName 和 GServer 上已注册的服务设为 SERVING
+func (s *Server) serving() {
+	if s.Health == nil {
+		return
+	}
+	s.Health.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	s.Health.SetServingStatus(s.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	for service := range s.GServer.GetServiceInfo() {
+		s.Health.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_SERVING)
+	}
+}
+
+// servingOnAccept GRPCRegisterFunc 注册服务后阻塞在 Serve，Serve 第一次 Accept 时服务已全部注册，此时设为 SERVING
+func (s *Server) servingOnAccept(listener net.Listener) net.Listener {
+	l := &acceptListener{Listener: listener, accepted: make(chan struct{})}
+	go func() {
+		select {
+		case <-l.accepted:
+			s.serving()
+		case <-s.Ctx.Done():
+		}
+	}()
+	return l
+}
+
+// acceptListener 第一次 Accept 时关闭 accepted
+type acceptListener struct {
+	net.Listener
+	once     sync.Once
+	accepted chan struct{}
+}
+
+func (l *acceptListener) Accept() (net.Conn, error) {
+	l.once.Do(func() { close(l.accepted) })
+	return l.Listener.Accept()
+}
+
 func (s *Server) Run() {
 	if s.GServer == nil {
 		s.NewServer()
@@ -214,7 +272,7 @@ func (s *Server) Run() {
 	}
 	s.tcpMux = cmux.New(listener)
 
-	s.GRPCListener = s.tcpMux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc"))
+	s.GRPCListener = s.servingOnAccept(s.tcpMux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc")))
 	s.HTTPListener = s.tcpMux.Match(cmux.HTTP1Fast())
 
 	go func() {
@@ -259,6 +317,10 @@ func (s *Server) Shutdown() {
 
 func (s *Server) stop() {
 	s.Logger.Info("Server is stopping")
+	if s.Health != nil {
+		// 先变为 NOT_SERVING，健康检查和客户端不再发送新的请求
+		s.Health.Shutdown()
+	}
 	if err := s.deregister(); err != nil {
 		s.Logger.Error("deregister http webserve error", err.Error())
 	}
@@ -0,0 +1,45 @@
+package http_grpc_serve
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestServingOnAccept(t *testing.T) {
+	s := New(WithServiceName("demo")).NewServer()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	listener := s.servingOnAccept(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+	check := func(service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+		resp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		return resp.GetStatus(), err
+	}
+
+	// 与 GRPCRegisterFunc 相同，先注册服务再 Serve
+	s.GServer.RegisterService(&grpc.ServiceDesc{ServiceName: "demo.Echo", HandlerType: (*interface{})(nil)}, struct{}{})
+	go s.GServer.Serve(listener)
+	defer s.GServer.Stop()
+
+	for _, service := range []string{"", "demo", "demo.Echo", grpc_health_v1.Health_ServiceDesc.ServiceName} {
+		assert.Eventually(t, func() bool {
+			st, err := check(service)
+			return err == nil && st == grpc_health_v1.HealthCheckResponse_SERVING
+		}, time.Second, 10*time.Millisecond, service)
+	}
+	_, err = check("unknown")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,408 @@
+package com_http
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+)
+
+// Strategy picks the instance a request is sent to.
+type Strategy int
+
+const (
+	// RoundRobin sends requests to the instances in turn.
+	RoundRobin Strategy = iota
+	// LeastOutstanding sends requests to the instance with the fewest requests in flight.
+	LeastOutstanding
+	// ConsistentHash sends requests with the same hash key to the same instance
+	// while the instances do not change.
+	ConsistentHash
+)
+
+// HashKeyHeader is the default hash key of ConsistentHash, the URL path is used when not set.
+const HashKeyHeader = "X-Hash-Key"
+
+// ErrNoInstances is returned when a service has no available instance.
+var ErrNoInstances = errors.New("com_http: no available instances")
+
+// TransportOption configures a DiscoveryTransport.
+type TransportOption func(t *DiscoveryTransport)
+
+// WithTransport specifies the transport requests are sent with, http.DefaultTransport by default.
+func WithTransport(base http.RoundTripper) TransportOption {
+	return func(t *DiscoveryTransport) {
+		t.base = base
+	}
+}
+
+// WithStrategy specifies how instances are picked, RoundRobin by default.
+func WithStrategy(strategy Strategy) TransportOption {
+	return func(t *DiscoveryTransport) {
+		t.strategy = strategy
+	}
+}
+
+// WithHashKey specifies the hash key of ConsistentHash.
+func WithHashKey(fn func(req *http.Request) string) TransportOption {
+	return func(t *DiscoveryTransport) {
+		t.hashKey = fn
+	}
+}
+
+// WithRetries specifies how many other instances an idempotent request is
+// retried on after a connection failure, 2 by default.
+func WithRetries(retries int) TransportOption {
+	return func(t *DiscoveryTransport) {
+		t.retries = retries
+	}
+}
+
+// WithInstanceFilters specifies the filters instances must pass, HealthyFilter by default.
+func WithInstanceFilters(filters ...servicediscovery.Filter) TransportOption {
+	return func(t *DiscoveryTransport) {
+		t.filters = filters
+	}
+}
+
+// WithSelectors specifies the selectors applied to the instances passing the
+// filters for every request, e.g. servicediscovery.ZoneSelector. The route
+// headers of the request override the route of its context.
+func WithSelectors(selectors ...servicediscovery.Selector) TransportOption {
+	return func(t *DiscoveryTransport) {
+		t.selectors = selectors
+	}
+}
+
+// WithServices only resolves the listed hosts, requests to other hosts are
+// sent as is. By default hosts without a dot other than localhost are resolved.
+func WithServices(services ...string) TransportOption {
+	return func(t *DiscoveryTransport) {
+		t.services = make(map[string]bool, len(services))
+		for _, service := range services {
+			t.services[service] = true
+		}
+	}
+}
+
+// WithResolveTimeout specifies how long the first request to a service waits
+// for its instances to be discovered, 3 seconds by default.
+func WithResolveTimeout(timeout time.Duration) TransportOption {
+	return func(t *DiscoveryTransport) {
+		t.resolveTimeout = timeout
+	}
+}
+
+// DiscoveryTransport is a http.RoundTripper resolving http://service-name/path
+// through a servicediscovery.Watcher, e.g.
+//
+//	client := NewHTTPClient(&http.Client{Transport: NewDiscoveryTransport(consulClient.Watch)})
+type DiscoveryTransport struct {
+	watch          servicediscovery.WatchFunc
+	base           http.RoundTripper
+	strategy       Strategy
+	hashKey        func(req *http.Request) string
+	retries        int
+	filters        []servicediscovery.Filter
+	selectors      []servicediscovery.Selector
+	services       map[string]bool
+	resolveTimeout time.Duration
+
+	locker   sync.Mutex
+	watchers map[string]*serviceWatcher
+}
+
+// NewDiscoveryTransport returns a transport resolving services with watch.
+func NewDiscoveryTransport(watch servicediscovery.WatchFunc, options ...TransportOption) *DiscoveryTransport {
+	t := &DiscoveryTransport{
+		watch:          watch,
+		base:           http.DefaultTransport,
+		retries:        2,
+		filters:        []servicediscovery.Filter{servicediscovery.HealthyFilter()},
+		resolveTimeout: 3 * time.Second,
+		watchers:       make(map[string]*serviceWatcher),
+	}
+	t.hashKey = func(req *http.Request) string {
+		if key := req.Header.Get(HashKeyHeader); len(key) > 0 {
+			return key
+		}
+		return req.URL.Path
+	}
+	for _, f := range options {
+		f(t)
+	}
+	return t
+}
+
+// NewDiscoveryHTTPClient returns a http client resolving services with watch.
+func NewDiscoveryHTTPClient(watch servicediscovery.WatchFunc, options ...TransportOption) HTTPClient {
+	return NewHTTPClient(&http.Client{Transport: NewDiscoveryTransport(watch, options...)})
+}
+
+func (t *DiscoveryTransport) resolvable(host string) bool {
+	if t.services != nil {
+		return t.services[host]
+	}
+	return host != "localhost" && !strings.ContainsAny(host, ".:")
+}
+
+func (t *DiscoveryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Port() != "" || !t.resolvable(req.URL.Hostname()) {
+		return t.base.RoundTrip(req)
+	}
+	sw, err := t.service(req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if err = sw.wait(req.Context(), t.resolveTimeout); err != nil {
+		return nil, err
+	}
+
+	route := servicediscovery.WithRoute(req.Context(), servicediscovery.RouteFromHeader(req.Header))
+	selected := servicediscovery.Select(route, sw.instances.List(t.filters...), t.selectors...)
+	tried := make(map[string]bool)
+	for attempt := 0; ; attempt++ {
+		instance := sw.pick(t.strategy, t.hashKey(req), tried, selected)
+		if instance == nil {
+			if err == nil {
+				err = ErrNoInstances
+			}
+			return nil, err
+		}
+		tried[instance.GetId()] = true
+
+		out, berr := rewind(req, attempt)
+		if berr != nil {
+			return nil, berr
+		}
+		out.URL.Host = net.JoinHostPort(instance.GetHost(), strconv.FormatUint(instance.GetPort(), 10))
+
+		done := sw.start(instance)
+		var resp *http.Response
+		resp, err = t.base.RoundTrip(out)
+		if err == nil {
+			resp.Body = &doneBody{ReadCloser: resp.Body, done: done}
+			return resp, nil
+		}
+		done()
+		if attempt >= t.retries || req.Context().Err() != nil || !retryable(req) {
+			return nil, err
+		}
+	}
+}
+
+// rewind clones req for an attempt, the body is read again for retries.
+func rewind(req *http.Request, attempt int) (*http.Request, error) {
+	out := req.Clone(req.Context())
+	if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+	return out, nil
+}
+
+// retryable reports whether req may be sent again after a connection failure.
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// Close stops watching the services.
+func (t *DiscoveryTransport) Close() {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	for name, sw := range t.watchers {
+		sw.watcher.Stop()
+		delete(t.watchers, name)
+	}
+}
+
+func (t *DiscoveryTransport) service(name string) (*serviceWatcher, error) {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	if sw, ok := t.watchers[name]; ok {
+		return sw, nil
+	}
+	watcher, err := t.watch(servicediscovery.WatchService(name))
+	if err != nil {
+		return nil, err
+	}
+	sw := &serviceWatcher{
+		watcher:   watcher,
+		instances: servicediscovery.NewInstances(name),
+		ready:     make(chan struct{}),
+		inflight:  make(map[string]*int64),
+	}
+	go t.run(name, sw)
+	t.watchers[name] = sw
+	return sw, nil
+}
+
+type serviceWatcher struct {
+	watcher   servicediscovery.Watcher
+	instances *servicediscovery.Instances
+	// closed once the first instances are known or the first wait timed out
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	locker   sync.Mutex
+	next     uint64
+	inflight map[string]*int64
+	ring     []ringNode
+	ringKey  string
+}
+
+type ringNode struct {
+	hash     uint32
+	instance servicediscovery.ServiceInstance
+}
+
+// run applies the watch results of name. When the watcher fails it is
+// stopped and removed, the next request for name watches the service again.
+func (t *DiscoveryTransport) run(name string, sw *serviceWatcher) {
+	for {
+		result, err := sw.watcher.Next()
+		if err != nil {
+			t.locker.Lock()
+			// already removed by Close
+			if t.watchers[name] == sw {
+				sw.watcher.Stop()
+				delete(t.watchers, name)
+			}
+			t.locker.Unlock()
+			return
+		}
+		sw.instances.Apply(result)
+		if len(sw.instances.List()) > 0 {
+			sw.readyOnce.Do(func() { close(sw.ready) })
+		}
+	}
+}
+
+func (sw *serviceWatcher) wait(ctx context.Context, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-sw.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		// do not wait again for a service without instances
+		sw.readyOnce.Do(func() { close(sw.ready) })
+		return ErrNoInstances
+	}
+}
+
+// start counts a request in flight on instance until the returned func is called.
+func (sw *serviceWatcher) start(instance servicediscovery.ServiceInstance) func() {
+	sw.locker.Lock()
+	count, ok := sw.inflight[instance.GetId()]
+	if !ok {
+		count = new(int64)
+		sw.inflight[instance.GetId()] = count
+	}
+	sw.locker.Unlock()
+	atomic.AddInt64(count, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { atomic.AddInt64(count, -1) })
+	}
+}
+
+// pick picks one of all the instances not tried yet.
+func (sw *serviceWatcher) pick(strategy Strategy, key string, tried map[string]bool, all []servicediscovery.ServiceInstance) servicediscovery.ServiceInstance {
+	instances := make([]servicediscovery.ServiceInstance, 0, len(all))
+	for _, instance := range all {
+		if !tried[instance.GetId()] {
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) == 0 {
+		return nil
+	}
+	sw.locker.Lock()
+	defer sw.locker.Unlock()
+	switch strategy {
+	case LeastOutstanding:
+		var best servicediscovery.ServiceInstance
+		var min int64
+		for _, instance := range instances {
+			var n int64
+			if count, ok := sw.inflight[instance.GetId()]; ok {
+				n = atomic.LoadInt64(count)
+			}
+			if best == nil || n < min {
+				best, min = instance, n
+			}
+		}
+		return best
+	case ConsistentHash:
+		return sw.hash(all, key, tried)
+	}
+	instance := instances[sw.next%uint64(len(instances))]
+	sw.next++
+	return instance
+}
+
+// ringReplicas is the number of points of an instance on the hash ring.
+const ringReplicas = 64
+
+// hash picks the first instance not tried after key on the ring of all
+// instances, so failed instances move their keys to the next instance only.
+func (sw *serviceWatcher) hash(instances []servicediscovery.ServiceInstance, key string, tried map[string]bool) servicediscovery.ServiceInstance {
+	ids := make([]string, len(instances))
+	for i, instance := range instances {
+		ids[i] = instance.GetId() + "@" + instance.GetHost() + ":" + strconv.FormatUint(instance.GetPort(), 10)
+	}
+	if ringKey := strings.Join(ids, ","); ringKey != sw.ringKey {
+		ring := make([]ringNode, 0, len(instances)*ringReplicas)
+		for _, instance := range instances {
+			for i := 0; i < ringReplicas; i++ {
+				ring = append(ring, ringNode{hash: crc32.ChecksumIEEE([]byte(instance.GetId() + "#" + strconv.Itoa(i))), instance: instance})
+			}
+		}
+		sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+		sw.ring, sw.ringKey = ring, ringKey
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(sw.ring), func(i int) bool { return sw.ring[i].hash >= h })
+	for i := 0; i < len(sw.ring); i++ {
+		node := sw.ring[(start+i)%len(sw.ring)]
+		if !tried[node.instance.GetId()] {
+			return node.instance
+		}
+	}
+	return nil
+}
+
+// doneBody ends the request in flight when the response body is closed.
+type doneBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *doneBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
@@ -0,0 +1,236 @@
+package com_http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/pkg/discovery/servicediscovery"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeWatcher struct {
+	next chan *servicediscovery.Result
+	exit chan struct{}
+	fail chan struct{}
+}
+
+func (w *fakeWatcher) Next() (*servicediscovery.Result, error) {
+	select {
+	case <-w.exit:
+		return nil, errors.New("watcher stopped")
+	case <-w.fail:
+		return nil, errors.New("watch failed")
+	case r := <-w.next:
+		return r, nil
+	}
+}
+
+func (w *fakeWatcher) Stop() {
+	close(w.exit)
+}
+
+func newInstance(id, addr string) servicediscovery.ServiceInstance {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.ParseUint(port, 10, 64)
+	return &servicediscovery.DefaultServiceInstance{Id: id, ServiceName: "svc", Host: host, Port: p, Enable: true, Healthy: true}
+}
+
+func newServer(t *testing.T, name string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(name + ":" + string(body)))
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+// deadAddr returns an address nothing listens on.
+func deadAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+func newDiscoveryClient(t *testing.T, instances []servicediscovery.ServiceInstance, options ...TransportOption) HTTPClient {
+	watcher := &fakeWatcher{next: make(chan *servicediscovery.Result, 1), exit: make(chan struct{})}
+	watcher.next <- &servicediscovery.Result{Action: "update", Service: &servicediscovery.Service{Name: "svc", Nodes: instances}}
+	transport := NewDiscoveryTransport(func(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+		return watcher, nil
+	}, options...)
+	t.Cleanup(transport.Close)
+	return NewHTTPClient(&http.Client{Transport: transport})
+}
+
+func call(t *testing.T, client HTTPClient, method, path string, body string, options ...Option) (string, error) {
+	resp, err := client.Do(context.Background(), method, "http://svc"+path, []byte(body), options...)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return string(data), nil
+}
+
+func TestDiscoveryRoundRobin(t *testing.T) {
+	client := newDiscoveryClient(t, []servicediscovery.ServiceInstance{
+		newInstance("a", newServer(t, "a")),
+		newInstance("b", newServer(t, "b")),
+	})
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		body, err := call(t, client, http.MethodGet, "/", "")
+		assert.Nil(t, err)
+		counts[body]++
+	}
+	assert.Equal(t, map[string]int{"a:": 5, "b:": 5}, counts)
+}
+
+func TestDiscoveryRewatch(t *testing.T) {
+	addr := newServer(t, "a")
+	var watchers []*fakeWatcher
+	transport := NewDiscoveryTransport(func(opts ...servicediscovery.WatchOption) (servicediscovery.Watcher, error) {
+		watcher := &fakeWatcher{next: make(chan *servicediscovery.Result, 1), exit: make(chan struct{}), fail: make(chan struct{})}
+		watcher.next <- &servicediscovery.Result{Action: "update", Service: &servicediscovery.Service{Name: "svc", Nodes: []servicediscovery.ServiceInstance{newInstance("a", addr)}}}
+		watchers = append(watchers, watcher)
+		return watcher, nil
+	})
+	t.Cleanup(transport.Close)
+	client := NewHTTPClient(&http.Client{Transport: transport})
+
+	body, err := call(t, client, http.MethodGet, "/", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "a:", body)
+
+	// a failed watcher is stopped and removed
+	close(watchers[0].fail)
+	assert.Eventually(t, func() bool {
+		select {
+		case <-watchers[0].exit:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	body, err = call(t, client, http.MethodGet, "/", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "a:", body)
+	assert.Len(t, watchers, 2)
+}
+
+func TestDiscoveryRetryIdempotent(t *testing.T) {
+	client := newDiscoveryClient(t, []servicediscovery.ServiceInstance{
+		newInstance("a", deadAddr(t)),
+		newInstance("b", newServer(t, "b")),
+	})
+	for i := 0; i < 4; i++ {
+		body, err := call(t, client, http.MethodPut, "/", "data")
+		assert.Nil(t, err)
+		assert.Equal(t, "b:data", body)
+	}
+
+	// POST is not retried unless it carries an idempotency key
+	var failed int
+	for i := 0; i < 4; i++ {
+		if _, err := call(t, client, http.MethodPost, "/", "data"); err != nil {
+			failed++
+		}
+	}
+	assert.Equal(t, 2, failed)
+	for i := 0; i < 4; i++ {
+		body, err := call(t, client, http.MethodPost, "/", "data", WithHeader("Idempotency-Key", "1"))
+		assert.Nil(t, err)
+		assert.Equal(t, "b:data", body)
+	}
+}
+
+func TestDiscoveryConsistentHash(t *testing.T) {
+	client := newDiscoveryClient(t, []servicediscovery.ServiceInstance{
+		newInstance("a", newServer(t, "a")),
+		newInstance("b", newServer(t, "b")),
+		newInstance("c", newServer(t, "c")),
+	}, WithStrategy(ConsistentHash))
+	for _, path := range []string{"/1", "/2", "/3", "/4"} {
+		first, err := call(t, client, http.MethodGet, path, "")
+		assert.Nil(t, err)
+		for i := 0; i < 3; i++ {
+			body, _ := call(t, client, http.MethodGet, path, "")
+			assert.Equal(t, first, body)
+		}
+	}
+}
+
+func TestDiscoveryLeastOutstanding(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("slow:"))
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	client := newDiscoveryClient(t, []servicediscovery.ServiceInstance{
+		newInstance("a", slow.Listener.Addr().String()),
+		newInstance("b", newServer(t, "b")),
+	}, WithStrategy(LeastOutstanding))
+
+	// the first request is held by the slow instance
+	go call(t, client, http.MethodGet, "/", "")
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		body, err := call(t, client, http.MethodGet, "/", "")
+		assert.Nil(t, err)
+		assert.Equal(t, "b:", body)
+	}
+}
+
+func TestDiscoverySelectors(t *testing.T) {
+	zoned := func(id, zone, version string) servicediscovery.ServiceInstance {
+		instance := newInstance(id, newServer(t, id)).(*servicediscovery.DefaultServiceInstance)
+		instance.Metadata = map[string]string{servicediscovery.MetadataZone: zone, servicediscovery.MetadataVersion: version}
+		return instance
+	}
+	client := newDiscoveryClient(t, []servicediscovery.ServiceInstance{
+		zoned("a", "a", "v1"),
+		zoned("b", "b", "v1"),
+		zoned("c", "a", "v2"),
+	}, WithSelectors(servicediscovery.ZoneSelector("a", 1), servicediscovery.VersionSelector("")))
+
+	counts := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		body, err := call(t, client, http.MethodGet, "/", "")
+		assert.Nil(t, err)
+		counts[body]++
+	}
+	assert.Equal(t, map[string]int{"a:": 2, "c:": 2}, counts)
+
+	body, err := call(t, client, http.MethodGet, "/", "", WithHeader(servicediscovery.RouteZoneHeader, "b"))
+	assert.Nil(t, err)
+	assert.Equal(t, "b:", body)
+	body, err = call(t, client, http.MethodGet, "/", "", WithHeader(servicediscovery.RouteVersionHeader, "v2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "c:", body)
+	_, err = call(t, client, http.MethodGet, "/", "", WithHeader(servicediscovery.RouteVersionHeader, "v3"))
+	assert.ErrorIs(t, err, ErrNoInstances)
+}
+
+func TestDiscoveryPassThrough(t *testing.T) {
+	addr := newServer(t, "direct")
+	client := newDiscoveryClient(t, nil, WithResolveTimeout(10*time.Millisecond))
+	resp, err := client.Do(context.Background(), http.MethodGet, "http://"+addr+"/", nil)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	_, err = call(t, client, http.MethodGet, "/", "")
+	assert.Equal(t, ErrNoInstances, errors.Unwrap(err))
+}